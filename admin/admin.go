@@ -0,0 +1,163 @@
+// Package admin implements the Admin service, through which operators change
+// the log levels and feature flags of a running service, inspect its
+// configuration and query its audit log. It is served on its own listener,
+// normally bound to loopback, and guarded by the authorization policy of the
+// service.
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+
+	"grpc-test/audit"
+	"grpc-test/features"
+	"grpc-test/lib"
+	"grpc-test/logging"
+	pb "grpc-test/proto"
+)
+
+// Config of the Admin listener.
+type Config struct {
+	// Addr is the address of the Admin service, it is not served when empty.
+	Addr string `json:"addr"`
+}
+
+// Redacted replaces the values of secret settings in config dumps.
+const Redacted = "REDACTED"
+
+// Service implements pb.AdminServer.
+type Service struct {
+	pb.UnimplementedAdminServer
+	name   string
+	logs   *logging.Control
+	flags  *features.Set
+	audit  *audit.Log
+	config any
+}
+
+// NewService returns the Admin service of the named service. auditLog may be
+// nil, config is dumped as JSON by GetConfig.
+func NewService(name string, logs *logging.Control, flags *features.Set, auditLog *audit.Log, config any) *Service {
+	return &Service{name: name, logs: logs, flags: flags, audit: auditLog, config: config}
+}
+
+func (s *Service) GetLogLevels(context.Context, *pb.Empty) (*pb.LogLevels, error) {
+	return s.logLevels(), nil
+}
+
+func (s *Service) SetLogLevel(ctx context.Context, req *pb.SetLogLevelRequest) (*pb.LogLevels, error) {
+	if req.Level == "" {
+		if req.Package == "" {
+			return nil, lib.ErrBadRequest().WithMessage("the default level cannot be reset")
+		}
+		s.logs.ResetLevel(req.Package)
+		logging.FromContext(ctx).Warn("Log level reset", slog.String("package", req.Package))
+		return s.logLevels(), nil
+	}
+	level, err := logging.ParseLevel(req.Level)
+	if err != nil {
+		return nil, lib.ErrBadRequest().WithMessage(err.Error())
+	}
+	s.logs.SetLevel(req.Package, level)
+	logging.FromContext(ctx).Warn("Log level changed", slog.String("package", req.Package), slog.String("level", level.String()))
+	return s.logLevels(), nil
+}
+
+func (s *Service) SetDebug(ctx context.Context, req *pb.SetDebugRequest) (*pb.LogLevels, error) {
+	s.logs.SetDebug(req.Debug)
+	logging.FromContext(ctx).Warn("Debug mode changed", slog.Bool("debug", req.Debug))
+	return s.logLevels(), nil
+}
+
+func (s *Service) ListFlags(context.Context, *pb.Empty) (*pb.FeatureFlags, error) {
+	return s.featureFlags(), nil
+}
+
+func (s *Service) SetFlag(_ context.Context, req *pb.SetFlagRequest) (*pb.FeatureFlags, error) {
+	if err := s.flags.Set(req.Name, req.Enabled); err != nil {
+		return nil, lib.ErrNotFound().WithMessage(err.Error())
+	}
+	return s.featureFlags(), nil
+}
+
+func (s *Service) GetConfig(context.Context, *pb.Empty) (*pb.ConfigDump, error) {
+	data, err := Dump(s.config)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ConfigDump{Service: s.name, Json: string(data)}, nil
+}
+
+func (s *Service) QueryAudit(_ context.Context, req *pb.QueryAuditRequest) (*pb.AuditRecords, error) {
+	if s.audit == nil {
+		return nil, lib.ErrNotFound().WithMessage(s.name + " keeps no audit log, see -audit-file")
+	}
+	records, err := s.audit.Query(audit.FilterFromProto(req))
+	if err != nil {
+		return nil, err
+	}
+	return audit.ToProto(records), nil
+}
+
+func (s *Service) logLevels() *pb.LogLevels {
+	level, packages := s.logs.Levels()
+	resp := &pb.LogLevels{Level: level.String(), Packages: map[string]string{}, Debug: s.logs.Debug()}
+	for pkg, l := range packages {
+		resp.Packages[pkg] = l.String()
+	}
+	return resp
+}
+
+func (s *Service) featureFlags() *pb.FeatureFlags {
+	resp := &pb.FeatureFlags{}
+	for _, f := range s.flags.List() {
+		resp.Flags = append(resp.Flags, &pb.FeatureFlag{Name: f.Name, Description: f.Description, Enabled: f.Enabled()})
+	}
+	return resp
+}
+
+// Dump encodes config as indented JSON with the values of secret settings
+// replaced by Redacted.
+func Dump(config any) ([]byte, error) {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(redact(v), "", "  ")
+}
+
+func redact(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			if secret(key) && value != "" && value != nil {
+				v[key] = Redacted
+				continue
+			}
+			v[key] = redact(value)
+		}
+	case []any:
+		for i := range v {
+			v[i] = redact(v[i])
+		}
+	}
+	return v
+}
+
+// secret reports whether a setting holds a secret by its name, e.g.
+// "signing_secret" or "api_token". Paths such as "key_file" are not secret.
+func secret(key string) bool {
+	key = strings.ToLower(key)
+	for _, word := range []string{"secret", "password", "token"} {
+		if strings.Contains(key, word) {
+			return true
+		}
+	}
+	return strings.HasSuffix(key, "_key") || key == "key"
+}
@@ -0,0 +1,298 @@
+// Package audit keeps a tamper-evident trail of payment actions and
+// authorization decisions. Records are appended to a Store and chained by an
+// HMAC, each one covering the HMAC of the record before it, so that changing,
+// removing or reordering any record breaks every HMAC after it. Without the
+// key, a log cannot be rewritten into a chain that verifies. Verify checks a
+// chain, and a Head kept apart from the log shows whether it was cut short.
+//
+// The calls to Methods are recorded by an interceptor, and authorization
+// decisions by the Log as an authz.Auditor. Charges are the only payment
+// actions so far: there is no refund or top-up RPC yet.
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"grpc-test/authz"
+	"grpc-test/clock"
+	"grpc-test/logging"
+	"grpc-test/tlsconfig"
+
+	"github.com/revotech-group/go-lib/errors"
+	"google.golang.org/protobuf/proto"
+)
+
+// Config enables the audit log.
+type Config struct {
+	// File is the JSON Lines file records are appended to, the audit log is
+	// off when empty.
+	File string `json:"file"`
+	// KeyFile holds the secret key of the HMACs, required with File. It
+	// should not be readable by whoever may change the log.
+	KeyFile string `json:"key_file"`
+	// HeadFile receives the sequence number and HMAC of the last record after
+	// every append, optional. Kept on other storage than File, it shows
+	// whether the log was cut short.
+	HeadFile string `json:"head_file"`
+}
+
+// MinKeySize is the smallest key accepted, in bytes.
+const MinKeySize = 32
+
+// ReadKey reads a key from file, ignoring surrounding whitespace.
+func ReadKey(file string) ([]byte, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read audit key: %w", err)
+	}
+	key := bytes.TrimSpace(data)
+	if len(key) < MinKeySize {
+		return nil, fmt.Errorf("audit key in %s has %d bytes, want at least %d", file, len(key), MinKeySize)
+	}
+	return key, nil
+}
+
+// Kinds of records.
+const (
+	Charge = "charge"
+	Authz  = "authz"
+)
+
+// Outcomes besides the names of AppErrors.
+const (
+	// Attempted is recorded before a payment action runs, and followed by
+	// the record of its outcome.
+	Attempted = "attempted"
+	OK        = "ok"
+	Allowed   = "allowed"
+	Denied    = "denied"
+)
+
+// Unaudited are the methods whose authorization decisions are not recorded.
+var Unaudited = []string{"/grpc.health.v1.Health/*"}
+
+// Record is an entry of the audit log. Seq, PrevHash and Hash are set by the
+// store when it is appended.
+type Record struct {
+	Seq        int64     `json:"seq"`
+	Time       time.Time `json:"time"`
+	Kind       string    `json:"kind"`
+	Service    string    `json:"service"`
+	Method     string    `json:"method,omitempty"`
+	Actor      string    `json:"actor"`
+	RequestID  string    `json:"request_id,omitempty"`
+	CustomerID string    `json:"customer_id,omitempty"`
+	OrderID    string    `json:"order_id,omitempty"`
+	Amount     float64   `json:"amount,omitempty"`
+	Outcome    string    `json:"outcome"`
+	Reason     string    `json:"reason,omitempty"`
+	PrevHash   string    `json:"prev_hash"`
+	Hash       string    `json:"hash"`
+}
+
+// Digest is the hash of r: the hex HMAC-SHA256 with key of its JSON encoding
+// without Hash. It covers PrevHash, which links r to the record before it.
+func (r Record) Digest(key []byte) string {
+	r.Hash = ""
+	data, _ := json.Marshal(r)
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// chain sets the fields that link r after the record with seq and hash.
+func (r *Record) chain(seq int64, hash string, key []byte) {
+	r.Seq, r.PrevHash = seq+1, hash
+	r.Hash = r.Digest(key)
+}
+
+// Store is where records are kept. Append chains a record after the last one
+// and persists it before returning.
+type Store interface {
+	Append(r *Record) error
+	Query(f Filter) ([]Record, error)
+	Close() error
+}
+
+// Filter selects records. Empty fields match every record.
+type Filter struct {
+	Kind       string
+	Actor      string
+	CustomerID string
+	OrderID    string
+	Outcome    string
+	Since      time.Time
+	Until      time.Time
+	// Limit keeps the newest records up to Limit, 100 if 0.
+	Limit int
+}
+
+// Match reports whether r passes the filter.
+func (f Filter) Match(r Record) bool {
+	return (f.Kind == "" || r.Kind == f.Kind) &&
+		(f.Actor == "" || r.Actor == f.Actor) &&
+		(f.CustomerID == "" || r.CustomerID == f.CustomerID) &&
+		(f.OrderID == "" || r.OrderID == f.OrderID) &&
+		(f.Outcome == "" || r.Outcome == f.Outcome) &&
+		(f.Since.IsZero() || !r.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || r.Time.Before(f.Until))
+}
+
+// limit keeps the newest records up to the limit of f.
+func (f Filter) limit(records []Record) []Record {
+	limit := f.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+	if len(records) > limit {
+		records = records[len(records)-limit:]
+	}
+	return records
+}
+
+// BrokenChainError reports the first record of a chain that does not verify.
+type BrokenChainError struct {
+	Seq    int64
+	Reason string
+}
+
+func (e *BrokenChainError) Error() string {
+	return fmt.Sprintf("audit chain broken at record %d: %s", e.Seq, e.Reason)
+}
+
+// Verify checks that records form a chain under key from the first record on.
+func Verify(records []Record, key []byte) error {
+	var seq int64
+	var hash string
+	for _, r := range records {
+		switch {
+		case r.Seq != seq+1:
+			return &BrokenChainError{Seq: r.Seq, Reason: fmt.Sprintf("expected record %d", seq+1)}
+		case r.PrevHash != hash:
+			return &BrokenChainError{Seq: r.Seq, Reason: "previous hash does not match"}
+		case !hmac.Equal([]byte(r.Hash), []byte(r.Digest(key))):
+			return &BrokenChainError{Seq: r.Seq, Reason: "hash does not match contents"}
+		}
+		seq, hash = r.Seq, r.Hash
+	}
+	return nil
+}
+
+// Head identifies the last record of a log.
+type Head struct {
+	Seq  int64  `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+// Check reports an error unless records, which form a chain, contain the
+// record of h. Records after it are fine, as long as they chain.
+func (h Head) Check(records []Record) error {
+	if h.Seq == 0 {
+		return nil
+	}
+	if int64(len(records)) < h.Seq {
+		return fmt.Errorf("audit log ends at record %d before its head %d, it was cut short", len(records), h.Seq)
+	}
+	if r := records[h.Seq-1]; r.Hash != h.Hash {
+		return &BrokenChainError{Seq: r.Seq, Reason: "hash does not match the head, the log was replaced"}
+	}
+	return nil
+}
+
+// Log records the payment actions of a service. A nil Log records nothing.
+type Log struct {
+	service string
+	store   Store
+	clock   clock.Clock
+
+	// mu keeps Time in the order of the chain.
+	mu sync.Mutex
+}
+
+// NewLog returns the audit log of the named service.
+func NewLog(service string, store Store, clk clock.Clock) *Log {
+	return &Log{service: service, store: store, clock: clk}
+}
+
+// Append records r, filling in its time, service, actor and request ID.
+func (l *Log) Append(ctx context.Context, r Record) error {
+	if l == nil {
+		return nil
+	}
+	r.Service = l.service
+	if r.Actor == "" {
+		r.Actor = actor(ctx)
+	}
+	if r.RequestID == "" {
+		r.RequestID = logging.RequestID(ctx)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	r.Time = l.clock.Now().UTC()
+	if err := l.store.Append(&r); err != nil {
+		logging.FromContext(ctx).Error("Failed to write audit record", slog.String("kind", r.Kind), slog.String("outcome", r.Outcome), slog.Any("error", err))
+		return err
+	}
+	return nil
+}
+
+// Record records an authorization decision. A decision that cannot be written
+// is only logged: the payment actions it allows fail on their own record.
+func (l *Log) Record(ctx context.Context, d authz.Decision) {
+	for _, pattern := range Unaudited {
+		if ok, _ := path.Match(pattern, d.Method); ok {
+			return
+		}
+	}
+	r := Record{Kind: Authz, Method: d.Method, Actor: d.Principal, Outcome: Allowed, Reason: d.Reason}
+	if !d.Allowed {
+		r.Outcome = Denied
+	}
+	l.Append(ctx, r)
+}
+
+// Query returns the records matching f, oldest first.
+func (l *Log) Query(f Filter) ([]Record, error) {
+	return l.store.Query(f)
+}
+
+// Close closes the store.
+func (l *Log) Close() error {
+	return l.store.Close()
+}
+
+// reason describes why an action failed: the type of its error detail, such
+// as "service.ErrNotEnoughCharge", or else its message.
+func reason(err error) string {
+	if appErr, ok := err.(errors.AppError); ok && appErr.GetProtobufError() != nil {
+		return string(proto.MessageName(appErr.GetProtobufError()))
+	}
+	if m, ok := err.(interface{ GetMessage() string }); ok {
+		return m.GetMessage()
+	}
+	return err.Error()
+}
+
+// actor identifies the caller: the authorized principal, or else the mTLS
+// identity of the peer.
+func actor(ctx context.Context) string {
+	if p, ok := authz.FromContext(ctx); ok {
+		return p.String()
+	}
+	if peer, ok := tlsconfig.PeerIdentity(ctx); ok {
+		return peer
+	}
+	return "anonymous"
+}
@@ -0,0 +1,174 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"grpc-test/clock"
+	"grpc-test/lib"
+	pb "grpc-test/proto"
+
+	"google.golang.org/grpc"
+)
+
+var key = []byte(strings.Repeat("k", MinKeySize))
+
+// openFile returns a store in a new directory, with a head file.
+func openFile(t *testing.T) (*FileStore, Config) {
+	dir := t.TempDir()
+	cfg := Config{File: filepath.Join(dir, "audit.jsonl"), KeyFile: filepath.Join(dir, "audit.key"), HeadFile: filepath.Join(dir, "audit.head")}
+	if err := os.WriteFile(cfg.KeyFile, key, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s, err := OpenFile(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s, cfg
+}
+
+func appendRecords(t *testing.T, s Store, n int) {
+	for range n {
+		if err := s.Append(&Record{Kind: Charge, Outcome: OK}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	s, cfg := openFile(t)
+	appendRecords(t, s, 3)
+	records, err := ReadFile(cfg.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Verify(records, key); err != nil {
+		t.Fatalf("Verify() = %v, want nil", err)
+	}
+
+	changed := append([]Record(nil), records...)
+	changed[1].Amount = 1000
+	if err := Verify(changed, key); err == nil {
+		t.Error("Verify() of a changed record = nil, want an error")
+	}
+
+	// Rewriting the whole chain needs the key
+	rewritten := append([]Record(nil), records...)
+	var seq int64
+	var hash string
+	for i := range rewritten {
+		rewritten[i].Amount = 1000
+		rewritten[i].chain(seq, hash, []byte("guessed"))
+		seq, hash = rewritten[i].Seq, rewritten[i].Hash
+	}
+	if err := Verify(rewritten, key); err == nil {
+		t.Error("Verify() of a chain rewritten without the key = nil, want an error")
+	}
+}
+
+func TestHeadDetectsTruncation(t *testing.T) {
+	s, cfg := openFile(t)
+	appendRecords(t, s, 3)
+	s.Close()
+
+	head, err := ReadHead(cfg.HeadFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Seq != 3 {
+		t.Errorf("head seq = %d, want 3", head.Seq)
+	}
+	records, err := ReadFile(cfg.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := head.Check(records); err != nil {
+		t.Errorf("Check() = %v, want nil", err)
+	}
+	if err := head.Check(records[:2]); err == nil {
+		t.Error("Check() of a truncated log = nil, want an error")
+	}
+
+	// Drop the last record from the file
+	data, err := os.ReadFile(cfg.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	if err := os.WriteFile(cfg.File, []byte(strings.Join(lines[:2], "")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenFile(cfg); err == nil {
+		t.Error("OpenFile() of a truncated log succeeded")
+	}
+}
+
+func TestOpenFileNeedsKey(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := OpenFile(Config{File: filepath.Join(dir, "audit.jsonl")}); err == nil {
+		t.Error("OpenFile() without a key file succeeded")
+	}
+	short := filepath.Join(dir, "short.key")
+	if err := os.WriteFile(short, []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenFile(Config{File: filepath.Join(dir, "audit.jsonl"), KeyFile: short}); err == nil {
+		t.Error("OpenFile() with a short key succeeded")
+	}
+}
+
+// failingStore fails every append after the first ok ones.
+type failingStore struct {
+	Store
+	ok int
+}
+
+func (s *failingStore) Append(r *Record) error {
+	if s.ok == 0 {
+		return errors.New("disk full")
+	}
+	s.ok--
+	return nil
+}
+
+func TestInterceptorFailsClosed(t *testing.T) {
+	// A charge that ran is never turned into an error, recorded or not
+	info := &grpc.UnaryServerInfo{FullMethod: pb.Charge_ChargeCustomer_FullMethodName}
+	req := &pb.ChargeRequest{CustomerId: "12345", Amount: 100}
+	tests := []struct {
+		name        string
+		ok          int
+		wantHandled bool
+		wantErr     string
+	}{
+		{"attempt not recorded", 0, false, lib.NameServiceUnavailable},
+		{"outcome not recorded", 1, true, ""},
+		{"recorded", 2, true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewLog("payment", &failingStore{ok: tt.ok}, clock.NewFake(time.Unix(0, 0)))
+			handled := false
+			handler := func(ctx context.Context, req any) (any, error) {
+				handled = true
+				return &pb.ChargeResponse{}, nil
+			}
+			resp, err := l.UnaryServerInterceptor()(context.Background(), req, info, handler)
+			if handled != tt.wantHandled {
+				t.Errorf("handled = %v, want %v", handled, tt.wantHandled)
+			}
+			if got := lib.NameOf(err); got != tt.wantErr {
+				t.Errorf("error = %q, want %q", got, tt.wantErr)
+			}
+			if handled && resp == nil {
+				t.Error("response of the handled call was dropped")
+			}
+		})
+	}
+}
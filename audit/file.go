@@ -0,0 +1,181 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FileStore appends records to a JSON Lines file, one record per line, and
+// syncs the file after every record. The head file, if any, is replaced after
+// every record.
+type FileStore struct {
+	name     string
+	headFile string
+	key      []byte
+
+	mu   sync.Mutex
+	f    *os.File
+	seq  int64
+	hash string
+}
+
+// OpenFile opens the audit log of cfg, creating it if needed. It refuses to
+// append to a chain that does not verify, or that does not reach the head in
+// cfg.HeadFile. A missing head file is written with the next record.
+func OpenFile(cfg Config) (*FileStore, error) {
+	if cfg.KeyFile == "" {
+		return nil, errors.New("the audit log needs a key file")
+	}
+	key, err := ReadKey(cfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	records, err := ReadFile(cfg.File)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if err := Verify(records, key); err != nil {
+		return nil, fmt.Errorf("%s: %w", cfg.File, err)
+	}
+	if cfg.HeadFile != "" {
+		head, err := ReadHead(cfg.HeadFile)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		if err := head.Check(records); err != nil {
+			return nil, fmt.Errorf("%s: %w", cfg.File, err)
+		}
+	}
+	f, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open audit log: %w", err)
+	}
+	s := &FileStore{name: cfg.File, headFile: cfg.HeadFile, key: key, f: f}
+	if n := len(records); n > 0 {
+		s.seq, s.hash = records[n-1].Seq, records[n-1].Hash
+	}
+	return s, nil
+}
+
+func (s *FileStore) Append(r *Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return errors.New("audit log is closed")
+	}
+	r.chain(s.seq, s.hash, s.key)
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err := s.f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if err := s.f.Sync(); err != nil {
+		return err
+	}
+	s.seq, s.hash = r.Seq, r.Hash
+	if s.headFile != "" {
+		return writeHead(s.headFile, Head{Seq: r.Seq, Hash: r.Hash})
+	}
+	return nil
+}
+
+// Query reads the file again, so that it sees every record written.
+func (s *FileStore) Query(f Filter) ([]Record, error) {
+	s.mu.Lock()
+	records, err := ReadFile(s.name)
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	var matched []Record
+	for _, r := range records {
+		if f.Match(r) {
+			matched = append(matched, r)
+		}
+	}
+	return f.limit(matched), nil
+}
+
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
+
+// ReadFile reads the records of an audit log file.
+func ReadFile(file string) ([]Record, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", file, line, err)
+		}
+		records = append(records, r)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read %s: %w", file, err)
+	}
+	return records, nil
+}
+
+// ReadHead reads the head written along an audit log.
+func ReadHead(file string) (Head, error) {
+	var h Head
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return h, err
+	}
+	if err := json.Unmarshal(data, &h); err != nil {
+		return h, fmt.Errorf("%s: %w", file, err)
+	}
+	return h, nil
+}
+
+// writeHead replaces the head file, through a synced temporary file so that
+// it is never half written.
+func writeHead(file string, h Head) error {
+	data, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*")
+	if err != nil {
+		return fmt.Errorf("write audit head: %w", err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), file)
+	}
+	if err != nil {
+		return fmt.Errorf("write audit head: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package audit
+
+import (
+	"context"
+	"log/slog"
+
+	"grpc-test/lib"
+	"grpc-test/logging"
+	"grpc-test/metrics"
+	pb "grpc-test/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// Methods are the payment actions recorded by the interceptor, by full method
+// name, with their kind. ChargeCustomer is the only one: there is no refund or
+// top-up RPC yet, and those get a kind here once they are added.
+var Methods = map[string]string{
+	pb.Charge_ChargeCustomer_FullMethodName: Charge,
+}
+
+// UnaryServerInterceptor records every call to Methods with its outcome. It
+// belongs after authorization, which identifies the actor, and in front of
+// rate limiting and fault injection, so that calls they fail are recorded as
+// attempts too.
+//
+// It fails closed: a call is only handled once its attempt is recorded, and
+// fails with ServiceUnavailable otherwise. Once handled, the call returns what
+// the handler did even if its outcome cannot be recorded: a charge that went
+// through must not be reported as failed, or the caller would charge again.
+// The attempt remains on record, and the missing outcome is logged and counted
+// by audit_outcomes_lost_total for reconciliation.
+func (l *Log) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		kind, ok := Methods[info.FullMethod]
+		if !ok {
+			return handler(ctx, req)
+		}
+		r := Record{Kind: kind, Method: info.FullMethod, Outcome: Attempted}
+		if p, ok := req.(payment); ok {
+			r.CustomerID, r.OrderID, r.Amount = p.GetCustomerId(), p.GetOrderId(), float64(p.GetAmount())
+		}
+		if err := l.Append(ctx, r); err != nil {
+			return nil, lib.ErrServiceUnavailable().WithMessage("Audit log unavailable, nothing was done")
+		}
+		resp, err := handler(ctx, req)
+		r.Outcome = OK
+		if err != nil {
+			r.Outcome, r.Reason = outcome(err), reason(err)
+		}
+		if err := l.Append(ctx, r); err != nil {
+			metrics.AuditOutcomeLost(kind)
+			logging.FromContext(ctx).Error("Audit outcome not recorded, reconcile the attempt with the gateway",
+				slog.String("method", r.Method), slog.String("customer_id", r.CustomerID), slog.String("order_id", r.OrderID),
+				slog.Float64("amount", r.Amount), slog.String("outcome", r.Outcome), slog.Any("error", err))
+		}
+		return resp, err
+	}
+}
+
+// payment is implemented by the requests of payment actions.
+type payment interface {
+	GetCustomerId() string
+	GetOrderId() string
+	GetAmount() float32
+}
+
+// outcome is the AppError name of err, or its gRPC code.
+func outcome(err error) string {
+	if name := lib.NameOf(err); name != "" {
+		return name
+	}
+	return status.Code(err).String()
+}
@@ -0,0 +1,50 @@
+package audit
+
+import (
+	pb "grpc-test/proto"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// FilterFromProto converts the filters of a QueryAudit request.
+func FilterFromProto(req *pb.QueryAuditRequest) Filter {
+	f := Filter{
+		Kind:       req.Kind,
+		Actor:      req.Actor,
+		CustomerID: req.CustomerId,
+		OrderID:    req.OrderId,
+		Outcome:    req.Outcome,
+		Limit:      int(req.Limit),
+	}
+	if req.Since != nil {
+		f.Since = req.Since.AsTime()
+	}
+	if req.Until != nil {
+		f.Until = req.Until.AsTime()
+	}
+	return f
+}
+
+// ToProto converts records for the Admin service.
+func ToProto(records []Record) *pb.AuditRecords {
+	resp := &pb.AuditRecords{}
+	for _, r := range records {
+		resp.Records = append(resp.Records, &pb.AuditRecord{
+			Seq:        r.Seq,
+			Time:       timestamppb.New(r.Time),
+			Kind:       r.Kind,
+			Service:    r.Service,
+			Method:     r.Method,
+			Actor:      r.Actor,
+			RequestId:  r.RequestID,
+			CustomerId: r.CustomerID,
+			OrderId:    r.OrderID,
+			Amount:     r.Amount,
+			Outcome:    r.Outcome,
+			Reason:     r.Reason,
+			PrevHash:   r.PrevHash,
+			Hash:       r.Hash,
+		})
+	}
+	return resp
+}
@@ -0,0 +1,49 @@
+package authz
+
+import (
+	"context"
+	"log/slog"
+
+	"grpc-test/logging"
+)
+
+// Decision is the audit record of one authorization decision.
+type Decision struct {
+	Method    string
+	Principal string
+	Allowed   bool
+	// Rule is the index of the matching rule, -1 when none matched.
+	Rule   int
+	Reason string
+}
+
+// Auditor records authorization decisions.
+type Auditor interface {
+	Record(ctx context.Context, d Decision)
+}
+
+// LogAuditor writes decisions to the request logger.
+type LogAuditor struct{}
+
+func (LogAuditor) Record(ctx context.Context, d Decision) {
+	level := slog.LevelInfo
+	if !d.Allowed {
+		level = slog.LevelWarn
+	}
+	logging.FromContext(ctx).Log(ctx, level, "Authorization decision",
+		slog.Bool("audit", true),
+		slog.String("principal", d.Principal),
+		slog.Bool("allowed", d.Allowed),
+		slog.Int("rule", d.Rule),
+		slog.String("reason", d.Reason),
+	)
+}
+
+// Auditors records decisions with every auditor in turn.
+type Auditors []Auditor
+
+func (a Auditors) Record(ctx context.Context, d Decision) {
+	for _, auditor := range a {
+		auditor.Record(ctx, d)
+	}
+}
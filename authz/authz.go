@@ -0,0 +1,226 @@
+// Package authz enforces a per-method authorization policy. Callers are
+// identified by their mTLS peer identity and/or a bearer token, and every
+// decision is written to an audit log.
+package authz
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+
+	"grpc-test/lib"
+	"grpc-test/tlsconfig"
+
+	"github.com/revotech-group/go-lib/errors"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Config points to the policy file, authorization is disabled when empty.
+type Config struct {
+	PolicyFile string `json:"policy_file"`
+}
+
+const authorizationHeader = "authorization"
+
+// Principal is the authenticated caller.
+type Principal struct {
+	Subject    string
+	Peer       string
+	Roles      []string
+	Scopes     []string
+	CustomerID string
+}
+
+// Anonymous reports whether the caller presented no credentials at all.
+func (p Principal) Anonymous() bool {
+	return p.Subject == "" && p.Peer == ""
+}
+
+// String identifies the principal in logs and errors.
+func (p Principal) String() string {
+	switch {
+	case p.Subject != "" && p.Peer != "":
+		return p.Subject + " via " + p.Peer
+	case p.Subject != "":
+		return p.Subject
+	case p.Peer != "":
+		return p.Peer
+	}
+	return "anonymous"
+}
+
+type principalKey struct{}
+
+// FromContext returns the principal of an authorized request.
+func FromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// Authorizer evaluates requests against a policy.
+type Authorizer struct {
+	policy  *Policy
+	auditor Auditor
+}
+
+func NewAuthorizer(policy *Policy, auditor Auditor) *Authorizer {
+	return &Authorizer{policy: policy, auditor: auditor}
+}
+
+// UnaryServerInterceptor authorizes unary RPCs.
+func (a *Authorizer) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := a.authorize(ctx, info.FullMethod, req)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor authorizes streaming RPCs. Ownership checks do not
+// apply to streams since there is no single request message.
+func (a *Authorizer) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := a.authorize(ss.Context(), info.FullMethod, nil)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+func (a *Authorizer) authorize(ctx context.Context, method string, req any) (context.Context, error) {
+	principal, err := a.authenticate(ctx)
+	d := Decision{Method: method, Principal: principal.String(), Rule: -1}
+	if err == nil {
+		var rule Rule
+		rule, d.Rule = a.policy.rule(method)
+		err = a.check(principal, rule, d.Rule, method, req)
+	}
+
+	d.Allowed = err == nil
+	if err != nil {
+		d.Reason = err.Error()
+	}
+	a.auditor.Record(ctx, d)
+
+	if err != nil {
+		return ctx, err
+	}
+	return context.WithValue(ctx, principalKey{}, principal), nil
+}
+
+func (a *Authorizer) authenticate(ctx context.Context) (Principal, error) {
+	var p Principal
+	p.Peer, _ = tlsconfig.PeerIdentity(ctx)
+
+	token, ok := bearerToken(ctx)
+	if !ok {
+		return p, nil
+	}
+	sum := sha256.Sum256([]byte(token))
+	cred, ok := a.policy.credential(hex.EncodeToString(sum[:]))
+	if !ok {
+		return p, Denied(lib.ErrUnauthorizedAccess(), "INVALID_TOKEN", "", nil)
+	}
+	p.Subject, p.Roles, p.Scopes, p.CustomerID = cred.Subject, cred.Roles, cred.Scopes, cred.CustomerID
+	return p, nil
+}
+
+func (a *Authorizer) check(p Principal, rule Rule, index int, method string, req any) error {
+	if index < 0 {
+		if a.policy.DefaultAllow {
+			return nil
+		}
+		return Denied(lib.ErrForbidden(), "NO_MATCHING_RULE", method, nil)
+	}
+	if rule.Public {
+		return nil
+	}
+	if p.Anonymous() {
+		return Denied(lib.ErrUnauthorizedAccess(), "UNAUTHENTICATED", method, nil)
+	}
+	if len(rule.Peers) > 0 && !slices.Contains(rule.Peers, p.Peer) {
+		return Denied(lib.ErrForbidden(), "PEER_NOT_ALLOWED", method, map[string]string{"peer": p.Peer})
+	}
+	if len(rule.Roles) > 0 && !slices.ContainsFunc(rule.Roles, func(r string) bool { return slices.Contains(p.Roles, r) }) {
+		return Denied(lib.ErrForbidden(), "MISSING_ROLE", method, map[string]string{"required_roles": strings.Join(rule.Roles, ",")})
+	}
+	for _, scope := range rule.Scopes {
+		if !slices.Contains(p.Scopes, scope) {
+			return Denied(lib.ErrForbidden(), "MISSING_SCOPE", method, map[string]string{"required_scope": scope})
+		}
+	}
+	if rule.OwnCustomer {
+		r, ok := req.(interface{ GetCustomerId() string })
+		if !ok || p.CustomerID == "" || r.GetCustomerId() != p.CustomerID {
+			return Denied(lib.ErrForbidden(), "CUSTOMER_MISMATCH", method, map[string]string{"caller_customer_id": p.CustomerID})
+		}
+	}
+	return nil
+}
+
+// Denied attaches a google.rpc.ErrorInfo detail describing the decision.
+func Denied(err errors.AppError, reason, method string, metadata map[string]string) errors.AppError {
+	if method != "" {
+		if metadata == nil {
+			metadata = map[string]string{}
+		}
+		metadata["method"] = method
+	}
+	return err.
+		WithMessage(fmt.Sprintf("Access denied: %s", strings.ToLower(strings.ReplaceAll(reason, "_", " ")))).
+		WithProtobufError(&errdetails.ErrorInfo{Reason: reason, Domain: "authz", Metadata: metadata})
+}
+
+func bearerToken(ctx context.Context) (string, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false
+	}
+	for _, v := range md.Get(authorizationHeader) {
+		if token, ok := strings.CutPrefix(v, "Bearer "); ok && token != "" {
+			return token, true
+		}
+	}
+	return "", false
+}
+
+// UnaryClientInterceptor forwards the caller's bearer token to downstream
+// services so they can authorize the end user as well.
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(forwardToken(ctx), method, req, reply, cc, opts...)
+	}
+}
+
+func forwardToken(ctx context.Context) context.Context {
+	token, ok := bearerToken(ctx)
+	if !ok {
+		return ctx
+	}
+	if md, ok := metadata.FromOutgoingContext(ctx); ok && len(md.Get(authorizationHeader)) > 0 {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, authorizationHeader, "Bearer "+token)
+}
+
+// WithToken attaches a bearer token to outgoing calls made with ctx.
+func WithToken(ctx context.Context, token string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, authorizationHeader, "Bearer "+token)
+}
+
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
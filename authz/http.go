@@ -0,0 +1,37 @@
+package authz
+
+import (
+	"net/http"
+
+	"grpc-test/lib"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// Handler authorizes HTTP requests to next against the policy. The path of a
+// request stands in for the method, so rules match it with patterns such as
+// "/debug/pprof/*". Callers are identified by their client certificate and
+// their Authorization header, as over gRPC.
+func (a *Authorizer) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if auth := r.Header.Get("Authorization"); auth != "" {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(authorizationHeader, auth))
+		}
+		if r.TLS != nil {
+			ctx = peer.NewContext(ctx, &peer.Peer{AuthInfo: credentials.TLSInfo{State: *r.TLS}})
+		}
+		ctx, err := a.authorize(ctx, r.URL.Path, nil)
+		if err != nil {
+			msg := err.Error()
+			if m, ok := err.(interface{ GetMessage() string }); ok {
+				msg = m.GetMessage()
+			}
+			http.Error(w, msg, lib.HTTPStatus(err))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
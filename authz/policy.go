@@ -0,0 +1,87 @@
+package authz
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+)
+
+// Policy is the declarative authorization file. Rules are evaluated in order
+// and the first rule whose pattern matches the method decides; methods without
+// a matching rule are denied unless DefaultAllow is set.
+type Policy struct {
+	DefaultAllow bool         `json:"default_allow"`
+	Rules        []Rule       `json:"rules"`
+	Principals   []Credential `json:"principals"`
+}
+
+// Rule lists what a caller needs to invoke the matching methods. Empty
+// fields impose no requirement.
+type Rule struct {
+	// Methods are path.Match patterns such as "/service.Charge/*".
+	Methods []string `json:"methods"`
+	// Public lets unauthenticated callers through.
+	Public bool `json:"public"`
+	// Peers are the mTLS identities allowed to call.
+	Peers []string `json:"peers"`
+	// Roles requires at least one of the roles.
+	Roles []string `json:"roles"`
+	// Scopes requires all of the scopes.
+	Scopes []string `json:"scopes"`
+	// OwnCustomer requires the customer_id of the request to be the caller's.
+	OwnCustomer bool `json:"own_customer"`
+}
+
+// Credential maps a bearer token, stored as its hex SHA-256, to a principal.
+type Credential struct {
+	TokenSHA256 string   `json:"token_sha256"`
+	Subject     string   `json:"subject"`
+	Roles       []string `json:"roles"`
+	Scopes      []string `json:"scopes"`
+	CustomerID  string   `json:"customer_id"`
+}
+
+// LoadPolicy reads and validates a policy file.
+func LoadPolicy(file string) (*Policy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read policy: %w", err)
+	}
+	var p Policy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("parse policy %s: %w", file, err)
+	}
+	for i, r := range p.Rules {
+		if len(r.Methods) == 0 {
+			return nil, fmt.Errorf("rule %d has no methods", i)
+		}
+		for _, m := range r.Methods {
+			if _, err := path.Match(m, ""); err != nil {
+				return nil, fmt.Errorf("rule %d: bad method pattern %q: %w", i, m, err)
+			}
+		}
+	}
+	return &p, nil
+}
+
+// rule returns the first rule matching method and its index, or -1.
+func (p *Policy) rule(method string) (Rule, int) {
+	for i, r := range p.Rules {
+		for _, pattern := range r.Methods {
+			if ok, _ := path.Match(pattern, method); ok {
+				return r, i
+			}
+		}
+	}
+	return Rule{}, -1
+}
+
+func (p *Policy) credential(tokenHash string) (Credential, bool) {
+	for _, c := range p.Principals {
+		if c.TokenSHA256 == tokenHash {
+			return c, true
+		}
+	}
+	return Credential{}, false
+}
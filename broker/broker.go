@@ -0,0 +1,207 @@
+// Package broker publishes messages to subjects and delivers them to the
+// subscribers of those subjects, so that a producer does not need a
+// connection per consumer. It follows the NATS model: subjects are
+// dot-separated tokens, subscriptions may use the "*" and ">" wildcards, and
+// subscribers sharing a group split the messages between them instead of each
+// receiving all of them.
+//
+// NATS connects to a NATS server with JetStream, such as the one Embedded
+// runs in the process. Memory is an in-process broker for tests. Both keep the
+// last message of the subjects given to Retain for new subscribers.
+package broker
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync"
+
+	"grpc-test/clock"
+)
+
+// Config selects the broker of a service.
+type Config struct {
+	// URL is nats://host:port for a NATS server, or memory://name for a
+	// broker shared within the process. The service uses no broker when both
+	// URL and Listen are empty.
+	URL string `json:"url"`
+	// Listen serves an embedded NATS server on this address. The service
+	// itself uses it unless URL is set.
+	Listen string `json:"listen"`
+	// Group is the consumer group of the subscriptions of the service. Empty
+	// delivers every message to every instance.
+	Group string `json:"group"`
+}
+
+// MaxPayload is the largest message accepted, as the max_payload of NATS.
+const MaxPayload = 1 << 20
+
+// Message is a message published to a subject.
+type Message struct {
+	Subject string
+	Data    []byte
+}
+
+// Handler processes the messages of a subscription, one at a time.
+type Handler func(Message)
+
+// Broker publishes and subscribes to subjects.
+type Broker interface {
+	Publish(subject string, data []byte) error
+	// Subscribe delivers the messages of the subjects matching pattern to h.
+	// Of the subscribers of a pattern in the same non-empty group, only one
+	// receives each message. A subscription to retained subjects starts with
+	// the last message of each of them.
+	Subscribe(pattern, group string, h Handler) (Subscription, error)
+	// Retain keeps the last message of every subject matching pattern for
+	// the subscriptions that start later.
+	Retain(pattern string) error
+	Close() error
+}
+
+// Subscription is an active subscription.
+type Subscription interface {
+	Unsubscribe() error
+}
+
+// ErrClosed is returned by a closed broker.
+var ErrClosed = errors.New("broker closed")
+
+// Open connects to the broker at rawURL. name identifies the connection to
+// the server.
+func Open(rawURL, name string, clk clock.Clock) (Broker, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("broker URL: %w", err)
+	}
+	switch u.Scheme {
+	case "memory":
+		return Shared(u.Host + u.Path), nil
+	case "nats":
+		if u.Host == "" {
+			return nil, fmt.Errorf("broker URL %q has no host", rawURL)
+		}
+		return Connect(rawURL, name, clk)
+	}
+	return nil, fmt.Errorf("broker URL %q: expected nats://host:port or memory://name", rawURL)
+}
+
+var (
+	sharedMu sync.Mutex
+	shared   = map[string]*Memory{}
+)
+
+// Shared returns the in-process broker called name, creating it on first use.
+// Services running in one process, such as in the test harness, talk through
+// it. It is never closed.
+func Shared(name string) Broker {
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+	m, ok := shared[name]
+	if !ok {
+		m = NewMemory()
+		shared[name] = m
+	}
+	return unclosable{m}
+}
+
+type unclosable struct{ *Memory }
+
+func (unclosable) Close() error { return nil }
+
+// Match reports whether subject matches pattern, where "*" matches one token
+// and a final ">" one or more.
+func Match(pattern, subject string) bool {
+	p, s := strings.Split(pattern, "."), strings.Split(subject, ".")
+	for i, token := range p {
+		if token == ">" {
+			return len(s) > i
+		}
+		if i >= len(s) || (token != "*" && token != s[i]) {
+			return false
+		}
+	}
+	return len(p) == len(s)
+}
+
+// covers reports whether every subject matching pattern matches outer.
+func covers(outer, pattern string) bool {
+	o, p := strings.Split(outer, "."), strings.Split(pattern, ".")
+	for i, token := range o {
+		if token == ">" {
+			return len(p) > i
+		}
+		if i >= len(p) || p[i] == ">" || (token != "*" && (p[i] == "*" || token != p[i])) {
+			return false
+		}
+	}
+	return len(o) == len(p)
+}
+
+// validSubject checks a subject, or a pattern if wildcards are allowed.
+func validSubject(subject string, wildcards bool) error {
+	tokens := strings.Split(subject, ".")
+	for i, token := range tokens {
+		switch {
+		case token == "" || strings.ContainsAny(token, " \t\r\n"):
+			return fmt.Errorf("invalid subject %q", subject)
+		case !wildcards && (token == "*" || token == ">"):
+			return fmt.Errorf("cannot publish to wildcard subject %q", subject)
+		case token == ">" && i != len(tokens)-1:
+			return fmt.Errorf("invalid subject %q: > must be the last token", subject)
+		}
+	}
+	return nil
+}
+
+// validGroup checks a group, which names a JetStream consumer on NATS.
+func validGroup(group string) error {
+	if strings.ContainsAny(group, " \t\r\n.*>/\\") {
+		return fmt.Errorf("invalid group %q", group)
+	}
+	return nil
+}
+
+// pending is the number of messages a subscriber may fall behind by before
+// messages are dropped, as NATS does with slow consumers.
+const pending = 1024
+
+// subscriber runs the handler of a Memory subscription on its own goroutine.
+type subscriber struct {
+	pattern string
+	group   string
+	h       Handler
+	ch      chan Message
+	once    sync.Once
+	done    chan struct{}
+}
+
+func newSubscriber(pattern, group string, h Handler) *subscriber {
+	s := &subscriber{pattern: pattern, group: group, h: h, ch: make(chan Message, pending), done: make(chan struct{})}
+	go func() {
+		for {
+			select {
+			case m := <-s.ch:
+				s.h(m)
+			case <-s.done:
+				return
+			}
+		}
+	}()
+	return s
+}
+
+func (s *subscriber) deliver(m Message) {
+	select {
+	case s.ch <- m:
+	case <-s.done:
+	default:
+		slog.Warn("Slow broker subscriber, message dropped", slog.String("subject", m.Subject), slog.String("pattern", s.pattern))
+	}
+}
+
+func (s *subscriber) stop() {
+	s.once.Do(func() { close(s.done) })
+}
@@ -0,0 +1,195 @@
+package broker
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"grpc-test/clock"
+
+	"github.com/nats-io/nats-server/v2/server"
+)
+
+// brokers returns the implementations under test, with "rates.>" retained.
+func brokers(t *testing.T) map[string]Broker {
+	e, err := StartEmbedded("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { e.Shutdown() })
+	n, err := Connect("nats://"+e.Addr().String(), "test", clock.Real)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { n.Close() })
+
+	m := NewMemory()
+	t.Cleanup(func() { m.Close() })
+	bs := map[string]Broker{"memory": m, "nats": n}
+	for _, b := range bs {
+		if err := b.Retain("rates.>"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return bs
+}
+
+// collector records the messages of a subscription.
+type collector struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (c *collector) handle(m Message) {
+	c.mu.Lock()
+	c.msgs = append(c.msgs, m.Subject+"="+string(m.Data))
+	c.mu.Unlock()
+}
+
+func (c *collector) get() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.msgs)
+}
+
+// waitFor waits up to 5s for the collectors to have n messages together.
+func waitFor(n int, cs ...*collector) {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		total := 0
+		for _, c := range cs {
+			total += len(c.get())
+		}
+		if total >= n {
+			return
+		}
+	}
+}
+
+func TestRetainedSubjectsStartWithTheLastMessage(t *testing.T) {
+	for name, b := range brokers(t) {
+		t.Run(name, func(t *testing.T) {
+			for _, m := range []string{"rates.USD.EUR=1", "rates.USD.EUR=2", "rates.GBP.JPY=3", "other=4"} {
+				subject, data, _ := strings.Cut(m, "=")
+				if err := b.Publish(subject, []byte(data)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// Publishes are asynchronous on NATS
+			time.Sleep(100 * time.Millisecond)
+
+			var c collector
+			sub, err := b.Subscribe("rates.>", "", c.handle)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sub.Unsubscribe()
+			waitFor(2, &c)
+			got := c.get()
+			if want := "rates.GBP.JPY=3,rates.USD.EUR=2"; strings.Join(slices.Sorted(slices.Values(got)), ",") != want {
+				t.Errorf("retained = %v, want %s", got, want)
+			}
+
+			if err := b.Publish("rates.USD.EUR", []byte("5")); err != nil {
+				t.Fatal(err)
+			}
+			waitFor(3, &c)
+			if got := c.get(); len(got) != 3 || got[2] != "rates.USD.EUR=5" {
+				t.Errorf("messages = %v, want rates.USD.EUR=5 last", got)
+			}
+		})
+	}
+}
+
+func TestGroupsSplitMessages(t *testing.T) {
+	for name, b := range brokers(t) {
+		t.Run(name, func(t *testing.T) {
+			var a, c, all collector
+			for _, col := range []*collector{&a, &c} {
+				sub, err := b.Subscribe("rates.>", "payment", col.handle)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer sub.Unsubscribe()
+			}
+			sub, err := b.Subscribe("rates.>", "", all.handle)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sub.Unsubscribe()
+			time.Sleep(100 * time.Millisecond)
+
+			const n = 20
+			for i := range n {
+				if err := b.Publish(fmt.Sprintf("rates.X.Y%d", i), []byte("1")); err != nil {
+					t.Fatal(err)
+				}
+			}
+			waitFor(n, &all)
+			waitFor(n, &a, &c)
+			// Late duplicates would arrive now
+			time.Sleep(100 * time.Millisecond)
+			if got := len(all.get()); got != n {
+				t.Errorf("subscriber without a group got %d messages, want %d", got, n)
+			}
+			if got := len(a.get()) + len(c.get()); got != n {
+				t.Errorf("group members got %d messages together, want %d", got, n)
+			}
+			if len(a.get()) == 0 || len(c.get()) == 0 {
+				t.Errorf("group members got %d and %d messages, want both some", len(a.get()), len(c.get()))
+			}
+		})
+	}
+}
+
+func TestPublishBoundsPayload(t *testing.T) {
+	for name, b := range brokers(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := b.Publish("rates.USD.EUR", make([]byte, MaxPayload+1)); err == nil {
+				t.Error("Publish() of an oversized message succeeded")
+			}
+		})
+	}
+}
+
+func TestCovers(t *testing.T) {
+	tests := []struct {
+		outer, pattern string
+		want           bool
+	}{
+		{"rates.>", "rates.>", true},
+		{"rates.>", "rates.USD.*", true},
+		{"rates.>", "rates.USD.EUR", true},
+		{"rates.>", "rates", false},
+		{"rates.*.*", "rates.>", false},
+		{"rates.*.*", "rates.USD.*", true},
+		{"rates.USD.*", "rates.*.EUR", false},
+	}
+	for _, tt := range tests {
+		if got := covers(tt.outer, tt.pattern); got != tt.want {
+			t.Errorf("covers(%q, %q) = %v, want %v", tt.outer, tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestRetainNeedsJetStream(t *testing.T) {
+	srv, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: server.RANDOM_PORT, NoSigs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Start()
+	defer srv.Shutdown()
+	if !srv.ReadyForConnections(5 * time.Second) {
+		t.Fatal("server did not start")
+	}
+	n, err := Connect(srv.ClientURL(), "test", clock.Real)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+	if err := n.Retain("rates.>"); err == nil {
+		t.Error("Retain() on a server without JetStream succeeded")
+	}
+}
@@ -0,0 +1,81 @@
+package broker
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"grpc-test/clock"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+// Embedded is a NATS server with JetStream running in the process. It has no
+// authentication, so it should only listen on a trusted network.
+type Embedded struct {
+	srv *server.Server
+	dir string // JetStream storage, removed on shutdown
+}
+
+// StartEmbedded starts a NATS server listening on addr, with port 0 for any.
+func StartEmbedded(addr string) (*Embedded, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("broker address: %w", err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, fmt.Errorf("broker address %q: invalid port", addr)
+	}
+	if port == 0 {
+		port = server.RANDOM_PORT
+	}
+	dir, err := os.MkdirTemp("", "broker-")
+	if err != nil {
+		return nil, err
+	}
+
+	srv, err := server.NewServer(&server.Options{
+		ServerName: "grpc-test",
+		Host:       host,
+		Port:       port,
+		MaxPayload: MaxPayload,
+		JetStream:  true,
+		StoreDir:   dir,
+		NoSigs:     true,
+	})
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("broker: %w", err)
+	}
+	go srv.Start()
+	if !srv.ReadyForConnections(5 * time.Second) {
+		srv.Shutdown()
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("broker did not start listening on %s", addr)
+	}
+	slog.Info("Broker is running", slog.String("addr", srv.Addr().String()))
+	return &Embedded{srv: srv, dir: dir}, nil
+}
+
+// Addr returns the address the server listens on.
+func (e *Embedded) Addr() net.Addr {
+	return e.srv.Addr()
+}
+
+// Connect returns a client of the server that does not go through the
+// network.
+func (e *Embedded) Connect(name string, clk clock.Clock) (*NATS, error) {
+	return Connect("", name, clk, nats.InProcessServer(e.srv))
+}
+
+// Shutdown stops the server and removes its storage.
+func (e *Embedded) Shutdown() error {
+	e.srv.Shutdown()
+	e.srv.WaitForShutdown()
+	return os.RemoveAll(e.dir)
+}
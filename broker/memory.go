@@ -0,0 +1,138 @@
+package broker
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// Memory is a broker within the process. It keeps the last message of the
+// retained subjects and delivers the matching ones to new subscriptions, so
+// that a consumer starts with the current values instead of waiting for the
+// next publish.
+type Memory struct {
+	mu       sync.Mutex
+	subs     []*subscriber
+	next     map[string]int // round-robin position by group and pattern
+	retain   []string       // patterns given to Retain
+	retained map[string]Message
+	closed   bool
+}
+
+func NewMemory() *Memory {
+	return &Memory{next: map[string]int{}, retained: map[string]Message{}}
+}
+
+func (m *Memory) Publish(subject string, data []byte) error {
+	if err := validSubject(subject, false); err != nil {
+		return err
+	}
+	if len(data) > MaxPayload {
+		return fmt.Errorf("message of %d bytes on %s exceeds the maximum of %d", len(data), subject, MaxPayload)
+	}
+	msg := Message{Subject: subject, Data: slices.Clone(data)}
+
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return ErrClosed
+	}
+	if slices.ContainsFunc(m.retain, func(p string) bool { return Match(p, subject) }) {
+		m.retained[subject] = msg
+	}
+	var targets []*subscriber
+	groups := map[string][]*subscriber{}
+	for _, s := range m.subs {
+		if !Match(s.pattern, subject) {
+			continue
+		}
+		if s.group == "" {
+			targets = append(targets, s)
+			continue
+		}
+		key := groupKey(s)
+		groups[key] = append(groups[key], s)
+	}
+	for key, members := range groups {
+		targets = append(targets, members[m.next[key]%len(members)])
+		m.next[key]++
+	}
+	m.mu.Unlock()
+
+	for _, s := range targets {
+		s.deliver(msg)
+	}
+	return nil
+}
+
+func (m *Memory) Subscribe(pattern, group string, h Handler) (Subscription, error) {
+	if err := validSubject(pattern, true); err != nil {
+		return nil, err
+	}
+	if err := validGroup(group); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, ErrClosed
+	}
+	s := newSubscriber(pattern, group, h)
+	// Retained messages go to the first member of a group only, the others
+	// would receive them a second time.
+	first := group == "" || !slices.ContainsFunc(m.subs, func(o *subscriber) bool { return groupKey(o) == groupKey(s) })
+	m.subs = append(m.subs, s)
+	if first {
+		for subject, msg := range m.retained {
+			if Match(pattern, subject) {
+				s.deliver(msg)
+			}
+		}
+	}
+	return &memorySubscription{m: m, s: s}, nil
+}
+
+func (m *Memory) Retain(pattern string) error {
+	if err := validSubject(pattern, true); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !slices.Contains(m.retain, pattern) {
+		m.retain = append(m.retain, pattern)
+	}
+	return nil
+}
+
+// Close stops every subscription.
+func (m *Memory) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	for _, s := range m.subs {
+		s.stop()
+	}
+	m.subs = nil
+	return nil
+}
+
+func (m *Memory) unsubscribe(s *subscriber) {
+	m.mu.Lock()
+	m.subs = slices.DeleteFunc(m.subs, func(o *subscriber) bool { return o == s })
+	m.mu.Unlock()
+	s.stop()
+}
+
+func groupKey(s *subscriber) string {
+	return s.group + " " + s.pattern
+}
+
+type memorySubscription struct {
+	m *Memory
+	s *subscriber
+}
+
+func (s *memorySubscription) Unsubscribe() error {
+	s.m.unsubscribe(s.s)
+	return nil
+}
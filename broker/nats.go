@@ -0,0 +1,293 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"grpc-test/clock"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// NATS is a broker on a NATS server. Retained subjects are kept in a
+// JetStream stream holding the last message of each subject, and
+// subscriptions to them are JetStream consumers that start with those
+// messages. Other subscriptions are core NATS ones. The connection is
+// re-established whenever it is lost, and messages published meanwhile are
+// buffered by the client.
+type NATS struct {
+	nc    *nats.Conn
+	js    jetstream.JetStream
+	clock clock.Clock
+
+	ctx    context.Context // cancelled by Close, stops the retries
+	cancel context.CancelFunc
+
+	mu     sync.Mutex
+	retain []string
+}
+
+// Connect connects to the NATS server at url, in the background if it cannot
+// be reached yet. name identifies the connection to the server, and opts are
+// added to the defaults.
+func Connect(url, name string, clk clock.Clock, opts ...nats.Option) (*NATS, error) {
+	n := &NATS{clock: clk}
+	n.ctx, n.cancel = context.WithCancel(context.Background())
+	opts = append([]nats.Option{
+		nats.Name(name),
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1),
+		nats.ConnectHandler(func(nc *nats.Conn) {
+			slog.Info("Connected to broker", slog.String("addr", nc.ConnectedAddr()))
+		}),
+		nats.DisconnectErrHandler(func(nc *nats.Conn, err error) {
+			if err != nil {
+				slog.Warn("Broker connection lost", slog.Any("error", err))
+			}
+		}),
+		// A restarted server may have lost the streams
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			slog.Info("Reconnected to broker", slog.String("addr", nc.ConnectedAddr()))
+			n.mu.Lock()
+			retain := slices.Clone(n.retain)
+			n.mu.Unlock()
+			for _, pattern := range retain {
+				go n.retry(n.ctx, "create stream "+streamName(pattern), n.createStream(pattern))
+			}
+		}),
+		nats.ErrorHandler(func(_ *nats.Conn, sub *nats.Subscription, err error) {
+			attrs := []any{slog.Any("error", err)}
+			if sub != nil {
+				attrs = append(attrs, slog.String("subject", sub.Subject))
+			}
+			slog.Warn("Broker error", attrs...)
+		}),
+	}, opts...)
+
+	nc, err := nats.Connect(url, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("connect to broker: %w", err)
+	}
+	if n.js, err = jetstream.New(nc); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	n.nc = nc
+	return n, nil
+}
+
+func (n *NATS) Publish(subject string, data []byte) error {
+	if err := validSubject(subject, false); err != nil {
+		return err
+	}
+	if len(data) > MaxPayload {
+		return fmt.Errorf("message of %d bytes on %s exceeds the maximum of %d", len(data), subject, MaxPayload)
+	}
+	err := n.nc.Publish(subject, data)
+	if errors.Is(err, nats.ErrConnectionClosed) {
+		return ErrClosed
+	}
+	return err
+}
+
+// Retain creates the stream of pattern. It fails if the server has no
+// JetStream, and is retried in the background while the server cannot be
+// reached.
+func (n *NATS) Retain(pattern string) error {
+	if err := validSubject(pattern, true); err != nil {
+		return err
+	}
+	n.mu.Lock()
+	if !slices.Contains(n.retain, pattern) {
+		n.retain = append(n.retain, pattern)
+	}
+	n.mu.Unlock()
+	return n.start(n.ctx, "create stream "+streamName(pattern), n.createStream(pattern))
+}
+
+func (n *NATS) Subscribe(pattern, group string, h Handler) (Subscription, error) {
+	if err := validSubject(pattern, true); err != nil {
+		return nil, err
+	}
+	if err := validGroup(group); err != nil {
+		return nil, err
+	}
+	n.mu.Lock()
+	i := slices.IndexFunc(n.retain, func(r string) bool { return covers(r, pattern) })
+	stream := ""
+	if i >= 0 {
+		stream = streamName(n.retain[i])
+	}
+	n.mu.Unlock()
+
+	if stream == "" {
+		handle := func(m *nats.Msg) { h(Message{Subject: m.Subject, Data: m.Data}) }
+		var sub *nats.Subscription
+		var err error
+		if group == "" {
+			sub, err = n.nc.Subscribe(pattern, handle)
+		} else {
+			sub, err = n.nc.QueueSubscribe(pattern, group, handle)
+		}
+		if errors.Is(err, nats.ErrConnectionClosed) {
+			return nil, ErrClosed
+		}
+		return sub, err
+	}
+
+	s := &streamSubscription{}
+	ctx, cancel := context.WithCancel(n.ctx)
+	s.cancel = cancel
+	consume := func(ctx context.Context) error {
+		c, err := n.consumer(ctx, stream, pattern, group)
+		if err != nil {
+			return err
+		}
+		cc, err := c.Consume(func(m jetstream.Msg) {
+			h(Message{Subject: m.Subject(), Data: m.Data()})
+			if group != "" {
+				m.Ack()
+			}
+		})
+		if err != nil {
+			return err
+		}
+		s.set(ctx, cc)
+		return nil
+	}
+	if err := n.start(ctx, "subscribe to "+pattern, consume); err != nil {
+		cancel()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (n *NATS) Close() error {
+	n.cancel()
+	n.nc.Close()
+	return nil
+}
+
+// consumer returns the consumer of a subscription to a retained pattern,
+// starting with the last message of every subject. Subscriptions without a
+// group get their own ordered consumer. Those of a group share a durable
+// consumer named after it, which hands each message to one of them.
+func (n *NATS) consumer(ctx context.Context, stream, pattern, group string) (jetstream.Consumer, error) {
+	if group == "" {
+		return n.js.OrderedConsumer(ctx, stream, jetstream.OrderedConsumerConfig{
+			FilterSubjects: []string{pattern},
+			DeliverPolicy:  jetstream.DeliverLastPerSubjectPolicy,
+		})
+	}
+	return n.js.CreateOrUpdateConsumer(ctx, stream, jetstream.ConsumerConfig{
+		Durable:           group,
+		FilterSubject:     pattern,
+		DeliverPolicy:     jetstream.DeliverLastPerSubjectPolicy,
+		AckPolicy:         jetstream.AckExplicitPolicy,
+		InactiveThreshold: time.Hour,
+	})
+}
+
+func (n *NATS) createStream(pattern string) func(context.Context) error {
+	return func(ctx context.Context) error {
+		_, err := n.js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+			Name:              streamName(pattern),
+			Subjects:          []string{pattern},
+			MaxMsgsPerSubject: 1,
+			MaxMsgSize:        MaxPayload,
+			Storage:           jetstream.FileStorage,
+		})
+		return err
+	}
+}
+
+// start runs fn now if the client is connected, and returns its error if the
+// server has no JetStream. Otherwise fn is retried in the background.
+func (n *NATS) start(ctx context.Context, what string, fn func(context.Context) error) error {
+	if n.nc.IsConnected() {
+		attempt, cancel := context.WithTimeout(ctx, 5*time.Second)
+		err := fn(attempt)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		// Nothing answers the JetStream API of a server without it
+		if errors.Is(err, nats.ErrNoResponders) || errors.Is(err, jetstream.ErrJetStreamNotEnabled) || errors.Is(err, jetstream.ErrJetStreamNotEnabledForAccount) {
+			return fmt.Errorf("%s: %w", what, err)
+		}
+	}
+	go n.retry(ctx, what, fn)
+	return nil
+}
+
+// retry calls fn until it succeeds or ctx is done, with a backoff from 100ms
+// to 10s.
+func (n *NATS) retry(ctx context.Context, what string, fn func(context.Context) error) {
+	backoff := 100 * time.Millisecond
+	for {
+		attempt, cancel := context.WithTimeout(ctx, 5*time.Second)
+		err := fn(attempt)
+		cancel()
+		if err == nil || ctx.Err() != nil {
+			return
+		}
+		slog.Warn("Broker operation failed", slog.String("operation", what), slog.Any("error", err), slog.Duration("retry_in", backoff))
+		select {
+		case <-ctx.Done():
+			return
+		case <-n.clock.After(backoff):
+		}
+		backoff = min(backoff*2, 10*time.Second)
+	}
+}
+
+// streamName names the stream of a pattern after its literal tokens, e.g.
+// RATES for "rates.>".
+func streamName(pattern string) string {
+	var tokens []string
+	for _, token := range strings.Split(pattern, ".") {
+		if token != "*" && token != ">" {
+			tokens = append(tokens, strings.ToUpper(strings.NewReplacer("/", "_", "\\", "_").Replace(token)))
+		}
+	}
+	if len(tokens) == 0 {
+		return "ALL"
+	}
+	return strings.Join(tokens, "_")
+}
+
+// streamSubscription is a subscription to a retained pattern, consuming once
+// the consumer could be created.
+type streamSubscription struct {
+	cancel context.CancelFunc
+
+	mu sync.Mutex
+	cc jetstream.ConsumeContext
+}
+
+func (s *streamSubscription) set(ctx context.Context, cc jetstream.ConsumeContext) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ctx.Err() != nil {
+		cc.Stop()
+		return
+	}
+	s.cc = cc
+}
+
+func (s *streamSubscription) Unsubscribe() error {
+	s.cancel()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cc != nil {
+		s.cc.Stop()
+	}
+	return nil
+}
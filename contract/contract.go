@@ -0,0 +1,141 @@
+// Package contract publishes the error details each service may return. A
+// provider's contract lists the errors of each of its methods, derived from
+// domain.MethodErrors and the interceptors every service runs. Consumer tests
+// drive every error of Errors through the client code, so that a provider
+// adding a detail the client does not handle fails the build instead of
+// falling through to "unexpected" at runtime.
+package contract
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"grpc-test/authz"
+	"grpc-test/deadline"
+	"grpc-test/domain"
+	"grpc-test/lib"
+	"grpc-test/ratelimit"
+
+	"github.com/revotech-group/go-lib/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// Common are the errors any method may return from the interceptors in front
+// of the handlers: rate limiting, authorization, deadline enforcement and
+// recovered panics.
+var Common = []func() error{
+	func() error { return ratelimit.ErrTooManyRequests(time.Second, "", "") },
+	func() error { return authz.Denied(lib.ErrUnauthorizedAccess(), "UNAUTHENTICATED", "", nil) },
+	func() error { return authz.Denied(lib.ErrForbidden(), "NO_MATCHING_RULE", "", nil) },
+	func() error { return deadline.ErrBudgetTooSmall(0, 0) },
+	func() error { return lib.ErrInternalServerError() },
+}
+
+// Error is an error a method may return.
+type Error struct {
+	Name string `json:"name"`
+	Code int    `json:"code"`
+	// Detail is the full name of its protobuf detail, e.g.
+	// "service.ErrNotEnoughCharge", empty if it has none.
+	Detail string `json:"detail,omitempty"`
+}
+
+func (e Error) String() string {
+	if e.Detail == "" {
+		return e.Name
+	}
+	return e.Name + " (" + e.Detail + ")"
+}
+
+// Method lists the errors of a method.
+type Method struct {
+	Name   string  `json:"name"`
+	Errors []Error `json:"errors"`
+}
+
+// Contract lists the errors of every method of a service.
+type Contract struct {
+	Service string   `json:"service"`
+	Methods []Method `json:"methods"`
+}
+
+// Providers returns the contracts of services. Every method registered in
+// domain.MethodErrors must belong to one of them.
+func Providers(services ...grpc.ServiceDesc) ([]Contract, error) {
+	known := map[string]bool{}
+	contracts := make([]Contract, len(services))
+	for i, sd := range services {
+		contracts[i] = Of(sd)
+		for _, m := range contracts[i].Methods {
+			known[m.Name] = true
+		}
+	}
+	for method := range domain.MethodErrors {
+		if !known[method] {
+			return nil, fmt.Errorf("domain.MethodErrors lists %s, which no service provides", method)
+		}
+	}
+	return contracts, nil
+}
+
+// Of returns the contract of a service.
+func Of(sd grpc.ServiceDesc) Contract {
+	c := Contract{Service: sd.ServiceName}
+	var names []string
+	for _, m := range sd.Methods {
+		names = append(names, m.MethodName)
+	}
+	for _, s := range sd.Streams {
+		names = append(names, s.StreamName)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		full := "/" + sd.ServiceName + "/" + name
+		c.Methods = append(c.Methods, Method{Name: full, Errors: describeAll(Errors(full))})
+	}
+	return c
+}
+
+// Errors returns every error a method may return: those of its handler and
+// the Common ones.
+func Errors(method string) []error {
+	var errs []error
+	for _, newErr := range append(slices.Clone(domain.MethodErrors[method]), Common...) {
+		errs = append(errs, newErr())
+	}
+	return errs
+}
+
+// describeAll describes errs, without duplicates.
+func describeAll(errs []error) []Error {
+	var described []Error
+	for _, err := range errs {
+		e := describe(err)
+		if !slices.Contains(described, e) {
+			described = append(described, e)
+		}
+	}
+	sort.Slice(described, func(i, j int) bool {
+		if described[i].Name != described[j].Name {
+			return described[i].Name < described[j].Name
+		}
+		return described[i].Detail < described[j].Detail
+	})
+	return described
+}
+
+func describe(err error) Error {
+	e := Error{Name: lib.NameOf(err), Code: lib.CodeOf(err)}
+	if appErr, ok := err.(errors.AppError); ok && appErr.GetProtobufError() != nil {
+		e.Detail = DetailName(appErr.GetProtobufError())
+	}
+	return e
+}
+
+// DetailName returns the full name of a detail type, as used in contracts.
+func DetailName(detail proto.Message) string {
+	return string(proto.MessageName(detail))
+}
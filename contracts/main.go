@@ -0,0 +1,27 @@
+// Command contracts publishes the error contracts of the services as JSON:
+//
+//	go run ./contracts > contracts.json
+//
+// Each method lists its errors by name, code and detail type.
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+
+	"grpc-test/contract"
+	pb "grpc-test/proto"
+)
+
+func main() {
+	contracts, err := contract.Providers(pb.Order_ServiceDesc, pb.Charge_ServiceDesc, pb.Currency_ServiceDesc)
+	if err != nil {
+		log.Fatal(err)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(contracts); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,118 @@
+// Package currencyservice implements the Currency service, which streams
+// exchange rates to its subscribers and, when the service has a broker,
+// publishes them there for any number of consumers.
+package currencyservice
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"grpc-test/admin"
+	"grpc-test/broker"
+	"grpc-test/clock"
+	"grpc-test/logging"
+	"grpc-test/metrics"
+	pb "grpc-test/proto" // Replace with the correct import path
+	"grpc-test/random"
+	"grpc-test/rates"
+	"grpc-test/server"
+)
+
+// Server implements pb.CurrencyServer.
+type Server struct {
+	pb.UnimplementedCurrencyServer
+	interval time.Duration
+	clock    clock.Clock
+	rand     random.Rand
+}
+
+// NewServer returns a Currency service that sends a rate from rng every
+// interval of clk.
+func NewServer(interval time.Duration, clk clock.Clock, rng random.Rand) *Server {
+	return &Server{interval: interval, clock: clk, rand: rng}
+}
+
+// next simulates fetching an exchange rate.
+func (s *Server) next() *pb.ExchangeRate {
+	currencies := []string{"USD", "EUR", "GBP", "JPY", "AUD"}
+	from := currencies[s.rand.IntN(len(currencies))]
+	to := currencies[s.rand.IntN(len(currencies))]
+	rate := s.rand.Float64() * (s.rand.Float64() + 0.5) // Random exchange rate for demo purposes
+
+	return &pb.ExchangeRate{
+		CurrencyFrom: from,
+		CurrencyTo:   to,
+		Rate:         rate,
+		Timestamp:    s.clock.Now().Format(time.RFC3339),
+	}
+}
+
+func (s *Server) SendExchangeRates(_ *pb.Empty, stream pb.Currency_SendExchangeRatesServer) error {
+	ctx := stream.Context()
+
+	// Send exchange rates every interval until the subscriber goes away or
+	// the server shuts down
+	ticker := s.clock.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		// Send the exchange rate to the payment service
+		if err := stream.Send(s.next()); err != nil {
+			logging.FromContext(ctx).Error("Error sending exchange rate", slog.Any("error", err))
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			logging.FromContext(ctx).Info("Exchange rate stream ended", slog.Any("reason", context.Cause(ctx)))
+			return nil
+		case <-ticker.C():
+		}
+	}
+}
+
+// Publish publishes a rate to b every interval until ctx is done. Failed
+// publishes are logged, the next rate is published regardless.
+func (s *Server) Publish(ctx context.Context, b broker.Broker) {
+	ticker := s.clock.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		rate := s.next()
+		if err := rates.Publish(b, rate); err != nil {
+			slog.Warn("Failed to publish exchange rate", slog.String("from", rate.CurrencyFrom), slog.String("to", rate.CurrencyTo), slog.Any("error", err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+	}
+}
+
+// DefaultConfig returns the defaults of the currency service.
+func DefaultConfig() server.Config {
+	return server.Config{
+		Addr:    ":50053",
+		Metrics: metrics.Config{Addr: ":9093"},
+		Admin:   admin.Config{Addr: "localhost:50063"},
+	}
+}
+
+// Start registers a Currency service sending a rate every 5 seconds on srv,
+// and publishes rates as often to the broker of srv, if any.
+func Start(srv *server.Server) *Server {
+	s := NewServer(5*time.Second, srv.Clock(), srv.Rand("rates"))
+	pb.RegisterCurrencyServer(srv.GRPC(), s)
+	if b := srv.Broker(); b != nil {
+		if err := rates.Retain(b); err != nil {
+			slog.Error("Failed to retain exchange rates on the broker", slog.Any("error", err))
+		}
+		// Published rates are drawn from their own generator, so that they do
+		// not change the rates of the streams.
+		publisher := NewServer(5*time.Second, srv.Clock(), srv.Rand("published-rates"))
+		srv.Go(func(ctx context.Context) { publisher.Publish(ctx, b) })
+	}
+	srv.SetReady(pb.Currency_ServiceDesc.ServiceName, true)
+	return s
+}
@@ -0,0 +1,185 @@
+// Package deadline propagates the caller's deadline through the services with
+// budget accounting: every downstream hop gets the remaining time minus a
+// safety margin, so that the caller still has time to handle the answer, and
+// requests that arrive with too little time left are rejected up front.
+package deadline
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path"
+	"slices"
+	"time"
+
+	"grpc-test/lib"
+	"grpc-test/logging"
+	"grpc-test/metrics"
+
+	"github.com/revotech-group/go-lib/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Config sets the margin reserved per downstream hop and the per-method rules.
+type Config struct {
+	HopMargin lib.Duration `json:"hop_margin"`
+	Rules     []Rule       `json:"rules"`
+}
+
+// Rule applies to the methods matching one of its path.Match patterns. The
+// first matching rule wins.
+type Rule struct {
+	Methods []string `json:"methods"`
+	// MinRemaining rejects requests with less time left than this.
+	MinRemaining lib.Duration `json:"min_remaining"`
+	// Default is the deadline given to requests that arrive without one.
+	Default lib.Duration `json:"default"`
+}
+
+// Remaining returns the time left until the deadline of ctx.
+func Remaining(ctx context.Context) (time.Duration, bool) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0, false
+	}
+	return time.Until(deadline), true
+}
+
+// Reserve returns a context whose deadline is margin earlier than the one of
+// ctx, leaving the caller margin to handle the result of a downstream call.
+// It fails if the remaining budget does not cover the margin. Contexts without
+// a deadline are returned unchanged.
+func Reserve(ctx context.Context, margin time.Duration) (context.Context, context.CancelFunc, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok || margin <= 0 {
+		return ctx, func() {}, nil
+	}
+	if time.Until(deadline) <= margin {
+		return ctx, func() {}, status.Errorf(codes.DeadlineExceeded, "deadline budget exhausted: %v left, %v reserved", time.Until(deadline).Round(time.Millisecond), margin)
+	}
+	ctx, cancel := context.WithDeadline(ctx, deadline.Add(-margin))
+	return ctx, cancel, nil
+}
+
+// ErrBudgetTooSmall is returned to callers whose deadline leaves less than the
+// method's minimum.
+func ErrBudgetTooSmall(remaining, minimum time.Duration) errors.AppError {
+	return lib.ErrDeadlineExceeded().WithMessage(fmt.Sprintf(
+		"Deadline too short: %v left, at least %v required", remaining.Round(time.Millisecond), minimum))
+}
+
+// Enforcer applies the rules of a Config to incoming requests.
+type Enforcer struct {
+	rules []Rule
+}
+
+func NewEnforcer(cfg Config) *Enforcer {
+	return &Enforcer{rules: cfg.Rules}
+}
+
+func (e *Enforcer) rule(method string) (Rule, bool) {
+	for _, r := range e.rules {
+		if slices.ContainsFunc(r.Methods, func(p string) bool {
+			ok, _ := path.Match(p, method)
+			return ok
+		}) {
+			return r, true
+		}
+	}
+	return Rule{}, false
+}
+
+// admit applies the default deadline and checks the remaining budget.
+func (e *Enforcer) admit(ctx context.Context, method string) (context.Context, context.CancelFunc, error) {
+	r, _ := e.rule(method)
+	cancel := func() {}
+	remaining, ok := Remaining(ctx)
+	if !ok {
+		if r.Default <= 0 {
+			return ctx, cancel, nil
+		}
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(r.Default))
+		remaining = time.Duration(r.Default)
+	}
+	metrics.DeadlineBudget(method, remaining)
+
+	if minimum := time.Duration(r.MinRemaining); remaining < minimum {
+		cancel()
+		metrics.DeadlineRejected(method)
+		logging.FromContext(ctx).Warn("Rejecting request with insufficient deadline",
+			slog.Duration("remaining", remaining),
+			slog.Duration("min_remaining", minimum),
+		)
+		return ctx, func() {}, ErrBudgetTooSmall(remaining, minimum)
+	}
+	return ctx, cancel, nil
+}
+
+func (e *Enforcer) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, cancel, err := e.admit(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		defer cancel()
+		return handler(ctx, req)
+	}
+}
+
+func (e *Enforcer) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, cancel, err := e.admit(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// UnaryClientInterceptor reserves margin of the caller's deadline on every
+// outgoing call, and fails without sending it when the budget is spent.
+func UnaryClientInterceptor(margin time.Duration) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, cancel, err := Reserve(ctx, margin)
+		if err != nil {
+			metrics.DeadlineRejected(method)
+			return err
+		}
+		defer cancel()
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor is UnaryClientInterceptor for streams. The reserved
+// context lives as long as the stream.
+func StreamClientInterceptor(margin time.Duration) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		if _, ok := ctx.Deadline(); !ok {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+		ctx, cancel, err := Reserve(ctx, margin)
+		if err != nil {
+			metrics.DeadlineRejected(method)
+			return nil, err
+		}
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		context.AfterFunc(cs.Context(), cancel)
+		return cs, nil
+	}
+}
+
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
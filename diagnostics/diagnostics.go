@@ -0,0 +1,105 @@
+// Package diagnostics exposes the internals of a running service for
+// debugging: gRPC server reflection, so that tools like grpcurl discover the
+// services without the proto file, channelz, which shows the state of every
+// server and client connection, and an HTTP listener with pprof profiles and
+// goroutine dumps. Everything is off unless configured.
+package diagnostics
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"time"
+
+	"google.golang.org/grpc"
+	channelz "google.golang.org/grpc/channelz/service"
+	"google.golang.org/grpc/reflection"
+)
+
+// Config enables the diagnostics.
+type Config struct {
+	// Reflection registers the gRPC reflection service.
+	Reflection bool `json:"reflection"`
+	// Channelz registers the channelz service.
+	Channelz bool `json:"channelz"`
+	// Addr is the address of the pprof HTTP listener, it is not served when
+	// empty. Without an authorization policy it must be a loopback address.
+	Addr string `json:"addr"`
+}
+
+// Enabled reports whether any diagnostics are on.
+func (c Config) Enabled() bool {
+	return c.Reflection || c.Channelz || c.Addr != ""
+}
+
+// Validate rejects an HTTP listener reachable from other hosts when its
+// requests cannot be authorized.
+func (c Config) Validate(authorized bool) error {
+	if c.Addr == "" || authorized {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(c.Addr)
+	if err != nil {
+		return fmt.Errorf("diagnostics address: %w", err)
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	return fmt.Errorf("diagnostics on %s need an authorization policy or a loopback address", c.Addr)
+}
+
+// Register adds the reflection and channelz services to s, as configured.
+func Register(s *grpc.Server, cfg Config) {
+	if cfg.Reflection {
+		reflection.Register(s)
+	}
+	if cfg.Channelz {
+		channelz.RegisterChannelzServiceToServer(s)
+	}
+}
+
+// Handler serves pprof under /debug/pprof/. The full goroutine dump is at
+// /debug/pprof/goroutine?debug=2.
+func Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+// Serve serves h on addr until ctx is done, over TLS when tlsConfig is set.
+func Serve(ctx context.Context, addr string, h http.Handler, tlsConfig *tls.Config) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if tlsConfig != nil {
+		lis = tls.NewListener(lis, tlsConfig)
+	}
+
+	// No write timeout, CPU profiles and traces take as long as requested.
+	srv := &http.Server{Handler: h, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	slog.Info("Serving diagnostics", slog.String("addr", lis.Addr().String()))
+	if err := srv.Serve(lis); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+// Package discovery resolves downstream services to one or more addresses and
+// balances calls across them. Dependencies are gRPC targets:
+//
+//	localhost:50052                   a single address
+//	static:///host1:50052,host2:50052 a fixed list
+//	dns:///payment.internal:50052     every A/AAAA record, refreshed on failure
+//	registry:///payment               the instances in the registry file
+//
+// The registry is a JSON file that services add themselves to on startup and
+// remove themselves from on shutdown. While running they renew a heartbeat in
+// it, so that the instances of a crashed process go stale and are skipped.
+// Resolvers watch it for changes.
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"grpc-test/clock"
+	"grpc-test/lib"
+	"grpc-test/random"
+
+	"google.golang.org/grpc"
+)
+
+// Config selects the registry file and the balancing policy of outgoing
+// connections.
+type Config struct {
+	// Registry is the path of the registry file, empty to disable it.
+	Registry string `json:"registry"`
+	// Advertise is the address registered for this service. It defaults to
+	// the listen address, with an unspecified host replaced by the hostname.
+	Advertise string `json:"advertise"`
+	// Balancer is "round_robin", "least_request" or "pick_first".
+	Balancer string `json:"balancer"`
+	// TTL is how long a registered instance stays in the registry without a
+	// heartbeat, 15s if 0. Instances renew it every third of the TTL.
+	TTL lib.Duration `json:"ttl"`
+}
+
+// HeartbeatTTL returns the TTL with its default.
+func (c Config) HeartbeatTTL() time.Duration {
+	if c.TTL <= 0 {
+		return 15 * time.Second
+	}
+	return time.Duration(c.TTL)
+}
+
+// watchInterval is how often resolvers check the registry file for changes.
+const watchInterval = 2 * time.Second
+
+// DialOptions returns the options that route a connection through the
+// configured resolvers and balancer. A least_request balancer draws its seed
+// from rng, and registry heartbeats go stale on clk.
+func DialOptions(cfg Config, rng random.Rand, clk clock.Clock) []grpc.DialOption {
+	var opts []grpc.DialOption
+	if cfg.Registry != "" {
+		opts = append(opts, grpc.WithResolvers(&registryBuilder{file: cfg.Registry, ttl: cfg.HeartbeatTTL(), clock: clk}))
+	}
+	if cfg.Balancer != "" {
+		balancerConfig := map[string]any{}
+		if cfg.Balancer == LeastRequest {
+			balancerConfig["seed"] = rng.Int64N(math.MaxInt64-1) + 1
+		}
+		serviceConfig, _ := json.Marshal(map[string]any{
+			"loadBalancingConfig": []map[string]any{{cfg.Balancer: balancerConfig}},
+		})
+		opts = append(opts, grpc.WithDefaultServiceConfig(string(serviceConfig)))
+	}
+	return opts
+}
+
+// Validate checks that the balancer is known.
+func (c Config) Validate() error {
+	if c.TTL < 0 {
+		return fmt.Errorf("negative registry ttl %v", c.TTL)
+	}
+	switch c.Balancer {
+	case "", "pick_first", "round_robin", LeastRequest:
+		return nil
+	}
+	return fmt.Errorf("unknown balancer %q", c.Balancer)
+}
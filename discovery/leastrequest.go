@@ -0,0 +1,128 @@
+package discovery
+
+import (
+	"encoding/json"
+	"sync/atomic"
+
+	"grpc-test/random"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/serviceconfig"
+)
+
+// LeastRequest is the name of a balancer that sends each call to the ready
+// instance with the fewest calls in flight, picking between two random
+// instances to avoid herding on the least loaded one.
+const LeastRequest = "least_request"
+
+func init() {
+	balancer.Register(leastRequestBalancerBuilder{})
+}
+
+// leastRequestConfig is the balancer config of LeastRequest, e.g.
+// {"least_request": {"seed": 42}}. The two instances compared are drawn from
+// a generator with Seed, or a random seed if 0.
+type leastRequestConfig struct {
+	serviceconfig.LoadBalancingConfig
+	Seed int64 `json:"seed"`
+}
+
+// leastRequestBalancerBuilder gives every balancer its own picker builder, so
+// that the in-flight counts of its SubConns outlive the pickers.
+type leastRequestBalancerBuilder struct{}
+
+func (leastRequestBalancerBuilder) Name() string { return LeastRequest }
+
+func (leastRequestBalancerBuilder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	pb := &leastRequestBuilder{inflight: map[balancer.SubConn]*atomic.Int64{}, rand: random.New(random.NewSeed())}
+	return &leastRequestBalancer{
+		Balancer: base.NewBalancerBuilder(LeastRequest, pb, base.Config{HealthCheck: true}).Build(cc, opts),
+		pickers:  pb,
+	}
+}
+
+func (leastRequestBalancerBuilder) ParseConfig(js json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+	cfg := &leastRequestConfig{}
+	if err := json.Unmarshal(js, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// leastRequestBalancer is a base balancer that seeds its pickers from the
+// config.
+type leastRequestBalancer struct {
+	balancer.Balancer
+	pickers *leastRequestBuilder
+	seed    int64
+}
+
+func (b *leastRequestBalancer) UpdateClientConnState(s balancer.ClientConnState) error {
+	// Called from the same goroutine as the picker builder
+	if cfg, ok := s.BalancerConfig.(*leastRequestConfig); ok && cfg.Seed != 0 && cfg.Seed != b.seed {
+		b.seed = cfg.Seed
+		b.pickers.rand = random.New(cfg.Seed)
+	}
+	return b.Balancer.UpdateClientConnState(s)
+}
+
+func (b *leastRequestBalancer) ExitIdle() {
+	b.Balancer.(balancer.ExitIdler).ExitIdle()
+}
+
+// leastRequestBuilder builds the pickers of one balancer, which calls Build
+// from a single goroutine.
+type leastRequestBuilder struct {
+	// inflight counts the calls in flight per ready SubConn, across pickers.
+	// Calls finishing on a SubConn that is no longer ready decrement a count
+	// that was dropped.
+	inflight map[balancer.SubConn]*atomic.Int64
+	rand     random.Rand
+}
+
+func (b *leastRequestBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	for sc := range b.inflight {
+		if _, ok := info.ReadySCs[sc]; !ok {
+			delete(b.inflight, sc)
+		}
+	}
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+	p := &leastRequestPicker{rand: b.rand}
+	for sc := range info.ReadySCs {
+		inflight, ok := b.inflight[sc]
+		if !ok {
+			inflight = &atomic.Int64{}
+			b.inflight[sc] = inflight
+		}
+		p.subConns = append(p.subConns, counted{SubConn: sc, inflight: inflight})
+	}
+	return p
+}
+
+type counted struct {
+	balancer.SubConn
+	inflight *atomic.Int64
+}
+
+type leastRequestPicker struct {
+	subConns []counted
+	rand     random.Rand
+}
+
+func (p *leastRequestPicker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	sc := p.subConns[p.rand.IntN(len(p.subConns))]
+	if len(p.subConns) > 1 {
+		other := p.subConns[p.rand.IntN(len(p.subConns))]
+		if other.inflight.Load() < sc.inflight.Load() {
+			sc = other
+		}
+	}
+	sc.inflight.Add(1)
+	return balancer.PickResult{
+		SubConn: sc.SubConn,
+		Done:    func(balancer.DoneInfo) { sc.inflight.Add(-1) },
+	}, nil
+}
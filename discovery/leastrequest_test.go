@@ -0,0 +1,65 @@
+package discovery
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"grpc-test/random"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+)
+
+type fakeSubConn struct {
+	balancer.SubConn
+	name string
+}
+
+func buildInfo(scs ...*fakeSubConn) base.PickerBuildInfo {
+	info := base.PickerBuildInfo{ReadySCs: map[balancer.SubConn]base.SubConnInfo{}}
+	for _, sc := range scs {
+		info.ReadySCs[sc] = base.SubConnInfo{}
+	}
+	return info
+}
+
+func inflight(p balancer.Picker) map[string]int64 {
+	counts := map[string]int64{}
+	for _, sc := range p.(*leastRequestPicker).subConns {
+		counts[sc.SubConn.(*fakeSubConn).name] = sc.inflight.Load()
+	}
+	return counts
+}
+
+func TestLeastRequestKeepsCountsAcrossPickers(t *testing.T) {
+	a, b, c := &fakeSubConn{name: "a"}, &fakeSubConn{name: "b"}, &fakeSubConn{name: "c"}
+	builder := &leastRequestBuilder{inflight: map[balancer.SubConn]*atomic.Int64{}, rand: random.New(1)}
+	p := builder.Build(buildInfo(a, b))
+	var done []func(balancer.DoneInfo)
+	for range 10 {
+		res, err := p.Pick(balancer.PickInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		done = append(done, res.Done)
+	}
+	before := inflight(p)
+
+	// A new instance becoming ready rebuilds the picker
+	p = builder.Build(buildInfo(a, b, c))
+	after := inflight(p)
+	if after["a"] != before["a"] || after["b"] != before["b"] || after["c"] != 0 {
+		t.Errorf("in flight after rebuild = %v, want %v and none on c", after, before)
+	}
+	for _, d := range done {
+		d(balancer.DoneInfo{})
+	}
+	if got := inflight(p); got["a"]+got["b"]+got["c"] != 0 {
+		t.Errorf("in flight once done = %v, want none", got)
+	}
+
+	builder.Build(buildInfo(c))
+	if len(builder.inflight) != 1 {
+		t.Errorf("counts kept for %d SubConns, want 1 for the ready one", len(builder.inflight))
+	}
+}
@@ -0,0 +1,193 @@
+package discovery
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// Registry maps service names to the addresses of their instances.
+type Registry struct {
+	Services map[string][]string `json:"services"`
+	// Heartbeats hold the time registered instances last renewed their entry,
+	// by service and address. Instances added by hand have none and never go
+	// stale.
+	Heartbeats map[string]map[string]time.Time `json:"heartbeats,omitempty"`
+}
+
+// Live returns the instances of service, without those whose last heartbeat is
+// older than ttl at now.
+func (r Registry) Live(service string, now time.Time, ttl time.Duration) []string {
+	return slices.DeleteFunc(slices.Clone(r.Services[service]), func(addr string) bool {
+		return r.stale(service, addr, now, ttl)
+	})
+}
+
+func (r Registry) stale(service, addr string, now time.Time, ttl time.Duration) bool {
+	beat, ok := r.Heartbeats[service][addr]
+	return ok && now.Sub(beat) > ttl
+}
+
+// expiry returns when the next live instance of service goes stale, zero if
+// none will.
+func (r Registry) expiry(service string, now time.Time, ttl time.Duration) time.Time {
+	var next time.Time
+	for _, addr := range r.Services[service] {
+		beat, ok := r.Heartbeats[service][addr]
+		if !ok || r.stale(service, addr, now, ttl) {
+			continue
+		}
+		if at := beat.Add(ttl); next.IsZero() || at.Before(next) {
+			next = at
+		}
+	}
+	return next
+}
+
+// LoadRegistry reads a registry file. A missing file is an empty registry.
+func LoadRegistry(file string) (Registry, error) {
+	reg := Registry{Services: map[string][]string{}, Heartbeats: map[string]map[string]time.Time{}}
+	data, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return reg, nil
+	}
+	if err != nil {
+		return reg, fmt.Errorf("read registry: %w", err)
+	}
+	if len(data) == 0 {
+		return reg, nil
+	}
+	if err := json.Unmarshal(data, &reg); err != nil {
+		return reg, fmt.Errorf("parse registry %s: %w", file, err)
+	}
+	if reg.Services == nil {
+		reg.Services = map[string][]string{}
+	}
+	if reg.Heartbeats == nil {
+		reg.Heartbeats = map[string]map[string]time.Time{}
+	}
+	return reg, nil
+}
+
+// Register adds addr to the instances of service in the registry file, or
+// renews its heartbeat if it is there. Instances of any service whose
+// heartbeat is older than ttl are removed, as they are gone.
+func Register(file, service, addr string, now time.Time, ttl time.Duration) error {
+	return update(file, func(reg Registry) {
+		for s, beats := range reg.Heartbeats {
+			for a := range beats {
+				if reg.stale(s, a, now, ttl) {
+					remove(reg, s, a)
+				}
+			}
+		}
+		if !slices.Contains(reg.Services[service], addr) {
+			reg.Services[service] = append(reg.Services[service], addr)
+		}
+		if reg.Heartbeats[service] == nil {
+			reg.Heartbeats[service] = map[string]time.Time{}
+		}
+		reg.Heartbeats[service][addr] = now.UTC()
+	})
+}
+
+// Deregister removes addr from the instances of service.
+func Deregister(file, service, addr string) error {
+	return update(file, func(reg Registry) { remove(reg, service, addr) })
+}
+
+func remove(reg Registry, service, addr string) {
+	reg.Services[service] = slices.DeleteFunc(reg.Services[service], func(a string) bool { return a == addr })
+	if len(reg.Services[service]) == 0 {
+		delete(reg.Services, service)
+	}
+	delete(reg.Heartbeats[service], addr)
+	if len(reg.Heartbeats[service]) == 0 {
+		delete(reg.Heartbeats, service)
+	}
+}
+
+// update applies fn to the registry under a lock file shared by every
+// process, then replaces the file atomically so that watchers never read a
+// partial write.
+func update(file string, fn func(Registry)) error {
+	unlock, err := lock(file + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	reg, err := LoadRegistry(file)
+	if err != nil {
+		return err
+	}
+	fn(reg)
+	data, err := json.MarshalIndent(reg, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*")
+	if err != nil {
+		return fmt.Errorf("write registry: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write registry: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write registry: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		return fmt.Errorf("write registry: %w", err)
+	}
+	return nil
+}
+
+// lockStale is the age after which a lock left behind by a crashed process is
+// broken.
+const lockStale = 10 * time.Second
+
+func lock(name string) (unlock func(), err error) {
+	deadline := time.Now().Add(2 * lockStale)
+	for {
+		f, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(name) }, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, fmt.Errorf("lock registry: %w", err)
+		}
+		if info, err := os.Stat(name); err == nil && time.Since(info.ModTime()) > lockStale {
+			os.Remove(name)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("lock registry: %s is held by another process", name)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// AdvertiseAddr returns the address other services should dial to reach a
+// listener on addr.
+func AdvertiseAddr(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		if host, err = os.Hostname(); err != nil {
+			return "", err
+		}
+	}
+	return net.JoinHostPort(host, port), nil
+}
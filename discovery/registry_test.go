@@ -0,0 +1,114 @@
+package discovery
+
+import (
+	"net/url"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"grpc-test/clock"
+
+	"google.golang.org/grpc/resolver"
+)
+
+const ttl = 15 * time.Second
+
+func TestLiveSkipsStaleInstances(t *testing.T) {
+	now := time.Unix(1000, 0)
+	reg := Registry{
+		Services: map[string][]string{"payment": {"a:1", "b:1", "c:1"}},
+		Heartbeats: map[string]map[string]time.Time{"payment": {
+			"a:1": now.Add(-time.Second),
+			"b:1": now.Add(-time.Minute),
+		}},
+	}
+	// c:1 was added by hand and has no heartbeat
+	if got, want := reg.Live("payment", now, ttl), []string{"a:1", "c:1"}; !slices.Equal(got, want) {
+		t.Errorf("Live() = %v, want %v", got, want)
+	}
+	if got, want := reg.expiry("payment", now, ttl), now.Add(ttl-time.Second); !got.Equal(want) {
+		t.Errorf("expiry() = %v, want %v", got, want)
+	}
+}
+
+func TestRegisterRenewsAndPrunes(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "services.json")
+	start := time.Unix(1000, 0)
+	if err := Register(file, "payment", "a:1", start, ttl); err != nil {
+		t.Fatal(err)
+	}
+	if err := Register(file, "payment", "b:1", start, ttl); err != nil {
+		t.Fatal(err)
+	}
+	// Only b renews, so a is pruned once its heartbeat is older than the TTL
+	later := start.Add(ttl + time.Second)
+	if err := Register(file, "payment", "b:1", later, ttl); err != nil {
+		t.Fatal(err)
+	}
+	reg, err := LoadRegistry(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reg.Services["payment"]; !slices.Equal(got, []string{"b:1"}) {
+		t.Errorf("instances = %v, want [b:1]", got)
+	}
+	if beat := reg.Heartbeats["payment"]["b:1"]; !beat.Equal(later) {
+		t.Errorf("heartbeat of b:1 = %v, want %v", beat, later)
+	}
+}
+
+// fakeClientConn records the addresses a resolver pushes.
+type fakeClientConn struct {
+	resolver.ClientConn
+	states chan []string
+}
+
+func (cc *fakeClientConn) UpdateState(s resolver.State) error {
+	var addrs []string
+	for _, a := range s.Addresses {
+		addrs = append(addrs, a.Addr)
+	}
+	cc.states <- addrs
+	return nil
+}
+
+func (cc *fakeClientConn) ReportError(err error) {}
+
+func TestResolverDropsInstancesWithoutHeartbeat(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "services.json")
+	start := time.Unix(1000, 0)
+	clk := clock.NewFake(start)
+	if err := Register(file, "payment", "b:1", start, ttl); err != nil {
+		t.Fatal(err)
+	}
+	if err := Register(file, "payment", "a:1", start.Add(5*time.Second), ttl); err != nil {
+		t.Fatal(err)
+	}
+
+	cc := &fakeClientConn{states: make(chan []string, 10)}
+	target := resolver.Target{URL: url.URL{Scheme: "registry", Path: "/payment"}}
+	r, err := (&registryBuilder{file: file, ttl: ttl, clock: clk}).Build(target, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if got := <-cc.states; !slices.Equal(got, []string{"a:1", "b:1"}) {
+		t.Fatalf("resolved %v, want [a:1 b:1]", got)
+	}
+
+	// b crashed and the file no longer changes, but b is dropped once its
+	// heartbeat is older than the TTL
+	clk.BlockUntil(1)
+	for clk.Now().Sub(start) <= ttl {
+		clk.Advance(watchInterval)
+	}
+	select {
+	case got := <-cc.states:
+		if !slices.Equal(got, []string{"a:1"}) {
+			t.Errorf("resolved %v, want [a:1]", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stale instance was not dropped")
+	}
+}
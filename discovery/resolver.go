@@ -0,0 +1,147 @@
+package discovery
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"grpc-test/clock"
+
+	"google.golang.org/grpc/resolver"
+)
+
+func init() {
+	resolver.Register(staticBuilder{})
+}
+
+// staticBuilder resolves "static:///addr1,addr2" to a fixed list.
+type staticBuilder struct{}
+
+func (staticBuilder) Scheme() string { return "static" }
+
+func (staticBuilder) Build(target resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions) (resolver.Resolver, error) {
+	var addrs []string
+	for _, a := range strings.Split(target.Endpoint(), ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			addrs = append(addrs, a)
+		}
+	}
+	if err := cc.UpdateState(resolver.State{Addresses: addresses(addrs)}); err != nil {
+		return nil, err
+	}
+	return nopResolver{}, nil
+}
+
+type nopResolver struct{}
+
+func (nopResolver) ResolveNow(resolver.ResolveNowOptions) {}
+func (nopResolver) Close()                                {}
+
+// registryBuilder resolves "registry:///service" to the instances in a
+// registry file, and follows its changes.
+type registryBuilder struct {
+	file  string
+	ttl   time.Duration
+	clock clock.Clock
+}
+
+func (b *registryBuilder) Scheme() string { return "registry" }
+
+func (b *registryBuilder) Build(target resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions) (resolver.Resolver, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &registryResolver{
+		file:    b.file,
+		ttl:     b.ttl,
+		clock:   b.clock,
+		service: target.Endpoint(),
+		cc:      cc,
+		cancel:  cancel,
+		now:     make(chan struct{}, 1),
+	}
+	r.resolve()
+	go r.watch(ctx)
+	return r, nil
+}
+
+type registryResolver struct {
+	file    string
+	ttl     time.Duration
+	clock   clock.Clock
+	service string
+	cc      resolver.ClientConn
+	cancel  context.CancelFunc
+	now     chan struct{}
+
+	last    []string
+	modTime time.Time
+	expiry  time.Time // when the next instance goes stale, zero if none will
+}
+
+func (r *registryResolver) ResolveNow(resolver.ResolveNowOptions) {
+	select {
+	case r.now <- struct{}{}:
+	default:
+	}
+}
+
+func (r *registryResolver) Close() {
+	r.cancel()
+}
+
+func (r *registryResolver) watch(ctx context.Context) {
+	ticker := r.clock.NewTicker(watchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			// A crashed instance stops renewing its heartbeat without
+			// changing the file
+			expired := !r.expiry.IsZero() && !r.clock.Now().Before(r.expiry)
+			if info, err := os.Stat(r.file); err == nil && info.ModTime().Equal(r.modTime) && !expired {
+				continue
+			}
+		case <-r.now:
+		}
+		r.resolve()
+	}
+}
+
+// resolve reads the registry and pushes the instances of the service to the
+// connection if they changed.
+func (r *registryResolver) resolve() {
+	if info, err := os.Stat(r.file); err == nil {
+		r.modTime = info.ModTime()
+	}
+	reg, err := LoadRegistry(r.file)
+	if err != nil {
+		r.cc.ReportError(err)
+		return
+	}
+	now := r.clock.Now()
+	addrs := reg.Live(r.service, now, r.ttl)
+	r.expiry = reg.expiry(r.service, now, r.ttl)
+	slices.Sort(addrs)
+	if r.last != nil && slices.Equal(addrs, r.last) {
+		return
+	}
+	r.last = addrs
+	if len(addrs) == 0 {
+		slog.Warn("No instances registered", slog.String("service", r.service), slog.String("registry", r.file))
+	} else {
+		slog.Info("Resolved instances", slog.String("service", r.service), slog.Any("addrs", addrs))
+	}
+	r.cc.UpdateState(resolver.State{Addresses: addresses(addrs)})
+}
+
+func addresses(addrs []string) []resolver.Address {
+	out := make([]resolver.Address, len(addrs))
+	for i, a := range addrs {
+		out[i] = resolver.Address{Addr: a}
+	}
+	return out
+}
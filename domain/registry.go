@@ -0,0 +1,30 @@
+package domain
+
+import (
+	"grpc-test/lib"
+	pb "grpc-test/proto"
+)
+
+// MethodErrors are the AppErrors each method returns from its handler, by
+// full method name. Errors of the interceptors in front of every handler are
+// declared by the contract package. Contracts are derived from both, so a
+// handler that starts returning a new error must be listed here.
+var MethodErrors = map[string][]func() error{
+	pb.Order_PlaceOrder_FullMethodName: {
+		// Declines of ChargeCustomer are passed through.
+		ErrNotEnoughCredit,
+		ErrGatewayNotReachable,
+		func() error { return lib.ErrServiceUnavailable() },
+	},
+	pb.Order_GetOrder_FullMethodName: {
+		func() error { return lib.ErrNotFound() },
+	},
+	pb.Order_CancelOrder_FullMethodName: {
+		func() error { return lib.ErrNotFound() },
+		func() error { return lib.ErrBadRequest() },
+	},
+	pb.Charge_ChargeCustomer_FullMethodName: {
+		ErrNotEnoughCredit,
+		ErrGatewayNotReachable,
+	},
+}
@@ -0,0 +1,192 @@
+// Package faults injects failures into calls to rehearse incidents: latency,
+// gRPC status codes, AppErrors with their details, panics and streams that
+// break off after a number of messages. Rules apply per method on the server,
+// on the client or both, and can be changed at runtime through a file or the
+// FaultInjection service.
+package faults
+
+import (
+	"fmt"
+	"path"
+	"sync"
+
+	"grpc-test/clock"
+	"grpc-test/domain"
+	"grpc-test/lib"
+	"grpc-test/random"
+
+	"google.golang.org/grpc/codes"
+)
+
+// Config enables fault injection. When File is set, rules are read from it and
+// re-read whenever it changes. Seed, when set, replaces the seed of the run for
+// fault decisions.
+type Config struct {
+	Enabled bool   `json:"enabled"`
+	File    string `json:"file"`
+	Seed    int64  `json:"seed"`
+	Rules   []Rule `json:"rules"`
+}
+
+// Active reports whether faults may be injected.
+func (c Config) Active() bool {
+	return c.Enabled || c.File != ""
+}
+
+// Sides a rule applies to.
+const (
+	Server = "server"
+	Client = "client"
+)
+
+// Rule injects a fault into the calls to the matching methods. Rules are tried
+// in order and the first one that matches and wins its probability applies.
+// Latency is added before the call. At most one of Code, Error and Panic fails
+// the call, or with AbortAfter breaks off its stream.
+type Rule struct {
+	// Methods are path.Match patterns such as "/service.Charge/*".
+	Methods []string `json:"methods"`
+	// Side is Server, Client or empty for both.
+	Side string `json:"side"`
+	// Probability of injecting the fault into a call, in (0, 1].
+	Probability float64      `json:"probability"`
+	Latency     lib.Duration `json:"latency"`
+	// Code is a gRPC code name, e.g. "Unavailable".
+	Code string `json:"code"`
+	// Error is the name of an AppError in Errors, e.g. "ErrNotEnoughCredit".
+	Error string `json:"error"`
+	// Message replaces the message of the error or panic.
+	Message string `json:"message"`
+	// Panic panics in the handler, servers only.
+	Panic bool `json:"panic"`
+	// AbortAfter lets this many stream messages through before failing with
+	// Code or Error, Unavailable by default. Zero fails at once.
+	AbortAfter int `json:"abort_after"`
+}
+
+// Errors are the AppErrors rules can inject, by name.
+var Errors = map[string]func() error{
+	"ErrNotEnoughCredit":        domain.ErrNotEnoughCredit,
+	"ErrGatewayNotReachable":    domain.ErrGatewayNotReachable,
+	"ProductNotFound":           domain.ProductNotFoundErr,
+	lib.NameNotFound:            func() error { return lib.ErrNotFound() },
+	lib.NameInternalServerError: func() error { return lib.ErrInternalServerError() },
+	lib.NameTooManyRequests:     func() error { return lib.ErrTooManyRequests() },
+	lib.NameServiceUnavailable:  func() error { return lib.ErrServiceUnavailable() },
+	lib.NameDeadlineExceeded:    func() error { return lib.ErrDeadlineExceeded() },
+}
+
+// Validate checks a rule.
+func (r Rule) Validate() error {
+	if len(r.Methods) == 0 {
+		return fmt.Errorf("fault rule without methods")
+	}
+	for _, m := range r.Methods {
+		if _, err := path.Match(m, ""); err != nil {
+			return fmt.Errorf("invalid method pattern %q: %w", m, err)
+		}
+	}
+	if r.Side != "" && r.Side != Server && r.Side != Client {
+		return fmt.Errorf("invalid side %q, want %q or %q", r.Side, Server, Client)
+	}
+	if r.Probability <= 0 || r.Probability > 1 {
+		return fmt.Errorf("probability %g of %v is not in (0, 1]", r.Probability, r.Methods)
+	}
+	failures := 0
+	if r.Code != "" {
+		if _, ok := parseCode(r.Code); !ok {
+			return fmt.Errorf("unknown gRPC code %q", r.Code)
+		}
+		failures++
+	}
+	if r.Error != "" {
+		if _, ok := Errors[r.Error]; !ok {
+			return fmt.Errorf("unknown error %q", r.Error)
+		}
+		failures++
+	}
+	if r.Panic {
+		if r.Side == Client {
+			return fmt.Errorf("panics are only injected on the server")
+		}
+		failures++
+	}
+	if failures > 1 {
+		return fmt.Errorf("fault rule for %v sets more than one of code, error and panic", r.Methods)
+	}
+	if r.AbortAfter < 0 {
+		return fmt.Errorf("negative abort_after %d", r.AbortAfter)
+	}
+	if r.AbortAfter > 0 && r.Panic {
+		return fmt.Errorf("fault rule for %v sets both abort_after and panic", r.Methods)
+	}
+	return nil
+}
+
+func parseCode(name string) (codes.Code, bool) {
+	for c := codes.OK; c <= codes.Unauthenticated; c++ {
+		if c.String() == name {
+			return c, true
+		}
+	}
+	return 0, false
+}
+
+// Injector decides which calls get a fault.
+type Injector struct {
+	mu    sync.Mutex
+	rules []Rule
+	rng   random.Rand
+	clock clock.Clock
+}
+
+// NewInjector returns an injector with rules. Given the same random numbers
+// and calls, it injects the same faults.
+func NewInjector(rules []Rule, rng random.Rand, clk clock.Clock) (*Injector, error) {
+	i := &Injector{rng: rng, clock: clk}
+	if err := i.Update(rules); err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// Update validates and replaces the rules.
+func (i *Injector) Update(rules []Rule) error {
+	for _, r := range rules {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.rules = rules
+	return nil
+}
+
+// Rules returns the rules in effect.
+func (i *Injector) Rules() []Rule {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.rules
+}
+
+// pick returns the fault to inject into a call to method on side, if any.
+func (i *Injector) pick(method, side string) (Rule, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for _, r := range i.rules {
+		if (r.Side == "" || r.Side == side) && matches(r.Methods, method) && i.rng.Float64() < r.Probability {
+			return r, true
+		}
+	}
+	return Rule{}, false
+}
+
+func matches(patterns []string, method string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, method); ok {
+			return true
+		}
+	}
+	return false
+}
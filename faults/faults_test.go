@@ -0,0 +1,110 @@
+package faults
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"grpc-test/clock"
+	"grpc-test/random"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newTestInjector(t *testing.T, seed int64, rules ...Rule) *Injector {
+	i, err := NewInjector(rules, random.New(seed), clock.NewFake(time.Unix(0, 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return i
+}
+
+func TestPickMatchesMethodAndSide(t *testing.T) {
+	rules := []Rule{
+		{Methods: []string{"/service.Charge/*"}, Side: Server, Probability: 1, Code: "Unavailable"},
+		{Methods: []string{"/service.Currency/SendExchangeRates"}, Probability: 1, AbortAfter: 3},
+		{Methods: []string{"/service.Charge/ChargeCustomer"}, Probability: 1, Error: "ErrNotEnoughCredit"},
+	}
+	i := newTestInjector(t, 1, rules...)
+	tests := []struct {
+		method, side string
+		wantRule     int // index in the rules, -1 for none
+	}{
+		{"/service.Charge/ChargeCustomer", Server, 0},
+		{"/service.Charge/ChargeCustomer", Client, 2},
+		{"/service.Currency/SendExchangeRates", Client, 1},
+		{"/service.Currency/SendExchangeRates", Server, 1},
+		{"/service.Order/PlaceOrder", Server, -1},
+	}
+	for _, tt := range tests {
+		r, ok := i.pick(tt.method, tt.side)
+		switch {
+		case tt.wantRule < 0 && ok:
+			t.Errorf("pick(%s, %s) = %v, want no fault", tt.method, tt.side, r)
+		case tt.wantRule >= 0 && (!ok || !reflect.DeepEqual(r, rules[tt.wantRule])):
+			t.Errorf("pick(%s, %s) = %v, %v, want rule %d", tt.method, tt.side, r, ok, tt.wantRule)
+		}
+	}
+}
+
+func TestProbabilityFollowsSeed(t *testing.T) {
+	rule := Rule{Methods: []string{"/service.Charge/*"}, Probability: 0.3, Code: "Unavailable"}
+	decisions := func(seed int64) []bool {
+		i := newTestInjector(t, seed, rule)
+		var d []bool
+		for range 1000 {
+			_, ok := i.pick("/service.Charge/ChargeCustomer", Server)
+			d = append(d, ok)
+		}
+		return d
+	}
+
+	a, b := decisions(7), decisions(7)
+	injected := 0
+	for n := range a {
+		if a[n] != b[n] {
+			t.Fatalf("decision %d differs between runs with the same seed", n)
+		}
+		if a[n] {
+			injected++
+		}
+	}
+	if injected < 250 || injected > 350 {
+		t.Errorf("injected %d of 1000 calls, want about 300", injected)
+	}
+}
+
+// sendStream is a server stream that counts the messages sent.
+type sendStream struct {
+	grpc.ServerStream
+	sent int
+}
+
+func (s *sendStream) Context() context.Context { return context.Background() }
+
+func (s *sendStream) SendMsg(any) error {
+	s.sent++
+	return nil
+}
+
+func TestStreamAbortAfter(t *testing.T) {
+	i := newTestInjector(t, 1, Rule{Methods: []string{"/service.Currency/SendExchangeRates"}, Side: Server, Probability: 1, AbortAfter: 3})
+	ss := &sendStream{}
+	info := &grpc.StreamServerInfo{FullMethod: "/service.Currency/SendExchangeRates", IsServerStream: true}
+	err := i.StreamServerInterceptor()(nil, ss, info, func(_ any, stream grpc.ServerStream) error {
+		for {
+			if err := stream.SendMsg(struct{}{}); err != nil {
+				return err
+			}
+		}
+	})
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("stream error = %v, want Unavailable", err)
+	}
+	if ss.sent != 3 {
+		t.Errorf("sent = %d, want 3", ss.sent)
+	}
+}
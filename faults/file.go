@@ -0,0 +1,58 @@
+package faults
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// LoadRules reads the rules of a faults file.
+func LoadRules(file string) ([]Rule, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read faults: %w", err)
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parse faults %s: %w", file, err)
+	}
+	return cfg.Rules, nil
+}
+
+// WatchFile applies the rules of file to i whenever it changes, until ctx is
+// done. Invalid files are logged and ignored.
+func (i *Injector) WatchFile(ctx context.Context, file string, interval time.Duration) {
+	var last time.Time
+	if info, err := os.Stat(file); err == nil {
+		last = info.ModTime()
+	}
+
+	ticker := i.clock.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+
+		info, err := os.Stat(file)
+		if err != nil || info.ModTime().Equal(last) {
+			continue
+		}
+		last = info.ModTime()
+
+		rules, err := LoadRules(file)
+		if err == nil {
+			err = i.Update(rules)
+		}
+		if err != nil {
+			slog.Error("Failed to reload faults", slog.Any("error", err))
+			continue
+		}
+		slog.Info("Reloaded faults", slog.String("file", file), slog.Int("rules", len(rules)))
+	}
+}
@@ -0,0 +1,211 @@
+package faults
+
+import (
+	"context"
+	"time"
+
+	"grpc-test/metrics"
+
+	"github.com/revotech-group/go-lib/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryServerInterceptor injects server faults into unary RPCs. Panics are
+// raised inside the chain so that go-lib's interceptor recovers them.
+func (i *Injector) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		r, ok := i.inject(info.FullMethod, Server)
+		if !ok {
+			return handler(ctx, req)
+		}
+		if err := i.apply(ctx, r); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor injects server faults into streams. Streams with
+// AbortAfter fail the send after the last allowed message.
+func (i *Injector) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		r, ok := i.inject(info.FullMethod, Server)
+		if !ok {
+			return handler(srv, ss)
+		}
+		if r.AbortAfter == 0 {
+			if err := i.apply(ss.Context(), r); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}
+		if err := i.delay(ss.Context(), time.Duration(r.Latency)); err != nil {
+			return err
+		}
+		return handler(srv, &abortServerStream{ServerStream: ss, left: r.AbortAfter, err: r.abortErr()})
+	}
+}
+
+// UnaryClientInterceptor injects client faults into outgoing unary calls. It
+// returns AppErrors as is, as go-lib's client interceptor would.
+func (i *Injector) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		r, ok := i.inject(method, Client)
+		if !ok {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		if err := i.apply(ctx, r); err != nil {
+			return err
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor injects client faults into outgoing streams.
+// Streams with AbortAfter are cancelled after the last allowed message.
+func (i *Injector) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		r, ok := i.inject(method, Client)
+		if !ok {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+		if r.AbortAfter == 0 {
+			if err := i.apply(ctx, r); err != nil {
+				return nil, err
+			}
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+		if err := i.delay(ctx, time.Duration(r.Latency)); err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithCancel(ctx)
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		return &abortClientStream{ClientStream: cs, left: r.AbortAfter, err: r.abortErr(), cancel: cancel}, nil
+	}
+}
+
+// inject picks the fault of a call and counts it.
+func (i *Injector) inject(method, side string) (Rule, bool) {
+	r, ok := i.pick(method, side)
+	if ok {
+		metrics.FaultInjected(method, side, r.kind())
+	}
+	return r, ok
+}
+
+// apply waits for the latency of r, then panics or returns its error.
+func (i *Injector) apply(ctx context.Context, r Rule) error {
+	if err := i.delay(ctx, time.Duration(r.Latency)); err != nil {
+		return err
+	}
+	if r.Panic {
+		msg := r.Message
+		if msg == "" {
+			msg = "fault injected"
+		}
+		panic(msg)
+	}
+	return r.err()
+}
+
+// err returns the error of r, nil if it only adds latency.
+func (r Rule) err() error {
+	switch {
+	case r.Error != "":
+		err := Errors[r.Error]()
+		if appErr, ok := err.(errors.AppError); ok && r.Message != "" {
+			err = appErr.WithMessage(r.Message)
+		}
+		return err
+	case r.Code != "":
+		code, _ := parseCode(r.Code)
+		msg := r.Message
+		if msg == "" {
+			msg = "fault injected"
+		}
+		return status.Error(code, msg)
+	}
+	return nil
+}
+
+func (r Rule) abortErr() error {
+	if err := r.err(); err != nil {
+		return err
+	}
+	msg := r.Message
+	if msg == "" {
+		msg = "stream interrupted by fault injection"
+	}
+	return status.Error(codes.Unavailable, msg)
+}
+
+func (r Rule) kind() string {
+	switch {
+	case r.Panic:
+		return "panic"
+	case r.AbortAfter > 0:
+		return "abort"
+	case r.Error != "":
+		return "error"
+	case r.Code != "":
+		return "code"
+	}
+	return "latency"
+}
+
+func (i *Injector) delay(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	t := i.clock.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	case <-t.C():
+		return nil
+	}
+}
+
+// abortServerStream fails sends once left messages have been sent.
+type abortServerStream struct {
+	grpc.ServerStream
+	left int
+	err  error
+}
+
+func (s *abortServerStream) SendMsg(m any) error {
+	if s.left == 0 {
+		return s.err
+	}
+	s.left--
+	return s.ServerStream.SendMsg(m)
+}
+
+// abortClientStream cancels the stream once left messages have been received.
+type abortClientStream struct {
+	grpc.ClientStream
+	left   int
+	err    error
+	cancel context.CancelFunc
+}
+
+func (s *abortClientStream) RecvMsg(m any) error {
+	if s.left == 0 {
+		s.cancel()
+		return s.err
+	}
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil {
+		s.cancel()
+		return err
+	}
+	s.left--
+	return nil
+}
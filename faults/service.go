@@ -0,0 +1,74 @@
+package faults
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"grpc-test/lib"
+	"grpc-test/logging"
+	pb "grpc-test/proto"
+
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Service implements pb.FaultInjectionServer on top of an Injector.
+type Service struct {
+	pb.UnimplementedFaultInjectionServer
+	injector *Injector
+}
+
+func NewService(i *Injector) *Service {
+	return &Service{injector: i}
+}
+
+func (s *Service) GetFaults(context.Context, *pb.Empty) (*pb.FaultRules, error) {
+	return ToProto(s.injector.Rules()), nil
+}
+
+func (s *Service) SetFaults(ctx context.Context, req *pb.FaultRules) (*pb.FaultRules, error) {
+	rules := FromProto(req)
+	if err := s.injector.Update(rules); err != nil {
+		return nil, lib.ErrBadRequest().WithMessage(err.Error())
+	}
+	logging.FromContext(ctx).Warn("Fault rules replaced", slog.Int("rules", len(rules)))
+	return ToProto(rules), nil
+}
+
+// FromProto converts the rules of a SetFaults request.
+func FromProto(req *pb.FaultRules) []Rule {
+	rules := make([]Rule, len(req.Rules))
+	for i, r := range req.Rules {
+		rules[i] = Rule{
+			Methods:     r.Methods,
+			Side:        r.Side,
+			Probability: r.Probability,
+			Latency:     lib.Duration(r.Latency.AsDuration()),
+			Code:        r.Code,
+			Error:       r.Error,
+			Message:     r.Message,
+			Panic:       r.Panic,
+			AbortAfter:  int(r.AbortAfter),
+		}
+	}
+	return rules
+}
+
+// ToProto converts rules for the FaultInjection service.
+func ToProto(rules []Rule) *pb.FaultRules {
+	resp := &pb.FaultRules{}
+	for _, r := range rules {
+		resp.Rules = append(resp.Rules, &pb.FaultRule{
+			Methods:     r.Methods,
+			Side:        r.Side,
+			Probability: r.Probability,
+			Latency:     durationpb.New(time.Duration(r.Latency)),
+			Code:        r.Code,
+			Error:       r.Error,
+			Message:     r.Message,
+			Panic:       r.Panic,
+			AbortAfter:  int32(r.AbortAfter),
+		})
+	}
+	return resp
+}
@@ -0,0 +1,114 @@
+// Package features holds the feature flags of a service. Services define
+// their flags with a default when they start, the configuration overrides
+// the defaults, and the Admin service flips them at runtime.
+package features
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Flag is a feature that can be turned on or off at runtime.
+type Flag struct {
+	Name        string
+	Description string
+
+	enabled  atomic.Bool
+	mu       sync.Mutex
+	onChange []func(bool)
+}
+
+// Enabled reports whether the feature is on.
+func (f *Flag) Enabled() bool {
+	return f.enabled.Load()
+}
+
+// OnChange registers fn to be called with the new value whenever the flag is
+// flipped.
+func (f *Flag) OnChange(fn func(enabled bool)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.onChange = append(f.onChange, fn)
+}
+
+func (f *Flag) set(on bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.enabled.Swap(on) == on {
+		return
+	}
+	for _, fn := range f.onChange {
+		fn(on)
+	}
+}
+
+// Set is the flags of a service.
+type Set struct {
+	mu         sync.RWMutex
+	flags      map[string]*Flag
+	configured map[string]bool
+}
+
+// NewSet returns a set whose flags take the values of configured, by name,
+// instead of their defaults.
+func NewSet(configured map[string]bool) *Set {
+	return &Set{flags: map[string]*Flag{}, configured: configured}
+}
+
+// Define adds a flag, or returns the flag of that name if it exists.
+func (s *Set) Define(name, description string, enabled bool) *Flag {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f, ok := s.flags[name]; ok {
+		return f
+	}
+	if on, ok := s.configured[name]; ok {
+		enabled = on
+	}
+	f := &Flag{Name: name, Description: description}
+	f.enabled.Store(enabled)
+	s.flags[name] = f
+	return f
+}
+
+// Set turns a flag on or off.
+func (s *Set) Set(name string, on bool) error {
+	s.mu.RLock()
+	f, ok := s.flags[name]
+	s.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("unknown feature flag %q", name)
+	}
+	f.set(on)
+	slog.Warn("Feature flag changed", slog.String("flag", name), slog.Bool("enabled", on))
+	return nil
+}
+
+// List returns the flags sorted by name.
+func (s *Set) List() []*Flag {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	flags := make([]*Flag, 0, len(s.flags))
+	for _, f := range s.flags {
+		flags = append(flags, f)
+	}
+	sort.Slice(flags, func(i, j int) bool { return flags[i].Name < flags[j].Name })
+	return flags
+}
+
+// Unknown returns the configured names that no flag was defined for.
+func (s *Set) Unknown() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var names []string
+	for name := range s.configured {
+		if _, ok := s.flags[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,82 @@
+// Command gateway serves the Order and Charge services as HTTP/JSON, as
+// declared by the google.api.http annotations in service.proto.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"grpc-test/logging"
+	"grpc-test/metrics"
+	pb "grpc-test/proto"
+	"grpc-test/server"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/revotech-group/go-lib/grpc/interceptors"
+	"google.golang.org/grpc"
+)
+
+func main() {
+	cfg, err := server.Load("gateway", os.Args[1:], server.Config{
+		Addr:    ":8080",
+		Metrics: metrics.Config{Addr: ":9090"},
+		Dependencies: map[string]string{
+			"order":  "localhost:50051",
+			"charge": "localhost:50052",
+		},
+	})
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	srv, err := server.New(cfg)
+	if err != nil {
+		log.Fatalf("Failed to set up server: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Decode errors into AppErrors so that their name, code and details end
+	// up in the problem details
+	dialOpts := append([]grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(interceptors.UnaryClientErrorInterceptor()),
+	}, srv.DialOptions()...)
+	orderConn, err := grpc.Dial(cfg.Dependency("order"), dialOpts...)
+	if err != nil {
+		log.Fatalf("Failed to connect to Order Server: %v", err)
+	}
+	defer orderConn.Close()
+	chargeConn, err := grpc.Dial(cfg.Dependency("charge"), dialOpts...)
+	if err != nil {
+		log.Fatalf("Failed to connect to Charge Server: %v", err)
+	}
+	defer chargeConn.Close()
+
+	gw := runtime.NewServeMux(runtime.WithErrorHandler(writeProblem))
+	if err := pb.RegisterOrderHandlerClient(ctx, gw, pb.NewOrderClient(orderConn)); err != nil {
+		log.Fatalf("Failed to register Order handler: %v", err)
+	}
+	if err := pb.RegisterChargeHandlerClient(ctx, gw, pb.NewChargeClient(chargeConn)); err != nil {
+		log.Fatalf("Failed to register Charge handler: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/v1/", gw)
+	mux.HandleFunc("GET /openapi.json", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(pb.OpenAPI)
+	})
+
+	if err := srv.RunHTTP(ctx, logging.HTTPHandler(mux, srv.Rand("http_request_ids"))); err != nil {
+		log.Fatalf("Failed to serve: %v", err)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"grpc-test/lib"
+	"grpc-test/logging"
+	pb "grpc-test/proto"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/revotech-group/go-lib/errors"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// problem converts an error returned by a service into RFC 7807 problem
+// details. Errors are AppErrors decoded by go-lib's client interceptor, or
+// plain statuses for transport failures.
+func problem(ctx context.Context, r *http.Request, err error) *pb.Problem {
+	p := &pb.Problem{
+		Type:      "about:blank",
+		Title:     lib.NameOf(err),
+		Status:    int32(lib.HTTPStatus(err)),
+		Detail:    status.Convert(err).Message(),
+		Instance:  r.URL.Path,
+		Code:      int32(lib.CodeOf(err)),
+		RequestId: logging.RequestID(ctx),
+	}
+	if m, ok := err.(interface{ GetMessage() string }); ok {
+		p.Detail = m.GetMessage()
+	}
+	if p.Title != "" {
+		p.Type = "urn:grpc-test:error:" + p.Title
+	} else {
+		p.Title = http.StatusText(int(p.Status))
+	}
+
+	var details []proto.Message
+	if appErr, ok := err.(errors.AppError); ok && appErr.GetProtobufError() != nil {
+		details = append(details, appErr.GetProtobufError())
+	} else if s, ok := status.FromError(err); ok {
+		for _, d := range s.Proto().GetDetails() {
+			details = append(details, d)
+		}
+	}
+	for _, d := range details {
+		if a, ok := d.(*anypb.Any); ok {
+			p.Details = append(p.Details, a)
+			continue
+		}
+		a, err := anypb.New(d)
+		if err != nil {
+			continue
+		}
+		p.Details = append(p.Details, a)
+	}
+	return p
+}
+
+// writeProblem is the gateway's error handler.
+func writeProblem(ctx context.Context, _ *runtime.ServeMux, _ runtime.Marshaler, w http.ResponseWriter, r *http.Request, err error) {
+	p := problem(ctx, r, err)
+	if p.Status >= http.StatusInternalServerError {
+		logging.FromContext(ctx).Error("Request failed", slog.Int("status", int(p.Status)), slog.Any("error", err))
+	}
+
+	// Any details are printed with their fields, e.g. the retry delay of
+	// ErrTooManyRequests
+	body, merr := protojson.Marshal(p)
+	if merr != nil {
+		body = []byte(`{"title":"Internal Server Error","status":500}`)
+		p.Status = http.StatusInternalServerError
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	if p.Status == http.StatusTooManyRequests || p.Status == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", "1")
+	}
+	w.WriteHeader(int(p.Status))
+	w.Write(body)
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	pb "grpc-test/proto"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func adminLevels(fs *flag.FlagSet) func(context.Context, *options, []string) error {
+	return func(ctx context.Context, o *options, args []string) error {
+		if err := exactArgs(args, 0, "no arguments"); err != nil {
+			return err
+		}
+		return withAdminClient(ctx, o, func(ctx context.Context, c pb.AdminClient) error {
+			resp, err := c.GetLogLevels(ctx, &pb.Empty{})
+			if err != nil {
+				return err
+			}
+			printLogLevels(o, resp)
+			return nil
+		})
+	}
+}
+
+// adminLevel sets the default level with one argument and the level of a
+// package with two. The level "reset" makes a package use the default again.
+func adminLevel(fs *flag.FlagSet) func(context.Context, *options, []string) error {
+	return func(ctx context.Context, o *options, args []string) error {
+		if len(args) != 1 && len(args) != 2 {
+			return usageError("expected [package] <level|reset>")
+		}
+		req := &pb.SetLogLevelRequest{Level: args[len(args)-1]}
+		if len(args) == 2 {
+			req.Package = args[0]
+		}
+		if req.Level == "reset" {
+			req.Level = ""
+		}
+		return withAdminClient(ctx, o, func(ctx context.Context, c pb.AdminClient) error {
+			resp, err := c.SetLogLevel(ctx, req)
+			if err != nil {
+				return err
+			}
+			printLogLevels(o, resp)
+			return nil
+		})
+	}
+}
+
+func adminDebug(fs *flag.FlagSet) func(context.Context, *options, []string) error {
+	return func(ctx context.Context, o *options, args []string) error {
+		if err := exactArgs(args, 1, "on or off"); err != nil {
+			return err
+		}
+		on, err := parseOnOff(args[0])
+		if err != nil {
+			return err
+		}
+		return withAdminClient(ctx, o, func(ctx context.Context, c pb.AdminClient) error {
+			resp, err := c.SetDebug(ctx, &pb.SetDebugRequest{Debug: on})
+			if err != nil {
+				return err
+			}
+			printLogLevels(o, resp)
+			return nil
+		})
+	}
+}
+
+func adminFlags(fs *flag.FlagSet) func(context.Context, *options, []string) error {
+	return func(ctx context.Context, o *options, args []string) error {
+		if err := exactArgs(args, 0, "no arguments"); err != nil {
+			return err
+		}
+		return withAdminClient(ctx, o, func(ctx context.Context, c pb.AdminClient) error {
+			resp, err := c.ListFlags(ctx, &pb.Empty{})
+			if err != nil {
+				return err
+			}
+			printFeatureFlags(o, resp)
+			return nil
+		})
+	}
+}
+
+func adminFlag(fs *flag.FlagSet) func(context.Context, *options, []string) error {
+	return func(ctx context.Context, o *options, args []string) error {
+		if err := exactArgs(args, 2, "a flag name and on or off"); err != nil {
+			return err
+		}
+		on, err := parseOnOff(args[1])
+		if err != nil {
+			return err
+		}
+		return withAdminClient(ctx, o, func(ctx context.Context, c pb.AdminClient) error {
+			resp, err := c.SetFlag(ctx, &pb.SetFlagRequest{Name: args[0], Enabled: on})
+			if err != nil {
+				return err
+			}
+			printFeatureFlags(o, resp)
+			return nil
+		})
+	}
+}
+
+// adminConfig prints the configuration as JSON in either output format.
+func adminConfig(fs *flag.FlagSet) func(context.Context, *options, []string) error {
+	return func(ctx context.Context, o *options, args []string) error {
+		if err := exactArgs(args, 0, "no arguments"); err != nil {
+			return err
+		}
+		return withAdminClient(ctx, o, func(ctx context.Context, c pb.AdminClient) error {
+			resp, err := c.GetConfig(ctx, &pb.Empty{})
+			if err != nil {
+				return err
+			}
+			printMessage(o, resp, func(w *tabwriter.Writer) { fmt.Fprintln(w, resp.Json) })
+			return nil
+		})
+	}
+}
+
+func adminAudit(fs *flag.FlagSet) func(context.Context, *options, []string) error {
+	req := &pb.QueryAuditRequest{}
+	fs.StringVar(&req.Kind, "kind", "", "only records of this kind: charge or authz")
+	fs.StringVar(&req.Actor, "actor", "", "only records of this actor")
+	fs.StringVar(&req.CustomerId, "customer", "", "only records of this customer")
+	fs.StringVar(&req.OrderId, "order", "", "only records of this order")
+	fs.StringVar(&req.Outcome, "outcome", "", "only records with this outcome, e.g. ok, denied or BadRequestError")
+	since := fs.Duration("since", 0, "only records of the last duration, e.g. 1h")
+	limit := fs.Int("n", 0, "number of newest records, 100 if 0")
+	return func(ctx context.Context, o *options, args []string) error {
+		if err := exactArgs(args, 0, "no arguments"); err != nil {
+			return err
+		}
+		if *since > 0 {
+			req.Since = timestamppb.New(time.Now().Add(-*since))
+		}
+		req.Limit = int32(*limit)
+		return withAdminClient(ctx, o, func(ctx context.Context, c pb.AdminClient) error {
+			resp, err := c.QueryAudit(ctx, req)
+			if err != nil {
+				return err
+			}
+			printMessage(o, resp, func(w *tabwriter.Writer) {
+				fmt.Fprintln(w, "SEQ\tTIME\tKIND\tMETHOD\tACTOR\tCUSTOMER\tORDER\tAMOUNT\tOUTCOME\tREASON")
+				for _, r := range resp.Records {
+					amount := "-"
+					if r.Amount != 0 {
+						amount = fmt.Sprintf("%.2f", r.Amount)
+					}
+					fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", r.Seq, r.Time.AsTime().Format(time.RFC3339), r.Kind, r.Method, r.Actor,
+						dash(r.CustomerId), dash(r.OrderId), amount, r.Outcome, dash(r.Reason))
+				}
+			})
+			return nil
+		})
+	}
+}
+
+// withAdminClient connects to the Admin service for one call.
+func withAdminClient(ctx context.Context, o *options, call func(context.Context, pb.AdminClient) error) error {
+	conn, err := o.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(o.context(ctx), o.timeout)
+	defer cancel()
+	return call(ctx, pb.NewAdminClient(conn))
+}
+
+func printLogLevels(o *options, resp *pb.LogLevels) {
+	printMessage(o, resp, func(w *tabwriter.Writer) {
+		fmt.Fprintln(w, "PACKAGE\tLEVEL")
+		fmt.Fprintf(w, "(default)\t%s\n", resp.Level)
+		packages := make([]string, 0, len(resp.Packages))
+		for pkg := range resp.Packages {
+			packages = append(packages, pkg)
+		}
+		sort.Strings(packages)
+		for _, pkg := range packages {
+			fmt.Fprintf(w, "%s\t%s\n", pkg, resp.Packages[pkg])
+		}
+		fmt.Fprintf(w, "\nDebug:\t%s\n", onOff(resp.Debug))
+	})
+}
+
+func printFeatureFlags(o *options, resp *pb.FeatureFlags) {
+	printMessage(o, resp, func(w *tabwriter.Writer) {
+		fmt.Fprintln(w, "FLAG\tENABLED\tDESCRIPTION")
+		for _, f := range resp.Flags {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", f.Name, onOff(f.Enabled), f.Description)
+		}
+	})
+}
+
+func parseOnOff(s string) (bool, error) {
+	switch s {
+	case "on":
+		return true, nil
+	case "off":
+		return false, nil
+	}
+	on, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, usageError(fmt.Sprintf("expected on or off, got %q", s))
+	}
+	return on, nil
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"text/tabwriter"
+
+	pb "grpc-test/proto"
+)
+
+func chargeCreate(fs *flag.FlagSet) func(context.Context, *options, []string) error {
+	customer := fs.String("customer", "12345", "customer to charge")
+	amount := fs.Float64("amount", 100, "amount to charge")
+	order := fs.String("order", "", "order being paid, for correlation")
+	return func(ctx context.Context, o *options, args []string) error {
+		if err := exactArgs(args, 0, "no arguments"); err != nil {
+			return err
+		}
+		conn, err := o.dial()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		ctx, cancel := context.WithTimeout(o.context(ctx), o.timeout)
+		defer cancel()
+		resp, err := pb.NewChargeClient(conn).ChargeCustomer(ctx, &pb.ChargeRequest{
+			CustomerId: *customer,
+			Amount:     float32(*amount),
+			OrderId:    *order,
+		})
+		if err != nil {
+			return err
+		}
+		printMessage(o, resp, func(w *tabwriter.Writer) {
+			fmt.Fprintln(w, "MESSAGE")
+			fmt.Fprintln(w, resp.Message)
+		})
+		return nil
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"grpc-test/faults"
+	pb "grpc-test/proto"
+)
+
+func faultsGet(fs *flag.FlagSet) func(context.Context, *options, []string) error {
+	return func(ctx context.Context, o *options, args []string) error {
+		if err := exactArgs(args, 0, "no arguments"); err != nil {
+			return err
+		}
+		return withFaultsClient(ctx, o, func(ctx context.Context, c pb.FaultInjectionClient) (*pb.FaultRules, error) {
+			return c.GetFaults(ctx, &pb.Empty{})
+		})
+	}
+}
+
+func faultsSet(fs *flag.FlagSet) func(context.Context, *options, []string) error {
+	return func(ctx context.Context, o *options, args []string) error {
+		if err := exactArgs(args, 1, "a faults file"); err != nil {
+			return err
+		}
+		rules, err := faults.LoadRules(args[0])
+		if err != nil {
+			return err
+		}
+		return withFaultsClient(ctx, o, func(ctx context.Context, c pb.FaultInjectionClient) (*pb.FaultRules, error) {
+			return c.SetFaults(ctx, faults.ToProto(rules))
+		})
+	}
+}
+
+func faultsClear(fs *flag.FlagSet) func(context.Context, *options, []string) error {
+	return func(ctx context.Context, o *options, args []string) error {
+		if err := exactArgs(args, 0, "no arguments"); err != nil {
+			return err
+		}
+		return withFaultsClient(ctx, o, func(ctx context.Context, c pb.FaultInjectionClient) (*pb.FaultRules, error) {
+			return c.SetFaults(ctx, &pb.FaultRules{})
+		})
+	}
+}
+
+// withFaultsClient makes one call to the FaultInjection service and prints
+// the rules in effect.
+func withFaultsClient(ctx context.Context, o *options, call func(context.Context, pb.FaultInjectionClient) (*pb.FaultRules, error)) error {
+	conn, err := o.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(o.context(ctx), o.timeout)
+	defer cancel()
+	resp, err := call(ctx, pb.NewFaultInjectionClient(conn))
+	if err != nil {
+		return err
+	}
+	printMessage(o, resp, func(w *tabwriter.Writer) {
+		fmt.Fprintln(w, "METHODS\tSIDE\tPROBABILITY\tLATENCY\tFAULT")
+		for _, r := range faults.FromProto(resp) {
+			side := r.Side
+			if side == "" {
+				side = "both"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%g\t%s\t%s\n", strings.Join(r.Methods, ","), side, r.Probability, time.Duration(r.Latency), describeFault(r))
+		}
+	})
+	return nil
+}
+
+func describeFault(r faults.Rule) string {
+	var fault string
+	switch {
+	case r.Panic:
+		fault = "panic"
+	case r.Error != "":
+		fault = r.Error
+	case r.Code != "":
+		fault = r.Code
+	}
+	if r.AbortAfter > 0 {
+		fault = strings.TrimSpace(fmt.Sprintf("abort after %d %s", r.AbortAfter, fault))
+	}
+	if fault == "" {
+		return "-"
+	}
+	return fault
+}
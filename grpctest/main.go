@@ -0,0 +1,232 @@
+// Command grpctest calls the services from the command line:
+//
+//	grpctest order place -product Laptop -quantity 2
+//	grpctest order get <id>
+//	grpctest order list [-customer 12345]
+//	grpctest order cancel <id> [-reason text]
+//	grpctest charge create -customer 12345 -amount 100 [-order <id>]
+//	grpctest rates watch [-n 10] [-broker nats://localhost:4222 [-group g]]
+//	grpctest faults get|set <file>|clear [-addr localhost:50062]
+//	grpctest admin levels|flags|config [-addr localhost:50062]
+//	grpctest admin level [package] <level|reset>
+//	grpctest admin debug on|off
+//	grpctest admin flag <name> on|off
+//	grpctest admin audit [-kind charge] [-customer 12345] [-since 1h]
+//	grpctest webhooks register <url> [-events order.*] [-secret s]
+//	grpctest webhooks list|delete <id>|redeliver <delivery id>
+//	grpctest webhooks deliveries [-webhook <id>] [-state dead] [-attempts]
+//	grpctest webhooks receive -secret s [-listen localhost:8090] [-fail 3]
+//
+// Every command accepts the connection and output flags, see -h.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"time"
+
+	"grpc-test/authz"
+	"grpc-test/logging"
+	"grpc-test/tlsconfig"
+	"grpc-test/tracing"
+
+	"github.com/revotech-group/go-lib/grpc/interceptors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// options are the flags shared by every command.
+type options struct {
+	addr    string
+	tls     tlsconfig.Config
+	token   string
+	timeout time.Duration
+	output  string
+	trace   tracing.Config
+
+	// requestID and status are those of the last unary call, as returned
+	// before go-lib turns the status into an AppError.
+	requestID string
+	status    *status.Status
+}
+
+func (o *options) register(fs *flag.FlagSet, addr string) {
+	fs.StringVar(&o.addr, "addr", addr, "address of the service")
+	fs.StringVar(&o.tls.CAFile, "tls-ca", "", "PEM CA bundle to verify the service, enables TLS")
+	fs.StringVar(&o.tls.CertFile, "tls-cert", "", "PEM client certificate for mTLS")
+	fs.StringVar(&o.tls.KeyFile, "tls-key", "", "PEM client private key for mTLS")
+	fs.StringVar(&o.token, "token", os.Getenv("GRPCTEST_TOKEN"), "bearer token, defaults to $GRPCTEST_TOKEN")
+	fs.DurationVar(&o.timeout, "timeout", 5*time.Second, "deadline of unary calls")
+	fs.StringVar(&o.output, "o", "table", "output format: table or json")
+	fs.BoolVar(&o.trace.Stdout, "trace-stdout", false, "print the client spans to stdout")
+	fs.StringVar(&o.trace.File, "trace-file", "", "append the client spans as OTLP/JSON to this file")
+}
+
+// dial connects to the service. go-lib's interceptors turn errors into
+// AppErrors so that their name, code and details can be printed.
+func (o *options) dial() (*grpc.ClientConn, error) {
+	creds, err := tlsconfig.ClientCredentials(o.tls)
+	if err != nil {
+		return nil, err
+	}
+	return grpc.Dial(o.addr,
+		grpc.WithTransportCredentials(creds),
+		tracing.DialOption(),
+		grpc.WithChainUnaryInterceptor(interceptors.UnaryClientErrorInterceptor(), o.recordRequestID),
+		grpc.WithStreamInterceptor(interceptors.ClientStreamErrorInterceptor),
+	)
+}
+
+func (o *options) recordRequestID(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	var header metadata.MD
+	err := invoker(ctx, method, req, reply, cc, append(opts, grpc.Header(&header))...)
+	if id := header.Get(logging.RequestIDHeader); len(id) > 0 {
+		o.requestID = id[0]
+	}
+	o.status = status.Convert(err)
+	return err
+}
+
+// context returns the context of a call, carrying the token.
+func (o *options) context(ctx context.Context) context.Context {
+	if o.token != "" {
+		ctx = authz.WithToken(ctx, o.token)
+	}
+	return ctx
+}
+
+// command is a subcommand such as "order place".
+type command struct {
+	addr  string
+	args  string
+	help  string
+	flags func(fs *flag.FlagSet) func(ctx context.Context, o *options, args []string) error
+}
+
+var commands = map[string]command{
+	"order place":         {addr: "localhost:50051", help: "place an order", flags: orderPlace},
+	"order get":           {addr: "localhost:50051", args: "<id>", help: "show an order", flags: orderGet},
+	"order list":          {addr: "localhost:50051", help: "list orders", flags: orderList},
+	"order cancel":        {addr: "localhost:50051", args: "<id>", help: "cancel an order", flags: orderCancel},
+	"charge create":       {addr: "localhost:50052", help: "charge a customer", flags: chargeCreate},
+	"rates watch":         {addr: "localhost:50053", help: "stream exchange rates until interrupted", flags: ratesWatch},
+	"faults get":          {addr: "localhost:50062", help: "show the fault rules of a service", flags: faultsGet},
+	"faults set":          {addr: "localhost:50062", args: "<file>", help: "replace the fault rules of a service", flags: faultsSet},
+	"faults clear":        {addr: "localhost:50062", help: "remove all fault rules of a service", flags: faultsClear},
+	"admin levels":        {addr: "localhost:50062", help: "show the log levels of a service", flags: adminLevels},
+	"admin level":         {addr: "localhost:50062", args: "[package] <level|reset>", help: "set the log level of a service or one of its packages", flags: adminLevel},
+	"admin debug":         {addr: "localhost:50062", args: "<on|off>", help: "turn stack traces in logs on or off", flags: adminDebug},
+	"admin flags":         {addr: "localhost:50062", help: "list the feature flags of a service", flags: adminFlags},
+	"admin flag":          {addr: "localhost:50062", args: "<name> <on|off>", help: "turn a feature flag on or off", flags: adminFlag},
+	"admin config":        {addr: "localhost:50062", help: "show the effective configuration of a service", flags: adminConfig},
+	"admin audit":         {addr: "localhost:50062", help: "query the audit log of a service", flags: adminAudit},
+	"webhooks register":   {addr: "localhost:50051", args: "<url>", help: "register an endpoint to push events to", flags: webhooksRegister},
+	"webhooks list":       {addr: "localhost:50051", help: "list the registered webhooks", flags: webhooksList},
+	"webhooks delete":     {addr: "localhost:50051", args: "<id>", help: "delete a webhook", flags: webhooksDelete},
+	"webhooks deliveries": {addr: "localhost:50051", help: "list webhook deliveries and their attempts", flags: webhooksDeliveries},
+	"webhooks redeliver":  {addr: "localhost:50051", args: "<id>", help: "queue a dead letter again", flags: webhooksRedeliver},
+	"webhooks receive":    {help: "receive, verify and print webhook deliveries until interrupted", flags: webhooksReceive},
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	if len(args) < 2 {
+		usage()
+		return 2
+	}
+	name := args[0] + " " + args[1]
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		usage()
+		return 2
+	}
+
+	fs := flag.NewFlagSet("grpctest "+name, flag.ContinueOnError)
+	var o options
+	o.register(fs, cmd.addr)
+	runCmd := cmd.flags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: grpctest %s %s [flags]\n\n%s.\n\n", name, cmd.args, cmd.help)
+		fs.PrintDefaults()
+	}
+	positional, err := parse(fs, args[2:])
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if o.output != "table" && o.output != "json" {
+		fmt.Fprintf(os.Stderr, "unknown output format %q\n", o.output)
+		return 2
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), "grpctest", o.trace)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to set up tracing: %v\n", err)
+		return 1
+	}
+	defer shutdownTracing(context.Background())
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := runCmd(ctx, &o, positional); err != nil {
+		printError(&o, err)
+		return 1
+	}
+	return 0
+}
+
+// parse parses flags, allowing them before and after positional arguments.
+func parse(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString("Usage: grpctest <command> [flags]\n\nCommands:\n")
+	for _, name := range names {
+		fmt.Fprintf(&b, "  %-28s %s\n", strings.TrimSpace(name+" "+commands[name].args), commands[name].help)
+	}
+	b.WriteString("\nRun grpctest <command> -h for the flags of a command.\n")
+	fmt.Fprint(os.Stderr, b.String())
+}
+
+// exactArgs checks the number of positional arguments.
+func exactArgs(args []string, n int, names string) error {
+	if len(args) != n {
+		return usageError(fmt.Sprintf("expected %s", names))
+	}
+	return nil
+}
+
+type usageError string
+
+func (e usageError) Error() string { return string(e) }
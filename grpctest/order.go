@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	pb "grpc-test/proto"
+)
+
+func orderPlace(fs *flag.FlagSet) func(context.Context, *options, []string) error {
+	product := fs.String("product", "Laptop", "product to order")
+	quantity := fs.Int("quantity", 1, "number of items")
+	return func(ctx context.Context, o *options, args []string) error {
+		if err := exactArgs(args, 0, "no arguments"); err != nil {
+			return err
+		}
+		return withOrderClient(ctx, o, func(ctx context.Context, c pb.OrderClient) (any, error) {
+			return c.PlaceOrder(ctx, &pb.OrderRequest{Product: *product, Quantity: int32(*quantity)})
+		})
+	}
+}
+
+func orderGet(fs *flag.FlagSet) func(context.Context, *options, []string) error {
+	return func(ctx context.Context, o *options, args []string) error {
+		if err := exactArgs(args, 1, "an order ID"); err != nil {
+			return err
+		}
+		return withOrderClient(ctx, o, func(ctx context.Context, c pb.OrderClient) (any, error) {
+			return c.GetOrder(ctx, &pb.GetOrderRequest{Id: args[0]})
+		})
+	}
+}
+
+func orderList(fs *flag.FlagSet) func(context.Context, *options, []string) error {
+	customer := fs.String("customer", "", "only list the orders of this customer")
+	return func(ctx context.Context, o *options, args []string) error {
+		if err := exactArgs(args, 0, "no arguments"); err != nil {
+			return err
+		}
+		return withOrderClient(ctx, o, func(ctx context.Context, c pb.OrderClient) (any, error) {
+			return c.ListOrders(ctx, &pb.ListOrdersRequest{CustomerId: *customer})
+		})
+	}
+}
+
+func orderCancel(fs *flag.FlagSet) func(context.Context, *options, []string) error {
+	reason := fs.String("reason", "", "reason recorded with the cancellation")
+	return func(ctx context.Context, o *options, args []string) error {
+		if err := exactArgs(args, 1, "an order ID"); err != nil {
+			return err
+		}
+		return withOrderClient(ctx, o, func(ctx context.Context, c pb.OrderClient) (any, error) {
+			return c.CancelOrder(ctx, &pb.CancelOrderRequest{Id: args[0], Reason: *reason})
+		})
+	}
+}
+
+// withOrderClient makes one call to the Order service and prints its result.
+func withOrderClient(ctx context.Context, o *options, call func(context.Context, pb.OrderClient) (any, error)) error {
+	conn, err := o.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(o.context(ctx), o.timeout)
+	defer cancel()
+	resp, err := call(ctx, pb.NewOrderClient(conn))
+	if err != nil {
+		return err
+	}
+
+	switch r := resp.(type) {
+	case *pb.OrderResponse:
+		printMessage(o, r, func(w *tabwriter.Writer) { printOrders(w, r) })
+	case *pb.ListOrdersResponse:
+		printMessage(o, r, func(w *tabwriter.Writer) { printOrders(w, r.Orders...) })
+	}
+	return nil
+}
+
+func printOrders(w *tabwriter.Writer, orders ...*pb.OrderResponse) {
+	fmt.Fprintln(w, "ID\tPRODUCT\tQUANTITY\tSTATUS\tCUSTOMER\tMESSAGE")
+	for _, order := range orders {
+		status := strings.TrimPrefix(order.Status.String(), "ORDER_STATUS_")
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\n", order.OrderId, order.Product, order.Quantity, status, order.CustomerId, order.Message)
+	}
+}
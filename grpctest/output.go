@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"grpc-test/lib"
+
+	"github.com/revotech-group/go-lib/errors"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// printMessage prints a response as JSON or, in table format, with table.
+func printMessage(o *options, m proto.Message, table func(w *tabwriter.Writer)) {
+	if o.output == "json" {
+		out, _ := protojson.MarshalOptions{Multiline: true, UseProtoNames: true}.Marshal(m)
+		fmt.Println(string(out))
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	table(w)
+	w.Flush()
+}
+
+func printJSONLine(m proto.Message) {
+	out, _ := protojson.MarshalOptions{UseProtoNames: true}.Marshal(m)
+	fmt.Println(string(out))
+}
+
+// callError is the printed form of a failed call.
+type callError struct {
+	Name       string          `json:"name,omitempty"`
+	Code       int             `json:"code,omitempty"`
+	GRPCCode   string          `json:"grpc_code"`
+	Message    string          `json:"message"`
+	DetailType string          `json:"detail_type,omitempty"`
+	Detail     json.RawMessage `json:"detail,omitempty"`
+	Request    string          `json:"request_id,omitempty"`
+}
+
+// printError prints err to stderr with its AppError name, code, message and
+// decoded protobuf detail.
+func printError(o *options, err error) {
+	if _, ok := err.(usageError); ok {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+
+	e := callError{
+		Name:     lib.NameOf(err),
+		Code:     lib.CodeOf(err),
+		GRPCCode: status.Code(err).String(),
+		Message:  status.Convert(err).Message(),
+		Request:  o.requestID,
+	}
+	if o.status != nil {
+		e.GRPCCode = o.status.Code().String()
+	}
+	if m, ok := err.(interface{ GetMessage() string }); ok {
+		e.Message = m.GetMessage()
+	}
+	var detail proto.Message
+	if appErr, ok := err.(errors.AppError); ok {
+		detail = appErr.GetProtobufError()
+	}
+	if detail != nil {
+		e.DetailType = string(detail.ProtoReflect().Descriptor().FullName())
+		e.Detail, _ = protojson.MarshalOptions{UseProtoNames: true}.Marshal(detail)
+	}
+
+	if o.output == "json" {
+		out, _ := json.MarshalIndent(map[string]callError{"error": e}, "", "  ")
+		fmt.Fprintln(os.Stderr, string(out))
+		return
+	}
+	w := tabwriter.NewWriter(os.Stderr, 0, 4, 2, ' ', 0)
+	if e.Name != "" {
+		fmt.Fprintf(w, "Error:\t%s (%d)\n", e.Name, e.Code)
+	} else {
+		fmt.Fprintf(w, "Error:\t%s\n", e.GRPCCode)
+	}
+	fmt.Fprintf(w, "Message:\t%s\n", e.Message)
+	if detail != nil {
+		fmt.Fprintf(w, "Detail:\t%s %s\n", e.DetailType, e.Detail)
+	}
+	if e.Request != "" {
+		fmt.Fprintf(w, "Request ID:\t%s\n", e.Request)
+	}
+	w.Flush()
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"grpc-test/broker"
+	"grpc-test/clock"
+	pb "grpc-test/proto"
+	"grpc-test/rates"
+)
+
+func ratesWatch(fs *flag.FlagSet) func(context.Context, *options, []string) error {
+	n := fs.Int("n", 0, "stop after this many rates, 0 to watch until interrupted")
+	brokerURL := fs.String("broker", "", "consume the rates published on this broker, e.g. nats://localhost:4222, instead of streaming them")
+	group := fs.String("group", "", "consumer group on the broker, with -broker")
+	return func(ctx context.Context, o *options, args []string) error {
+		if err := exactArgs(args, 0, "no arguments"); err != nil {
+			return err
+		}
+		var recv func() (*pb.ExchangeRate, error)
+		if *brokerURL != "" {
+			b, err := broker.Open(*brokerURL, "grpctest", clock.Real)
+			if err != nil {
+				return err
+			}
+			defer b.Close()
+			// Starts with the last rate of every pair
+			if err := rates.Retain(b); err != nil {
+				return err
+			}
+			messages := make(chan broker.Message)
+			sub, err := b.Subscribe(rates.Subjects, *group, func(m broker.Message) {
+				select {
+				case messages <- m:
+				case <-ctx.Done():
+				}
+			})
+			if err != nil {
+				return err
+			}
+			defer sub.Unsubscribe()
+			recv = func() (*pb.ExchangeRate, error) {
+				select {
+				case m := <-messages:
+					return rates.Decode(m)
+				case <-ctx.Done():
+					return nil, io.EOF
+				}
+			}
+		} else {
+			conn, err := o.dial()
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+
+			// The stream has no deadline, -timeout only applies to unary calls
+			stream, err := pb.NewCurrencyClient(conn).SendExchangeRates(o.context(ctx), &pb.Empty{})
+			if err != nil {
+				return err
+			}
+			recv = stream.Recv
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		if o.output == "table" {
+			fmt.Fprintln(w, "FROM\tTO\tRATE\tTIMESTAMP")
+		}
+		for i := 0; *n == 0 || i < *n; i++ {
+			rate, err := recv()
+			if errors.Is(err, io.EOF) || ctx.Err() != nil {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if o.output == "json" {
+				printJSONLine(rate)
+				continue
+			}
+			fmt.Fprintf(w, "%s\t%s\t%.4f\t%s\n", rate.CurrencyFrom, rate.CurrencyTo, rate.Rate, rate.Timestamp)
+			w.Flush()
+		}
+		return nil
+	}
+}
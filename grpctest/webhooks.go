@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	pb "grpc-test/proto"
+	"grpc-test/webhook"
+)
+
+func webhooksRegister(fs *flag.FlagSet) func(context.Context, *options, []string) error {
+	req := &pb.RegisterWebhookRequest{}
+	eventList := fs.String("events", "", "comma-separated event types or patterns such as order.*, every event if empty")
+	fs.StringVar(&req.Secret, "secret", "", "signing secret, generated if empty")
+	return func(ctx context.Context, o *options, args []string) error {
+		if err := exactArgs(args, 1, "the URL of the endpoint"); err != nil {
+			return err
+		}
+		req.Url = args[0]
+		if *eventList != "" {
+			req.Events = strings.Split(*eventList, ",")
+		}
+		return withWebhooksClient(ctx, o, func(ctx context.Context, c pb.WebhooksClient) error {
+			resp, err := c.RegisterWebhook(ctx, req)
+			if err != nil {
+				return err
+			}
+			printMessage(o, resp, func(w *tabwriter.Writer) {
+				fmt.Fprintf(w, "ID:\t%s\nURL:\t%s\nEvents:\t%s\nSecret:\t%s\n", resp.Id, resp.Url, events(resp.Events), resp.Secret)
+			})
+			return nil
+		})
+	}
+}
+
+func webhooksList(fs *flag.FlagSet) func(context.Context, *options, []string) error {
+	return func(ctx context.Context, o *options, args []string) error {
+		if err := exactArgs(args, 0, "no arguments"); err != nil {
+			return err
+		}
+		return withWebhooksClient(ctx, o, func(ctx context.Context, c pb.WebhooksClient) error {
+			resp, err := c.ListWebhooks(ctx, &pb.Empty{})
+			if err != nil {
+				return err
+			}
+			printMessage(o, resp, func(w *tabwriter.Writer) {
+				fmt.Fprintln(w, "ID\tURL\tEVENTS\tCUSTOMER\tOWNER\tCREATED")
+				for _, h := range resp.Webhooks {
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", h.Id, h.Url, events(h.Events), dash(h.CustomerId), h.Owner, h.Created.AsTime().Format(time.RFC3339))
+				}
+			})
+			return nil
+		})
+	}
+}
+
+func webhooksDelete(fs *flag.FlagSet) func(context.Context, *options, []string) error {
+	return func(ctx context.Context, o *options, args []string) error {
+		if err := exactArgs(args, 1, "a webhook ID"); err != nil {
+			return err
+		}
+		return withWebhooksClient(ctx, o, func(ctx context.Context, c pb.WebhooksClient) error {
+			resp, err := c.DeleteWebhook(ctx, &pb.DeleteWebhookRequest{Id: args[0]})
+			if err != nil {
+				return err
+			}
+			printMessage(o, resp, func(w *tabwriter.Writer) { fmt.Fprintf(w, "Deleted webhook %s\n", args[0]) })
+			return nil
+		})
+	}
+}
+
+func webhooksDeliveries(fs *flag.FlagSet) func(context.Context, *options, []string) error {
+	req := &pb.ListDeliveriesRequest{}
+	fs.StringVar(&req.WebhookId, "webhook", "", "only the deliveries of this webhook")
+	fs.StringVar(&req.State, "state", "", "only deliveries in this state: pending, delivered or dead")
+	limit := fs.Int("n", 0, "number of newest deliveries, 100 if 0")
+	attempts := fs.Bool("attempts", false, "list every attempt of the deliveries")
+	return func(ctx context.Context, o *options, args []string) error {
+		if err := exactArgs(args, 0, "no arguments"); err != nil {
+			return err
+		}
+		req.Limit = int32(*limit)
+		return withWebhooksClient(ctx, o, func(ctx context.Context, c pb.WebhooksClient) error {
+			resp, err := c.ListDeliveries(ctx, req)
+			if err != nil {
+				return err
+			}
+			printMessage(o, resp, func(w *tabwriter.Writer) {
+				fmt.Fprintln(w, "ID\tWEBHOOK\tEVENT\tSTATE\tATTEMPTS\tLAST STATUS\tLAST ERROR")
+				for _, d := range resp.Deliveries {
+					status, lastErr := "-", "-"
+					if n := len(d.Attempts); n > 0 {
+						last := d.Attempts[n-1]
+						if last.StatusCode != 0 {
+							status = fmt.Sprint(last.StatusCode)
+						}
+						lastErr = dash(last.Error)
+					}
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", d.Id, d.WebhookId, d.EventType, d.State, len(d.Attempts), status, lastErr)
+					if *attempts {
+						for i, a := range d.Attempts {
+							fmt.Fprintf(w, "  #%d\t%s\t%s\t%d\t\t\t%s\n", i+1, a.Time.AsTime().Format(time.RFC3339Nano), a.Duration.AsDuration(), a.StatusCode, dash(a.Error))
+						}
+					}
+				}
+			})
+			return nil
+		})
+	}
+}
+
+func webhooksRedeliver(fs *flag.FlagSet) func(context.Context, *options, []string) error {
+	return func(ctx context.Context, o *options, args []string) error {
+		if err := exactArgs(args, 1, "a delivery ID"); err != nil {
+			return err
+		}
+		return withWebhooksClient(ctx, o, func(ctx context.Context, c pb.WebhooksClient) error {
+			resp, err := c.Redeliver(ctx, &pb.RedeliverRequest{Id: args[0]})
+			if err != nil {
+				return err
+			}
+			printMessage(o, resp, func(w *tabwriter.Writer) {
+				fmt.Fprintf(w, "Delivery %s of %s is %s again\n", resp.Id, resp.EventType, resp.State)
+			})
+			return nil
+		})
+	}
+}
+
+// webhooksReceive runs a local endpoint that verifies and prints deliveries,
+// to try webhooks out. -fail makes it answer 500 to the first deliveries, to
+// see them retried and dead-lettered.
+func webhooksReceive(fs *flag.FlagSet) func(context.Context, *options, []string) error {
+	listen := fs.String("listen", "localhost:8090", "address to receive deliveries on")
+	secret := fs.String("secret", "", "signing secret of the webhook, as returned by webhooks register")
+	fail := fs.Int("fail", 0, "answer 500 to this many deliveries first")
+	return func(ctx context.Context, o *options, args []string) error {
+		if err := exactArgs(args, 0, "no arguments"); err != nil {
+			return err
+		}
+		if *secret == "" {
+			return usageError("-secret is required")
+		}
+		lis, err := net.Listen("tcp", *listen)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Receiving webhooks on http://%s/\n", lis.Addr())
+
+		var mu sync.Mutex
+		received := 0
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		if o.output == "table" {
+			fmt.Fprintln(w, "TIME\tEVENT\tID\tRESULT\tDATA")
+			w.Flush()
+		}
+		h := webhook.Receiver(*secret, webhook.DefaultTolerance, func(e webhook.Event) error {
+			mu.Lock()
+			defer mu.Unlock()
+			received++
+			var err error
+			if received <= *fail {
+				err = fmt.Errorf("failing delivery %d of %d", received, *fail)
+			}
+			if o.output == "json" {
+				out, _ := json.Marshal(e)
+				fmt.Println(string(out))
+				return err
+			}
+			result := "ok"
+			if err != nil {
+				result = "500"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", e.Time.Format(time.RFC3339), e.Type, e.ID, result, e.Data)
+			w.Flush()
+			return err
+		})
+
+		srv := &http.Server{Handler: h, ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			<-ctx.Done()
+			srv.Close()
+		}()
+		if err := srv.Serve(lis); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	}
+}
+
+// withWebhooksClient connects to the Webhooks service for one call.
+func withWebhooksClient(ctx context.Context, o *options, call func(context.Context, pb.WebhooksClient) error) error {
+	conn, err := o.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(o.context(ctx), o.timeout)
+	defer cancel()
+	return call(ctx, pb.NewWebhooksClient(conn))
+}
+
+func events(patterns []string) string {
+	if len(patterns) == 0 {
+		return "*"
+	}
+	return strings.Join(patterns, ",")
+}
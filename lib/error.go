@@ -1,11 +1,14 @@
 package lib
 
 import (
+	stderrors "errors"
+
 	"github.com/revotech-group/go-lib/errors"
 )
 
 const (
 	NameServiceUnavailable  = "ServiceUnavailable"
+	NameDeadlineExceeded    = "DeadlineExceededError"
 	NameTooManyRequests     = "TooManyRequestsError"
 	NameBadRequest          = "BadRequestError"
 	NameInternalServerError = "InternalServerError"
@@ -27,3 +30,41 @@ func ErrInternalServerError() errors.AppError {
 func ErrBadRequest() errors.AppError {
 	return errors.NewAppError(NameBadRequest, "Bad request, invalid or missing parameter", 400)
 }
+
+func ErrForbidden() errors.AppError {
+	return errors.NewAppError(NameForbidden, "Forbidden, not allowed to access this resource", 403)
+}
+
+func ErrUnauthorizedAccess() errors.AppError {
+	return errors.NewAppError(NameUnauthorizedAccess, "Unauthorized access, missing or invalid credentials", 401)
+}
+
+func ErrTooManyRequests() errors.AppError {
+	return errors.NewAppError(NameTooManyRequests, "Too many requests, retry later", 429)
+}
+
+func ErrServiceUnavailable() errors.AppError {
+	return errors.NewAppError(NameServiceUnavailable, "Service unavailable, retry later", 503)
+}
+
+func ErrDeadlineExceeded() errors.AppError {
+	return errors.NewAppError(NameDeadlineExceeded, "Deadline exceeded before the request could complete", 504)
+}
+
+// NameOf returns the AppError name carried by err, or "" if err is not an AppError.
+func NameOf(err error) string {
+	var named interface{ GetName() string }
+	if stderrors.As(err, &named) {
+		return named.GetName()
+	}
+	return ""
+}
+
+// CodeOf returns the AppError code carried by err, or 0 if err is not an AppError.
+func CodeOf(err error) int {
+	var coded interface{ GetCode() int }
+	if stderrors.As(err, &coded) {
+		return coded.GetCode()
+	}
+	return 0
+}
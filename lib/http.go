@@ -0,0 +1,54 @@
+package lib
+
+import (
+	"net/http"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// httpStatusByName maps the AppError names of this package to HTTP statuses.
+var httpStatusByName = map[string]int{
+	NameBadRequest:          http.StatusBadRequest,
+	NameUnauthorizedAccess:  http.StatusUnauthorized,
+	NameForbidden:           http.StatusForbidden,
+	NameNotFound:            http.StatusNotFound,
+	NameAlreadyExists:       http.StatusConflict,
+	NameTooManyRequests:     http.StatusTooManyRequests,
+	NameInternalServerError: http.StatusInternalServerError,
+	NameServiceUnavailable:  http.StatusServiceUnavailable,
+	NameDeadlineExceeded:    http.StatusGatewayTimeout,
+}
+
+var httpStatusByCode = map[codes.Code]int{
+	codes.OK:                 http.StatusOK,
+	codes.Canceled:           499,
+	codes.InvalidArgument:    http.StatusBadRequest,
+	codes.FailedPrecondition: http.StatusBadRequest,
+	codes.OutOfRange:         http.StatusBadRequest,
+	codes.Unauthenticated:    http.StatusUnauthorized,
+	codes.PermissionDenied:   http.StatusForbidden,
+	codes.NotFound:           http.StatusNotFound,
+	codes.AlreadyExists:      http.StatusConflict,
+	codes.Aborted:            http.StatusConflict,
+	codes.ResourceExhausted:  http.StatusTooManyRequests,
+	codes.Unimplemented:      http.StatusNotImplemented,
+	codes.Unavailable:        http.StatusServiceUnavailable,
+	codes.DeadlineExceeded:   http.StatusGatewayTimeout,
+}
+
+// HTTPStatus returns the HTTP status for err. AppError codes are HTTP
+// statuses already and are used as is, otherwise the status is derived from
+// the AppError name, then from the gRPC code.
+func HTTPStatus(err error) int {
+	if code := CodeOf(err); code >= 400 && code <= 599 {
+		return code
+	}
+	if s, ok := httpStatusByName[NameOf(err)]; ok {
+		return s
+	}
+	if s, ok := httpStatusByCode[status.Code(err)]; ok {
+		return s
+	}
+	return http.StatusInternalServerError
+}
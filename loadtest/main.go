@@ -0,0 +1,239 @@
+// Command loadtest drives Order.PlaceOrder at a fixed rate or concurrency,
+// optionally with open Currency.SendExchangeRates streams alongside, and
+// reports latency percentiles and errors by AppError name and gRPC code:
+//
+//	loadtest -rps 200 -duration 1m -report run.json
+//	loadtest -concurrency 32 -streams 50 -baseline run.json
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"grpc-test/authz"
+	pb "grpc-test/proto"
+	"grpc-test/tlsconfig"
+
+	"github.com/revotech-group/go-lib/grpc/interceptors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type config struct {
+	orderAddr   string
+	ratesAddr   string
+	tls         tlsconfig.Config
+	token       string
+	rps         float64
+	concurrency int
+	duration    time.Duration
+	warmup      time.Duration
+	timeout     time.Duration
+	mix         mix
+	streams     int
+	seed        int64
+	report      string
+	baseline    string
+}
+
+func main() {
+	cfg := config{mix: defaultMix()}
+	flag.StringVar(&cfg.orderAddr, "addr", "localhost:50051", "address of the Order service")
+	flag.StringVar(&cfg.ratesAddr, "rates-addr", "localhost:50053", "address of the Currency service, used with -streams")
+	flag.StringVar(&cfg.tls.CAFile, "tls-ca", "", "PEM CA bundle to verify the services, enables TLS")
+	flag.StringVar(&cfg.tls.CertFile, "tls-cert", "", "PEM client certificate for mTLS")
+	flag.StringVar(&cfg.tls.KeyFile, "tls-key", "", "PEM client private key for mTLS")
+	flag.StringVar(&cfg.token, "token", os.Getenv("GRPCTEST_TOKEN"), "bearer token, defaults to $GRPCTEST_TOKEN")
+	flag.Float64Var(&cfg.rps, "rps", 0, "orders per second, 0 to run -concurrency workers back to back")
+	flag.IntVar(&cfg.concurrency, "concurrency", 10, "workers, or the maximum calls in flight with -rps")
+	flag.DurationVar(&cfg.duration, "duration", 30*time.Second, "measured duration of the run")
+	flag.DurationVar(&cfg.warmup, "warmup", 0, "time to send load before measuring")
+	flag.DurationVar(&cfg.timeout, "timeout", 5*time.Second, "deadline of each PlaceOrder call")
+	flag.Var(&cfg.mix, "mix", "products to order as product:min-max:weight, comma separated")
+	flag.IntVar(&cfg.streams, "streams", 0, "exchange rate streams to keep open during the run")
+	flag.Int64Var(&cfg.seed, "seed", 1, "seed of the product mix")
+	flag.StringVar(&cfg.report, "report", "", "write the JSON report to this file")
+	flag.StringVar(&cfg.baseline, "baseline", "", "JSON report of an earlier run to compare against")
+	flag.Parse()
+
+	if cfg.concurrency < 1 {
+		log.Fatal("-concurrency must be at least 1")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	report, err := run(ctx, cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	report.print(os.Stdout)
+
+	if cfg.report != "" {
+		if err := report.write(cfg.report); err != nil {
+			log.Fatalf("Failed to write report: %v", err)
+		}
+	}
+	if cfg.baseline != "" {
+		baseline, err := readReport(cfg.baseline)
+		if err != nil {
+			log.Fatalf("Failed to read baseline: %v", err)
+		}
+		compare(os.Stdout, baseline, report)
+	}
+}
+
+func run(ctx context.Context, cfg config) (*Report, error) {
+	creds, err := tlsconfig.ClientCredentials(cfg.tls)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.token != "" {
+		ctx = authz.WithToken(ctx, cfg.token)
+	}
+
+	// go-lib turns the status into an AppError, recordCode keeps the gRPC
+	// code it arrived with.
+	orderConn, err := grpc.Dial(cfg.orderAddr,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithChainUnaryInterceptor(interceptors.UnaryClientErrorInterceptor(), recordCode),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer orderConn.Close()
+	orders := pb.NewOrderClient(orderConn)
+
+	var streams *streamStats
+	if cfg.streams > 0 {
+		ratesConn, err := grpc.Dial(cfg.ratesAddr, grpc.WithTransportCredentials(creds))
+		if err != nil {
+			return nil, err
+		}
+		defer ratesConn.Close()
+		streams = newStreamStats()
+		streamCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		openStreams(streamCtx, pb.NewCurrencyClient(ratesConn), cfg.streams, streams)
+	}
+
+	stats := newCallStats()
+	rng := rand.New(rand.NewSource(cfg.seed))
+	var rngMu sync.Mutex
+	placeOrder := func() {
+		rngMu.Lock()
+		req := cfg.mix.pick(rng)
+		rngMu.Unlock()
+
+		callCtx, cancel := context.WithTimeout(ctx, cfg.timeout)
+		defer cancel()
+		code := new(codes.Code)
+		start := time.Now()
+		_, err := orders.PlaceOrder(withCodeRecorder(callCtx, code), req)
+		if ctx.Err() != nil {
+			return // interrupted, not a failure of the service
+		}
+		stats.record(time.Since(start), err, *code)
+	}
+
+	if cfg.warmup > 0 {
+		fmt.Fprintf(os.Stderr, "Warming up for %s\n", cfg.warmup)
+		warmCtx, cancel := context.WithTimeout(ctx, cfg.warmup)
+		generate(warmCtx, cfg, placeOrder, new(atomic.Int64))
+		cancel()
+		stats.reset()
+		if streams != nil {
+			streams.reset()
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Running for %s\n", cfg.duration)
+	runCtx, cancel := context.WithTimeout(ctx, cfg.duration)
+	defer cancel()
+	started := time.Now()
+	var dropped atomic.Int64
+	generate(runCtx, cfg, placeOrder, &dropped)
+	elapsed := time.Since(started)
+
+	report := &Report{
+		StartedAt:   started.UTC(),
+		Duration:    elapsed.Seconds(),
+		Target:      cfg.orderAddr,
+		RPS:         cfg.rps,
+		Concurrency: cfg.concurrency,
+		Mix:         cfg.mix.String(),
+		Seed:        cfg.seed,
+		PlaceOrder:  stats.summary(elapsed, dropped.Load()),
+	}
+	if streams != nil {
+		report.RateStreams = streams.summary(cfg.streams)
+	}
+	return report, nil
+}
+
+// generate calls fn until ctx is done: at cfg.rps with at most
+// cfg.concurrency calls in flight, or back to back from cfg.concurrency
+// workers. Calls that would exceed the limit at a fixed rate are dropped.
+func generate(ctx context.Context, cfg config, fn func(), dropped *atomic.Int64) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	if cfg.rps <= 0 {
+		for range cfg.concurrency {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for ctx.Err() == nil {
+					fn()
+				}
+			}()
+		}
+		return
+	}
+
+	inFlight := make(chan struct{}, cfg.concurrency)
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / cfg.rps))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		select {
+		case inFlight <- struct{}{}:
+		default:
+			dropped.Add(1)
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-inFlight }()
+			fn()
+		}()
+	}
+}
+
+type codeKey struct{}
+
+func withCodeRecorder(ctx context.Context, code *codes.Code) context.Context {
+	return context.WithValue(ctx, codeKey{}, code)
+}
+
+func recordCode(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	if code, ok := ctx.Value(codeKey{}).(*codes.Code); ok {
+		*code = status.Code(err)
+	}
+	return err
+}
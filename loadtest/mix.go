@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	pb "grpc-test/proto"
+)
+
+// mix is a weighted list of products to order. It is a flag.Value in the
+// form product:min-max:weight, e.g. "Laptop:1-3:2,Phone:1:1".
+type mix []item
+
+type item struct {
+	product  string
+	min, max int
+	weight   int
+}
+
+func defaultMix() mix {
+	return mix{
+		{product: "Laptop", min: 1, max: 3, weight: 3},
+		{product: "Phone", min: 1, max: 2, weight: 2},
+		{product: "Monitor", min: 1, max: 1, weight: 1},
+	}
+}
+
+func (m *mix) String() string {
+	if m == nil {
+		return ""
+	}
+	parts := make([]string, len(*m))
+	for i, it := range *m {
+		parts[i] = fmt.Sprintf("%s:%d-%d:%d", it.product, it.min, it.max, it.weight)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (m *mix) Set(v string) error {
+	var items mix
+	for _, part := range strings.Split(v, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ":")
+		if len(fields) < 2 || len(fields) > 3 || fields[0] == "" {
+			return fmt.Errorf("expected product:min-max[:weight], got %q", part)
+		}
+		it := item{product: fields[0], weight: 1}
+		lo, hi, isRange := strings.Cut(fields[1], "-")
+		var err error
+		if it.min, err = strconv.Atoi(lo); err != nil {
+			return fmt.Errorf("quantity of %s: %w", it.product, err)
+		}
+		it.max = it.min
+		if isRange {
+			if it.max, err = strconv.Atoi(hi); err != nil {
+				return fmt.Errorf("quantity of %s: %w", it.product, err)
+			}
+		}
+		if it.min < 1 || it.max < it.min {
+			return fmt.Errorf("invalid quantity range %q for %s", fields[1], it.product)
+		}
+		if len(fields) == 3 {
+			if it.weight, err = strconv.Atoi(fields[2]); err != nil || it.weight < 1 {
+				return fmt.Errorf("invalid weight %q for %s", fields[2], it.product)
+			}
+		}
+		items = append(items, it)
+	}
+	*m = items
+	return nil
+}
+
+// pick returns a random order from the mix.
+func (m mix) pick(rng *rand.Rand) *pb.OrderRequest {
+	total := 0
+	for _, it := range m {
+		total += it.weight
+	}
+	n := rng.Intn(total)
+	for _, it := range m {
+		if n -= it.weight; n < 0 {
+			return &pb.OrderRequest{Product: it.product, Quantity: int32(it.min + rng.Intn(it.max-it.min+1))}
+		}
+	}
+	panic("unreachable")
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// Report is the JSON result of a run. Latencies are in milliseconds.
+type Report struct {
+	StartedAt   time.Time     `json:"started_at"`
+	Duration    float64       `json:"duration_seconds"`
+	Target      string        `json:"target"`
+	RPS         float64       `json:"rps,omitempty"`
+	Concurrency int           `json:"concurrency"`
+	Mix         string        `json:"mix"`
+	Seed        int64         `json:"seed"`
+	PlaceOrder  CallReport    `json:"place_order"`
+	RateStreams *StreamReport `json:"rate_streams,omitempty"`
+}
+
+type CallReport struct {
+	Requests   int64          `json:"requests"`
+	Succeeded  int64          `json:"succeeded"`
+	Failed     int64          `json:"failed"`
+	Dropped    int64          `json:"dropped"`
+	Throughput float64        `json:"throughput_rps"`
+	ErrorRate  float64        `json:"error_rate"`
+	Latency    *LatencyReport `json:"latency_ms,omitempty"`
+	Errors     []ErrorCount   `json:"errors"`
+}
+
+type StreamReport struct {
+	Streams   int            `json:"streams"`
+	Opened    int64          `json:"opened"`
+	Messages  int64          `json:"messages"`
+	FirstRate *LatencyReport `json:"first_rate_ms,omitempty"`
+	Errors    []ErrorCount   `json:"errors"`
+}
+
+type LatencyReport struct {
+	Min  float64 `json:"min"`
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P95  float64 `json:"p95"`
+	P99  float64 `json:"p99"`
+	P999 float64 `json:"p99_9"`
+	Max  float64 `json:"max"`
+}
+
+// ErrorCount is the number of calls that failed with an AppError name and
+// gRPC code. Name is empty for errors that are not AppErrors.
+type ErrorCount struct {
+	Name  string `json:"name,omitempty"`
+	Code  string `json:"grpc_code"`
+	Count int64  `json:"count"`
+}
+
+func (r *Report) write(file string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, append(data, '\n'), 0o644)
+}
+
+func readReport(file string) (*Report, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var r Report
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", file, err)
+	}
+	return &r, nil
+}
+
+func (r *Report) print(out io.Writer) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+	p := r.PlaceOrder
+	fmt.Fprintf(w, "PlaceOrder\t%d requests in %.1fs\n", p.Requests, r.Duration)
+	fmt.Fprintf(w, "  succeeded\t%d (%.1f/s)\n", p.Succeeded, p.Throughput)
+	fmt.Fprintf(w, "  failed\t%d (%.2f%%)\n", p.Failed, p.ErrorRate*100)
+	if p.Dropped > 0 {
+		fmt.Fprintf(w, "  dropped\t%d (concurrency limit reached)\n", p.Dropped)
+	}
+	printLatency(w, p.Latency)
+	printErrors(w, p.Errors)
+
+	if s := r.RateStreams; s != nil {
+		fmt.Fprintf(w, "SendExchangeRates\t%d streams, %d opened, %d rates\n", s.Streams, s.Opened, s.Messages)
+		if s.FirstRate != nil {
+			fmt.Fprintf(w, "  first rate\tp50 %.2fms\tp99 %.2fms\n", s.FirstRate.P50, s.FirstRate.P99)
+		}
+		printErrors(w, s.Errors)
+	}
+}
+
+func printLatency(w io.Writer, l *LatencyReport) {
+	if l == nil {
+		return
+	}
+	fmt.Fprintf(w, "  latency\tmin %.2fms\tmean %.2fms\tmax %.2fms\n", l.Min, l.Mean, l.Max)
+	fmt.Fprintf(w, "  \tp50 %.2fms\tp90 %.2fms\tp95 %.2fms\tp99 %.2fms\tp99.9 %.2fms\n", l.P50, l.P90, l.P95, l.P99, l.P999)
+}
+
+func printErrors(w io.Writer, errs []ErrorCount) {
+	for _, e := range errs {
+		name := e.Name
+		if name == "" {
+			name = "-"
+		}
+		fmt.Fprintf(w, "  error\t%s\t%s\t%d\n", name, e.Code, e.Count)
+	}
+}
+
+// compare prints the change of the headline numbers from base to r.
+func compare(out io.Writer, base, r *Report) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', tabwriter.AlignRight)
+	defer w.Flush()
+	fmt.Fprintln(w, "\nvs baseline\tbaseline\tthis run\tchange\t")
+	row := func(name string, a, b float64) {
+		change := "-"
+		if a != 0 {
+			change = fmt.Sprintf("%+.1f%%", (b-a)/a*100)
+		}
+		fmt.Fprintf(w, "%s\t%.2f\t%.2f\t%s\t\n", name, a, b, change)
+	}
+	row("throughput rps", base.PlaceOrder.Throughput, r.PlaceOrder.Throughput)
+	row("error rate %", base.PlaceOrder.ErrorRate*100, r.PlaceOrder.ErrorRate*100)
+	if a, b := base.PlaceOrder.Latency, r.PlaceOrder.Latency; a != nil && b != nil {
+		row("p50 ms", a.P50, b.P50)
+		row("p90 ms", a.P90, b.P90)
+		row("p99 ms", a.P99, b.P99)
+		row("p99.9 ms", a.P999, b.P999)
+		row("max ms", a.Max, b.Max)
+	}
+}
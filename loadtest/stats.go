@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"grpc-test/lib"
+	pb "grpc-test/proto"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Latencies are recorded in microseconds, from 1µs to 1m with three
+// significant digits.
+const maxLatency = int64(time.Minute / time.Microsecond)
+
+type errorKey struct {
+	name string
+	code codes.Code
+}
+
+// callStats collects the outcome of unary calls.
+type callStats struct {
+	mu      sync.Mutex
+	latency *hdrhistogram.Histogram
+	calls   int64
+	errors  map[errorKey]int64
+}
+
+func newCallStats() *callStats {
+	return &callStats{latency: hdrhistogram.New(1, maxLatency, 3), errors: map[errorKey]int64{}}
+}
+
+func (s *callStats) record(d time.Duration, err error, code codes.Code) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	// Values above the range are clamped rather than lost.
+	_ = s.latency.RecordValue(min(max(d.Microseconds(), 1), maxLatency))
+	if err != nil {
+		s.errors[errorKey{name: lib.NameOf(err), code: code}]++
+	}
+}
+
+func (s *callStats) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency.Reset()
+	s.calls = 0
+	clear(s.errors)
+}
+
+func (s *callStats) summary(elapsed time.Duration, dropped int64) CallReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	failed := int64(0)
+	for _, n := range s.errors {
+		failed += n
+	}
+	r := CallReport{
+		Requests:   s.calls,
+		Succeeded:  s.calls - failed,
+		Failed:     failed,
+		Dropped:    dropped,
+		Throughput: float64(s.calls-failed) / elapsed.Seconds(),
+		Errors:     errorCounts(s.errors),
+	}
+	if s.calls > 0 {
+		r.ErrorRate = float64(failed) / float64(s.calls)
+		r.Latency = latencyReport(s.latency)
+	}
+	return r
+}
+
+func latencyReport(h *hdrhistogram.Histogram) *LatencyReport {
+	ms := func(us int64) float64 { return float64(us) / 1000 }
+	return &LatencyReport{
+		Min:  ms(h.Min()),
+		Mean: h.Mean() / 1000,
+		P50:  ms(h.ValueAtQuantile(50)),
+		P90:  ms(h.ValueAtQuantile(90)),
+		P95:  ms(h.ValueAtQuantile(95)),
+		P99:  ms(h.ValueAtQuantile(99)),
+		P999: ms(h.ValueAtQuantile(99.9)),
+		Max:  ms(h.Max()),
+	}
+}
+
+// errorCounts returns the errors sorted by count, most frequent first.
+func errorCounts(errs map[errorKey]int64) []ErrorCount {
+	counts := make([]ErrorCount, 0, len(errs))
+	for k, n := range errs {
+		counts = append(counts, ErrorCount{Name: k.name, Code: k.code.String(), Count: n})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Name+counts[i].Code < counts[j].Name+counts[j].Code
+	})
+	return counts
+}
+
+// streamStats collects what the exchange rate streams received.
+type streamStats struct {
+	mu        sync.Mutex
+	opened    int64
+	messages  int64
+	firstRate *hdrhistogram.Histogram
+	errors    map[errorKey]int64
+}
+
+func newStreamStats() *streamStats {
+	return &streamStats{firstRate: hdrhistogram.New(1, maxLatency, 3), errors: map[errorKey]int64{}}
+}
+
+// reset clears what was received so far. Streams opened during the warmup
+// stay open, so they are still counted.
+func (s *streamStats) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = 0
+	s.firstRate.Reset()
+	clear(s.errors)
+}
+
+func (s *streamStats) summary(streams int) *StreamReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := &StreamReport{
+		Streams:  streams,
+		Opened:   s.opened,
+		Messages: s.messages,
+		Errors:   errorCounts(s.errors),
+	}
+	if s.firstRate.TotalCount() > 0 {
+		r.FirstRate = latencyReport(s.firstRate)
+	}
+	return r
+}
+
+// openStreams keeps n exchange rate streams open until ctx is done,
+// reopening any that fail after a second.
+func openStreams(ctx context.Context, client pb.CurrencyClient, n int, stats *streamStats) {
+	for range n {
+		go func() {
+			for ctx.Err() == nil {
+				err := watchRates(ctx, client, stats)
+				if ctx.Err() != nil {
+					return
+				}
+				stats.mu.Lock()
+				stats.errors[errorKey{code: status.Code(err)}]++
+				stats.mu.Unlock()
+				select {
+				case <-ctx.Done():
+				case <-time.After(time.Second):
+				}
+			}
+		}()
+	}
+}
+
+func watchRates(ctx context.Context, client pb.CurrencyClient, stats *streamStats) error {
+	start := time.Now()
+	stream, err := client.SendExchangeRates(ctx, &pb.Empty{})
+	if err != nil {
+		return err
+	}
+	stats.mu.Lock()
+	stats.opened++
+	stats.mu.Unlock()
+
+	for first := true; ; first = false {
+		if _, err := stream.Recv(); err != nil {
+			if errors.Is(err, io.EOF) {
+				return status.Error(codes.Unavailable, "stream ended by the server")
+			}
+			return err
+		}
+		stats.mu.Lock()
+		stats.messages++
+		if first {
+			_ = stats.firstRate.RecordValue(min(max(time.Since(start).Microseconds(), 1), maxLatency))
+		}
+		stats.mu.Unlock()
+	}
+}
@@ -0,0 +1,172 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"runtime"
+	"strings"
+	"sync"
+
+	logger "github.com/revotech-group/go-lib/log"
+)
+
+// Control changes what is logged at runtime: the level of each package, and
+// go-lib's debug mode, which adds stack traces to recovered panics and app
+// errors. It sits in front of go-lib's handler, which lets every record
+// through, so that levels below the configured one can be turned on later.
+type Control struct {
+	mu       sync.RWMutex
+	level    slog.Level
+	packages map[string]slog.Level
+	debug    bool
+
+	// pkgs caches the package of each logging call site.
+	pkgs sync.Map
+}
+
+// NewControl returns a control logging at level, or at the level given for a
+// package, e.g. {"orderservice": DEBUG}.
+func NewControl(level slog.Level, packages map[string]slog.Level, debug bool) *Control {
+	return &Control{level: level, packages: maps.Clone(packages), debug: debug}
+}
+
+// Install sets up go-lib's default logger behind c.
+func (c *Control) Install() {
+	c.mu.RLock()
+	debug := c.debug
+	c.mu.RUnlock()
+	logger.SetupDefaultLogger(slog.LevelDebug, debug)
+	inner := slog.Default().Handler()
+	if h, ok := inner.(*levelHandler); ok {
+		inner = h.inner
+	}
+	slog.SetDefault(slog.New(&levelHandler{control: c, inner: inner}))
+}
+
+// Levels returns the default level and those of packages.
+func (c *Control) Levels() (slog.Level, map[string]slog.Level) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.level, maps.Clone(c.packages)
+}
+
+// SetLevel sets the level of pkg, an import path such as
+// "grpc-test/orderservice" or its last element. An empty pkg sets the default.
+func (c *Control) SetLevel(pkg string, level slog.Level) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if pkg == "" {
+		c.level = level
+		return
+	}
+	if c.packages == nil {
+		c.packages = map[string]slog.Level{}
+	}
+	c.packages[pkg] = level
+}
+
+// ResetLevel makes pkg log at the default level again.
+func (c *Control) ResetLevel(pkg string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.packages, pkg)
+}
+
+// Debug reports whether debug mode is on.
+func (c *Control) Debug() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.debug
+}
+
+// SetDebug turns debug mode on or off by installing go-lib's logger again.
+// Loggers derived before, such as those of running requests, keep the mode
+// they were created with.
+func (c *Control) SetDebug(on bool) {
+	c.mu.Lock()
+	c.debug = on
+	c.mu.Unlock()
+	c.Install()
+}
+
+// ParseLevel parses a level name such as "DEBUG" or "warn".
+func ParseLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return 0, fmt.Errorf("invalid log level %q, want DEBUG, INFO, WARN or ERROR", name)
+	}
+	return level, nil
+}
+
+// minimum is the lowest level any package logs at.
+func (c *Control) minimum() slog.Level {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	lowest := c.level
+	for _, l := range c.packages {
+		lowest = min(lowest, l)
+	}
+	return lowest
+}
+
+// levelOf returns the level of the package logging from pc. The longest
+// matching package name wins.
+func (c *Control) levelOf(pc uintptr) slog.Level {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if len(c.packages) == 0 {
+		return c.level
+	}
+	pkg := c.packageOf(pc)
+	level, matched := c.level, ""
+	for name, l := range c.packages {
+		if len(name) > len(matched) && (pkg == name || strings.HasSuffix(pkg, "/"+name) || strings.HasPrefix(pkg, name+"/")) {
+			level, matched = l, name
+		}
+	}
+	return level
+}
+
+func (c *Control) packageOf(pc uintptr) string {
+	if pkg, ok := c.pkgs.Load(pc); ok {
+		return pkg.(string)
+	}
+	pkg := ""
+	if fn := runtime.FuncForPC(pc); fn != nil {
+		// e.g. "grpc-test/orderservice.(*Server).PlaceOrder"
+		name := fn.Name()
+		slash := strings.LastIndex(name, "/")
+		if dot := strings.Index(name[slash+1:], "."); dot >= 0 {
+			pkg = name[:slash+1+dot]
+		}
+	}
+	c.pkgs.Store(pc, pkg)
+	return pkg
+}
+
+// levelHandler drops the records below the level of the package logging them.
+type levelHandler struct {
+	control *Control
+	inner   slog.Handler
+}
+
+func (h *levelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.control.minimum() && h.inner.Enabled(ctx, level)
+}
+
+func (h *levelHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level < h.control.levelOf(r.PC) {
+		return nil
+	}
+	return h.inner.Handle(ctx, r)
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{control: h.control, inner: h.inner.WithAttrs(attrs)}
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{control: h.control, inner: h.inner.WithGroup(name)}
+}
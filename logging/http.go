@@ -0,0 +1,30 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"grpc-test/random"
+)
+
+// HTTPHandler sets up the request ID and logger for HTTP requests, like the
+// server interceptors do for RPCs. The ID is taken from a valid X-Request-Id
+// header or generated from rng, and echoed in the response.
+func HTTPHandler(h http.Handler, rng random.Rand) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID(rng)
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		ctx = context.WithValue(ctx, loggerKey{}, slog.Default().With(
+			slog.String("http_method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("request_id", id),
+		))
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,150 @@
+// Package logging ties log lines of one request together. Every incoming RPC
+// gets a request ID, taken from valid x-request-id metadata or generated, and a
+// request-scoped *slog.Logger stored in its context. The ID is echoed in the
+// response headers and forwarded on outgoing calls.
+package logging
+
+import (
+	"context"
+	"log/slog"
+
+	"grpc-test/random"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// RequestIDHeader is the metadata key carrying the request ID.
+const RequestIDHeader = "x-request-id"
+
+// MaxRequestIDLength bounds the request IDs taken from callers.
+const MaxRequestIDLength = 128
+
+type loggerKey struct{}
+
+type requestIDKey struct{}
+
+// FromContext returns the request-scoped logger, or the default logger
+// outside of a request.
+func FromContext(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
+
+// With returns a context whose logger has the given attributes added.
+func With(ctx context.Context, args ...any) context.Context {
+	return context.WithValue(ctx, loggerKey{}, FromContext(ctx).With(args...))
+}
+
+// RequestID returns the ID of the current request, if any.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// UnaryServerInterceptor sets up the request ID and logger for unary RPCs.
+// Request IDs are generated from rng.
+func UnaryServerInterceptor(rng random.Rand) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx = newRequestContext(ctx, info.FullMethod, req, rng)
+		grpc.SetHeader(ctx, metadata.Pairs(RequestIDHeader, RequestID(ctx)))
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor sets up the request ID and logger for streaming
+// RPCs. Request IDs are generated from rng.
+func StreamServerInterceptor(rng random.Rand) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := newRequestContext(ss.Context(), info.FullMethod, nil, rng)
+		ss.SetHeader(metadata.Pairs(RequestIDHeader, RequestID(ctx)))
+		return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// UnaryClientInterceptor forwards the request ID on outgoing unary calls.
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(outgoing(ctx), method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor forwards the request ID on outgoing streams.
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(outgoing(ctx), desc, cc, method, opts...)
+	}
+}
+
+func newRequestContext(ctx context.Context, method string, req any, rng random.Rand) context.Context {
+	id := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(RequestIDHeader); len(v) > 0 {
+			id = v[0]
+		}
+	}
+	if !validRequestID(id) {
+		id = newRequestID(rng)
+	}
+
+	attrs := []any{slog.String("method", method), slog.String("request_id", id)}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		attrs = append(attrs, slog.String("trace_id", sc.TraceID().String()))
+	}
+	if r, ok := req.(interface{ GetCustomerId() string }); ok && r.GetCustomerId() != "" {
+		attrs = append(attrs, slog.String("customer_id", r.GetCustomerId()))
+	}
+	if r, ok := req.(interface{ GetOrderId() string }); ok && r.GetOrderId() != "" {
+		attrs = append(attrs, slog.String("order_id", r.GetOrderId()))
+	}
+
+	ctx = context.WithValue(ctx, requestIDKey{}, id)
+	return context.WithValue(ctx, loggerKey{}, slog.Default().With(attrs...))
+}
+
+// validRequestID reports whether id, taken from a caller, may be used as is:
+// it ends up in logs, headers and downstream calls, so it is limited to
+// MaxRequestIDLength letters, digits and "-", "_", ".", ":".
+func validRequestID(id string) bool {
+	if id == "" || len(id) > MaxRequestIDLength {
+		return false
+	}
+	for _, c := range []byte(id) {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case c == '-' || c == '_' || c == '.' || c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID returns a random UUID drawn from rng.
+func newRequestID(rng random.Rand) string {
+	return uuid.Must(uuid.NewRandomFromReader(rng)).String()
+}
+
+func outgoing(ctx context.Context) context.Context {
+	id := RequestID(ctx)
+	if id == "" {
+		return ctx
+	}
+	if md, ok := metadata.FromOutgoingContext(ctx); ok && len(md.Get(RequestIDHeader)) > 0 {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, RequestIDHeader, id)
+}
+
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
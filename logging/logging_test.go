@@ -0,0 +1,50 @@
+package logging
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"grpc-test/random"
+
+	"google.golang.org/grpc/metadata"
+)
+
+func TestRequestIDFromCaller(t *testing.T) {
+	tests := []struct {
+		name string
+		id   string
+		keep bool
+	}{
+		{"uuid", "1d3d99bd-6f1c-4a55-9c57-3a7c54e7b1f0", true},
+		{"trace style", "web:checkout_42.3", true},
+		{"empty", "", false},
+		{"newline", "abc\nlevel=ERROR msg=forged", false},
+		{"spaces", "abc def", false},
+		{"non-ASCII", "abcé", false},
+		{"too long", strings.Repeat("a", MaxRequestIDLength+1), false},
+		{"longest", strings.Repeat("a", MaxRequestIDLength), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(RequestIDHeader, tt.id))
+			got := RequestID(newRequestContext(ctx, "/service.Order/GetOrder", nil, random.New(1)))
+			if tt.keep && got != tt.id {
+				t.Errorf("request ID = %q, want %q", got, tt.id)
+			}
+			if !tt.keep && (got == tt.id || !validRequestID(got)) {
+				t.Errorf("request ID = %q, want a generated one", got)
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "/v1/orders", nil)
+			r.Header.Set(RequestIDHeader, tt.id)
+			w := httptest.NewRecorder()
+			HTTPHandler(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}), random.New(1)).ServeHTTP(w, r)
+			if got := w.Header().Get(RequestIDHeader); (got == tt.id) != tt.keep {
+				t.Errorf("HTTP request ID = %q for %q, want kept %v", got, tt.id, tt.keep)
+			}
+		})
+	}
+}
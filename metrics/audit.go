@@ -0,0 +1,16 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var auditOutcomesLost = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "audit_outcomes_lost_total",
+	Help: "Payment actions that ran but whose outcome could not be written to the audit log, by kind. Each one needs reconciling.",
+}, []string{"kind"})
+
+// AuditOutcomeLost counts a payment action whose outcome was not recorded.
+func AuditOutcomeLost(kind string) {
+	auditOutcomesLost.WithLabelValues(kind).Inc()
+}
@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	clientAttempts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_client_extra_attempts_total",
+		Help: "Retries and hedged copies of outgoing calls, by method, kind and reason.",
+	}, []string{"method", "kind", "reason"})
+
+	breakerState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "circuit_breaker_state",
+		Help: "Circuit breaker state per downstream target: 0 closed, 1 half-open, 2 open.",
+	}, []string{"target"})
+
+	breakerTransitions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "circuit_breaker_transitions_total",
+		Help: "Circuit breaker state changes per downstream target.",
+	}, []string{"target", "from", "to"})
+)
+
+// ClientAttempt counts an extra attempt of an outgoing call, kind is "retry"
+// or "hedge".
+func ClientAttempt(method, kind, reason string) {
+	clientAttempts.WithLabelValues(method, kind, reason).Inc()
+}
+
+// BreakerState sets the current state of a circuit breaker.
+func BreakerState(target string, state int) {
+	breakerState.WithLabelValues(target).Set(float64(state))
+}
+
+// BreakerTransition records a circuit breaker state change.
+func BreakerTransition(target, from, to string, state int) {
+	breakerTransitions.WithLabelValues(target, from, to).Inc()
+	BreakerState(target, state)
+}
@@ -0,0 +1,32 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	deadlineBudget = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "grpc_deadline_budget_seconds",
+		Help:    "Time left until the deadline when a request arrives.",
+		Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"method"})
+
+	deadlineRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_deadline_rejected_total",
+		Help: "Calls rejected because their remaining deadline was too short.",
+	}, []string{"method"})
+)
+
+// DeadlineBudget observes the remaining deadline of an incoming request.
+func DeadlineBudget(method string, remaining time.Duration) {
+	deadlineBudget.WithLabelValues(method).Observe(remaining.Seconds())
+}
+
+// DeadlineRejected counts a call refused for lack of time, incoming or
+// outgoing.
+func DeadlineRejected(method string) {
+	deadlineRejected.WithLabelValues(method).Inc()
+}
@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"grpc-test/clock"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	ordersPlaced = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "orders_placed_total",
+		Help: "Orders placed and paid successfully.",
+	})
+
+	chargesDeclined = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "charges_declined_total",
+		Help: "Charges declined by the payment service, by reason.",
+	}, []string{"reason"})
+
+	exchangeRateUpdates = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "exchange_rate_updates_total",
+		Help: "Exchange rate updates received, by currency pair.",
+	}, []string{"pair"})
+
+	lastExchangeRate = newLastUpdate("exchange_rate_last_update_age_seconds", "Seconds since the last exchange rate update, by currency pair.")
+)
+
+// OrderPlaced counts a successfully placed order.
+func OrderPlaced() {
+	ordersPlaced.Inc()
+}
+
+// ChargeDeclined counts a declined charge, reason is the name of the error
+// detail, e.g. "ErrNotEnoughCharge".
+func ChargeDeclined(reason string) {
+	chargesDeclined.WithLabelValues(reason).Inc()
+}
+
+// ExchangeRateReceived counts an exchange rate update for a currency pair.
+func ExchangeRateReceived(from, to string) {
+	pair := from + "/" + to
+	exchangeRateUpdates.WithLabelValues(pair).Inc()
+	lastExchangeRate.touch(pair)
+}
+
+// gaugeClock is the clock the last update gauges are measured on.
+var (
+	gaugeMu    sync.Mutex
+	gaugeClock clock.Clock = clock.Real
+)
+
+// SetClock replaces the system clock of the last update gauges. server.New
+// sets the clock of the server.
+func SetClock(c clock.Clock) {
+	gaugeMu.Lock()
+	defer gaugeMu.Unlock()
+	gaugeClock = c
+}
+
+func currentClock() clock.Clock {
+	gaugeMu.Lock()
+	defer gaugeMu.Unlock()
+	return gaugeClock
+}
+
+// lastUpdate reports, at scrape time, how long ago each label was touched.
+type lastUpdate struct {
+	desc *prometheus.Desc
+
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+func newLastUpdate(name, help string) *lastUpdate {
+	u := &lastUpdate{
+		desc: prometheus.NewDesc(name, help, []string{"pair"}, nil),
+		last: map[string]time.Time{},
+	}
+	prometheus.MustRegister(u)
+	return u
+}
+
+func (u *lastUpdate) touch(pair string) {
+	u.mu.Lock()
+	u.last[pair] = currentClock().Now()
+	u.mu.Unlock()
+}
+
+func (u *lastUpdate) Describe(ch chan<- *prometheus.Desc) {
+	ch <- u.desc
+}
+
+func (u *lastUpdate) Collect(ch chan<- prometheus.Metric) {
+	clk := currentClock()
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for pair, t := range u.last {
+		ch <- prometheus.MustNewConstMetric(u.desc, prometheus.GaugeValue, clk.Since(t).Seconds(), pair)
+	}
+}
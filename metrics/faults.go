@@ -0,0 +1,16 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var faultsInjected = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "faults_injected_total",
+	Help: "Faults injected into calls by kind: latency, code, error, panic or abort.",
+}, []string{"method", "side", "kind"})
+
+// FaultInjected counts a fault injected into a call.
+func FaultInjected(method, side, kind string) {
+	faultsInjected.WithLabelValues(method, side, kind).Inc()
+}
@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"grpc-test/lib"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// The gRPC code is only known once go-lib's error interceptor has converted
+// the AppError into a status, and the AppError name only before that. The
+// outer interceptors go first in the chain and record the metrics; the inner
+// ones go after go-lib's and pass the AppError name up through the context.
+
+type errorNameKey struct{}
+
+// UnaryServerInterceptor records RED metrics. It must be first in the chain.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		name := new(string)
+		start := time.Now()
+		resp, err := handler(context.WithValue(ctx, errorNameKey{}, name), req)
+		observe(info.FullMethod, start, err, *name)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor is UnaryServerInterceptor for streaming RPCs.
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		name := new(string)
+		start := time.Now()
+		err := handler(srv, &contextStream{ServerStream: ss, ctx: context.WithValue(ss.Context(), errorNameKey{}, name)})
+		observe(info.FullMethod, start, err, *name)
+		return err
+	}
+}
+
+// UnaryErrorNameInterceptor captures the AppError name for
+// UnaryServerInterceptor. It must come after go-lib's error interceptor.
+func UnaryErrorNameInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		resp, err := handler(ctx, req)
+		setErrorName(ctx, err)
+		return resp, err
+	}
+}
+
+// StreamErrorNameInterceptor is UnaryErrorNameInterceptor for streaming RPCs.
+func StreamErrorNameInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		err := handler(srv, ss)
+		setErrorName(ss.Context(), err)
+		return err
+	}
+}
+
+func setErrorName(ctx context.Context, err error) {
+	if name, ok := ctx.Value(errorNameKey{}).(*string); ok && err != nil {
+		*name = lib.NameOf(err)
+	}
+}
+
+func observe(method string, start time.Time, err error, errorName string) {
+	requests.WithLabelValues(method).Inc()
+	requestDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	if err != nil {
+		requestErrors.WithLabelValues(method, status.Code(err).String(), errorName).Inc()
+	}
+}
+
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
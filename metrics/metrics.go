@@ -0,0 +1,65 @@
+// Package metrics exposes Prometheus metrics for the services: RED metrics for
+// every RPC and counters for domain events.
+package metrics
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Config controls the /metrics HTTP listener.
+type Config struct {
+	// Addr is the address of the HTTP listener, metrics are not served when empty.
+	Addr string `json:"addr"`
+}
+
+var (
+	requests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_server_requests_total",
+		Help: "RPCs handled, by method.",
+	}, []string{"method"})
+
+	requestErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_server_errors_total",
+		Help: "RPCs that returned an error, by method, gRPC code and AppError name.",
+	}, []string{"method", "code", "error_name"})
+
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "grpc_server_request_duration_seconds",
+		Help:    "Time to handle an RPC, by method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method"})
+)
+
+// Serve exposes /metrics on addr until ctx is done.
+func Serve(ctx context.Context, addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	slog.Info("Serving metrics", slog.String("addr", lis.Addr().String()))
+	if err := srv.Serve(lis); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
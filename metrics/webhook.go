@@ -0,0 +1,16 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var webhookDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "webhook_delivery_attempts_total",
+	Help: "Webhook delivery attempts by event type and outcome: delivered, retry or dead.",
+}, []string{"event", "outcome"})
+
+// WebhookDelivery counts a webhook delivery attempt.
+func WebhookDelivery(event, outcome string) {
+	webhookDeliveries.WithLabelValues(event, outcome).Inc()
+}
@@ -0,0 +1,276 @@
+// Package orderservice implements the Order service, which charges customers
+// through the Charge service.
+package orderservice
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"grpc-test/admin"
+	"grpc-test/authz"
+	"grpc-test/contract"
+	"grpc-test/deadline"
+	"grpc-test/lib"
+	"grpc-test/logging"
+	"grpc-test/metrics"
+	pb "grpc-test/proto" // Replace with the correct import path
+	"grpc-test/random"
+	"grpc-test/rates"
+	"grpc-test/resilience"
+	"grpc-test/server"
+	"grpc-test/tracing"
+	"grpc-test/webhook"
+
+	"github.com/google/uuid"
+	"github.com/revotech-group/go-lib/errors"
+	"github.com/revotech-group/go-lib/grpc/interceptors"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// Server implements pb.OrderServer.
+type Server struct {
+	pb.UnimplementedOrderServer
+	chargeClient pb.ChargeClient
+	rand         random.Rand // Order IDs are drawn from it
+	webhooks     *webhook.Dispatcher
+	rates        *rates.Cache // Last exchange rate of every pair, from the broker
+
+	mu     sync.Mutex
+	orders map[string]*pb.OrderResponse // Kept in memory for simplicity
+}
+
+// NewServer returns an Order service that charges through chargeClient.
+func NewServer(chargeClient pb.ChargeClient, rng random.Rand) *Server {
+	return &Server{chargeClient: chargeClient, rand: rng, rates: rates.NewCache(), orders: map[string]*pb.OrderResponse{}}
+}
+
+// Rates returns the last exchange rates received from the broker, empty
+// unless the service has one.
+func (s *Server) Rates() *rates.Cache {
+	return s.rates
+}
+
+func (s *Server) save(order *pb.OrderResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.orders[order.OrderId] = proto.Clone(order).(*pb.OrderResponse)
+}
+
+// visible reports whether the caller may see order: callers that act for a
+// customer only see that customer's orders.
+func visible(ctx context.Context, order *pb.OrderResponse) bool {
+	p, ok := authz.FromContext(ctx)
+	return !ok || p.CustomerID == "" || order.CustomerId == p.CustomerID
+}
+
+// lookup returns a copy of an order.
+func (s *Server) lookup(ctx context.Context, id string) (*pb.OrderResponse, error) {
+	s.mu.Lock()
+	order, ok := s.orders[id]
+	s.mu.Unlock()
+
+	if !ok || !visible(ctx, order) {
+		return nil, lib.ErrNotFound().WithMessage("Order " + id + " not found")
+	}
+	return proto.Clone(order).(*pb.OrderResponse), nil
+}
+
+func (s *Server) GetOrder(ctx context.Context, req *pb.GetOrderRequest) (*pb.OrderResponse, error) {
+	return s.lookup(ctx, req.Id)
+}
+
+func (s *Server) ListOrders(ctx context.Context, req *pb.ListOrdersRequest) (*pb.ListOrdersResponse, error) {
+	customerID := req.CustomerId
+	if p, ok := authz.FromContext(ctx); ok && p.CustomerID != "" {
+		customerID = p.CustomerID
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resp := &pb.ListOrdersResponse{}
+	for _, order := range s.orders {
+		if customerID == "" || order.CustomerId == customerID {
+			resp.Orders = append(resp.Orders, proto.Clone(order).(*pb.OrderResponse))
+		}
+	}
+	slices.SortFunc(resp.Orders, func(a, b *pb.OrderResponse) int { return strings.Compare(a.OrderId, b.OrderId) })
+	return resp, nil
+}
+
+func (s *Server) CancelOrder(ctx context.Context, req *pb.CancelOrderRequest) (*pb.OrderResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	order, ok := s.orders[req.Id]
+	if !ok || !visible(ctx, order) {
+		return nil, lib.ErrNotFound().WithMessage("Order " + req.Id + " not found")
+	}
+	if order.Status == pb.OrderStatus_ORDER_STATUS_CANCELLED {
+		return nil, lib.ErrBadRequest().WithMessage("Order " + req.Id + " is already cancelled")
+	}
+
+	order.Status = pb.OrderStatus_ORDER_STATUS_CANCELLED
+	order.Message = "Cancelled"
+	if req.Reason != "" {
+		order.Message += ": " + req.Reason
+	}
+	logging.FromContext(ctx).Info("Order cancelled", slog.String("order_id", req.Id), slog.String("reason", req.Reason))
+	s.webhooks.Publish(ctx, webhook.OrderCancelled, order.CustomerId, order)
+	return proto.Clone(order).(*pb.OrderResponse), nil
+}
+
+func (s *Server) PlaceOrder(ctx context.Context, req *pb.OrderRequest) (*pb.OrderResponse, error) {
+	orderID := uuid.Must(uuid.NewRandomFromReader(s.rand)).String()
+	customerID := "12345" // Hardcoded for simplicity
+	if p, ok := authz.FromContext(ctx); ok && p.CustomerID != "" {
+		customerID = p.CustomerID
+	}
+	tracing.SetAttributes(ctx, tracing.OrderID.String(orderID), tracing.CustomerID.String(customerID))
+	ctx = logging.With(ctx, slog.String("order_id", orderID), slog.String("customer_id", customerID))
+	logger := logging.FromContext(ctx)
+	logger.Info("Order received", slog.String("product", req.Product), slog.Int("quantity", int(req.Quantity)))
+	order := &pb.OrderResponse{
+		OrderId:    orderID,
+		Product:    req.Product,
+		Quantity:   req.Quantity,
+		CustomerId: customerID,
+	}
+
+	// Call the Charge Server
+	chargeResponse, err := s.chargeClient.ChargeCustomer(ctx, &pb.ChargeRequest{
+		CustomerId: customerID,
+		Amount:     float32(req.Quantity) * 100, // Example calculation
+		OrderId:    orderID,
+	})
+
+	if err != nil {
+		// Deadlines, faults and the resilience layer may fail the call with a
+		// plain status instead of an AppError.
+		appErr, ok := err.(errors.AppError)
+		switch {
+		case !ok:
+			st := status.Convert(err)
+			logger.Warn("Charge failed", slog.String("code", st.Code().String()), slog.String("message", st.Message()))
+		case appErr.GetProtobufError() != nil:
+			detail := appErr.GetProtobufError()
+			if handle, ok := chargeErrors[contract.DetailName(detail)]; ok {
+				handle(logger, detail)
+			} else {
+				logger.Error("Received unexpected GRPC error", slog.String("type", fmt.Sprintf("%T", detail)))
+			}
+		default:
+			logger.Warn("No GRPC error found in appErr")
+		}
+		order.Status = pb.OrderStatus_ORDER_STATUS_PAYMENT_FAILED
+		order.Message = "Payment failed: " + err.Error()
+		s.save(order)
+		s.webhooks.Publish(ctx, webhook.OrderPaymentFailed, customerID, order)
+		return nil, err
+	}
+
+	metrics.OrderPlaced()
+	order.Status = pb.OrderStatus_ORDER_STATUS_PAID
+	order.Message = fmt.Sprintf("Order placed for %d x %s. %s", req.Quantity, req.Product, chargeResponse.Message)
+	s.save(order)
+	s.webhooks.Publish(ctx, webhook.OrderPaid, customerID, order)
+	return order, nil
+}
+
+// chargeErrors handle the error details of ChargeCustomer, by type.
+// TestChargeContract fails when Charge may return a detail missing here.
+var chargeErrors = map[string]func(logger *slog.Logger, detail proto.Message){
+	contract.DetailName(&pb.ErrGatewayNotReachable{}): func(logger *slog.Logger, _ proto.Message) {
+		logger.Warn("Received ErrGatewayNotReachable error")
+	},
+	contract.DetailName(&pb.ErrNotEnoughCharge{}): func(logger *slog.Logger, _ proto.Message) {
+		logger.Warn("Received NotEnoughCharge error")
+	},
+	contract.DetailName(&pb.ErrTooManyRequests{}): func(logger *slog.Logger, detail proto.Message) {
+		t := detail.(*pb.ErrTooManyRequests)
+		logger.Warn("Received TooManyRequests error", slog.Duration("retry_delay", t.GetRetryInfo().GetRetryDelay().AsDuration()))
+	},
+	contract.DetailName(&errdetails.ErrorInfo{}): func(logger *slog.Logger, detail proto.Message) {
+		logger.Warn("Charge denied", slog.String("reason", detail.(*errdetails.ErrorInfo).GetReason()))
+	},
+}
+
+// DefaultConfig returns the defaults of the order service.
+func DefaultConfig() server.Config {
+	return server.Config{
+		Addr:         ":50051",
+		LogLevel:     slog.LevelDebug,
+		Debug:        true,
+		Metrics:      metrics.Config{Addr: ":9091"},
+		Admin:        admin.Config{Addr: "localhost:50061"},
+		Dependencies: map[string]string{"charge": "localhost:50052"},
+		Deadline: deadline.Config{
+			HopMargin: lib.Duration(50 * time.Millisecond),
+			Rules: []deadline.Rule{{
+				Methods:      []string{"/service.Order/PlaceOrder"},
+				MinRemaining: lib.Duration(100 * time.Millisecond),
+				Default:      lib.Duration(5 * time.Second),
+			}},
+		},
+		// Charge is not idempotent and payment does not deduplicate on the
+		// order ID, so it is only retried on errors raised before anything was
+		// charged, and never hedged. Unavailable is not among them: it also
+		// covers a connection lost after Charge received the request.
+		Resilience: resilience.Config{
+			Retry: resilience.RetryPolicy{
+				MaxAttempts:    3,
+				InitialBackoff: lib.Duration(50 * time.Millisecond),
+				MaxBackoff:     lib.Duration(500 * time.Millisecond),
+				Multiplier:     2,
+				RetryOn:        []string{"ErrGatewayNotReachable", "ErrTooManyRequests"},
+				NeverRetry:     []string{"ErrNotEnoughCharge"},
+			},
+			Breaker: resilience.BreakerPolicy{
+				FailureThreshold: 5,
+				OpenTimeout:      lib.Duration(10 * time.Second),
+				HalfOpenProbes:   1,
+			},
+		},
+	}
+}
+
+// Start connects to the Charge service and registers the Order service on
+// srv, and subscribes to exchange rates on the broker of srv, if any. The dial
+// does not block: Order reports NOT_SERVING until the connection is ready.
+// dialOpts are added to those of srv, and the connection is closed once srv
+// has stopped.
+func Start(srv *server.Server, dialOpts ...grpc.DialOption) (*Server, error) {
+	cfg := srv.Config()
+	// Resilience goes first so that it sees the AppErrors decoded by go-lib.
+	opts := append([]grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(
+			resilience.New(cfg.Resilience, resilience.WithClock(srv.Clock()), resilience.WithRand(srv.Rand("resilience"))).Unary(),
+			interceptors.UnaryClientErrorInterceptor(),
+		),
+	}, srv.DialOptions()...)
+	chargeConn, err := grpc.Dial(cfg.Dependency("charge"), append(opts, dialOpts...)...)
+	if err != nil {
+		return nil, fmt.Errorf("connect to Charge Server: %w", err)
+	}
+	srv.OnStop(func(context.Context) error { return chargeConn.Close() })
+	srv.Go(func(ctx context.Context) { srv.WatchConn(ctx, pb.Order_ServiceDesc.ServiceName, chargeConn) })
+
+	s := NewServer(pb.NewChargeClient(chargeConn), srv.Rand("orders"))
+	s.webhooks = srv.Webhooks()
+	if b := srv.Broker(); b != nil {
+		sub, err := rates.Subscribe(b, cfg.Broker.Group, s.rates)
+		if err != nil {
+			return nil, fmt.Errorf("subscribe to exchange rates: %w", err)
+		}
+		srv.OnStop(func(context.Context) error { return sub.Unsubscribe() })
+	}
+	pb.RegisterOrderServer(srv.GRPC(), s)
+	return s, nil
+}
@@ -0,0 +1,70 @@
+package orderservice
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"grpc-test/contract"
+	pb "grpc-test/proto"
+	"grpc-test/random"
+
+	"github.com/revotech-group/go-lib/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// failingCharge fails every ChargeCustomer call with err.
+type failingCharge struct {
+	pb.ChargeClient
+	err error
+}
+
+func (c failingCharge) ChargeCustomer(context.Context, *pb.ChargeRequest, ...grpc.CallOption) (*pb.ChargeResponse, error) {
+	return nil, c.err
+}
+
+// captureLogs sends the default logger to a buffer for the rest of the test.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+	return &buf
+}
+
+func TestPlaceOrderStatusError(t *testing.T) {
+	captureLogs(t)
+	want := status.Error(codes.DeadlineExceeded, "budget exhausted")
+	s := NewServer(failingCharge{err: want}, random.New(1))
+
+	_, err := s.PlaceOrder(context.Background(), &pb.OrderRequest{Product: "Laptop", Quantity: 1})
+	if err != want {
+		t.Fatalf("PlaceOrder() error = %v, want %v", err, want)
+	}
+	list, _ := s.ListOrders(context.Background(), &pb.ListOrdersRequest{})
+	if len(list.Orders) != 1 || list.Orders[0].Status != pb.OrderStatus_ORDER_STATUS_PAYMENT_FAILED {
+		t.Errorf("orders = %v, want one PAYMENT_FAILED order", list.Orders)
+	}
+}
+
+// TestChargeContract drives every error Charge declares for ChargeCustomer
+// through PlaceOrder, and fails when the detail of one is not handled.
+func TestChargeContract(t *testing.T) {
+	for _, chargeErr := range contract.Errors(pb.Charge_ChargeCustomer_FullMethodName) {
+		t.Run(chargeErr.Error(), func(t *testing.T) {
+			logs := captureLogs(t)
+			s := NewServer(failingCharge{err: chargeErr}, random.New(1))
+
+			if _, err := s.PlaceOrder(context.Background(), &pb.OrderRequest{Product: "Laptop", Quantity: 1}); err == nil {
+				t.Fatal("PlaceOrder() succeeded, want an error")
+			}
+			if strings.Contains(logs.String(), "unexpected GRPC error") {
+				t.Errorf("detail %T is not handled:\n%s", chargeErr.(errors.AppError).GetProtobufError(), logs)
+			}
+		})
+	}
+}
@@ -0,0 +1,233 @@
+// Package paymentservice implements the Charge service. It is ready only
+// while it receives exchange rates, unless the require_rates feature flag is
+// turned off. Rates come from the broker of the service if it has one, and
+// otherwise from a stream of the Currency service.
+package paymentservice
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"grpc-test/admin"
+	"grpc-test/clock"
+	"grpc-test/deadline"
+	"grpc-test/lib"
+	"grpc-test/logging"
+	"grpc-test/metrics"
+	pb "grpc-test/proto" // Replace with the correct import path
+	"grpc-test/ratelimit"
+	"grpc-test/rates"
+	"grpc-test/server"
+	"grpc-test/tlsconfig"
+	"grpc-test/tracing"
+	"grpc-test/webhook"
+
+	"github.com/revotech-group/go-lib/errors"
+	"github.com/revotech-group/go-lib/grpc/interceptors"
+	"google.golang.org/grpc"
+)
+
+// Gateway charges customers on behalf of the Charge service.
+type Gateway interface {
+	Charge(ctx context.Context, req *pb.ChargeRequest) (*pb.ChargeResponse, error)
+}
+
+// GatewayFunc adapts a function to a Gateway.
+type GatewayFunc func(ctx context.Context, req *pb.ChargeRequest) (*pb.ChargeResponse, error)
+
+func (f GatewayFunc) Charge(ctx context.Context, req *pb.ChargeRequest) (*pb.ChargeResponse, error) {
+	return f(ctx, req)
+}
+
+// ApproveGateway approves every charge. Declines are rehearsed with fault
+// injection, see the faults package.
+type ApproveGateway struct{}
+
+func (ApproveGateway) Charge(_ context.Context, req *pb.ChargeRequest) (*pb.ChargeResponse, error) {
+	return &pb.ChargeResponse{Message: fmt.Sprintf("Charged %.2f", req.Amount)}, nil
+}
+
+// Server implements pb.ChargeServer.
+type Server struct {
+	pb.UnimplementedChargeServer
+	gateway  Gateway
+	rates    *rates.Cache // Last exchange rate of every pair
+	webhooks *webhook.Dispatcher
+}
+
+// NewServer returns a Charge service that charges through gateway.
+func NewServer(gateway Gateway) *Server {
+	return &Server{gateway: gateway, rates: rates.NewCache()}
+}
+
+// Rates returns the last exchange rates received.
+func (s *Server) Rates() *rates.Cache {
+	return s.rates
+}
+
+func (s *Server) ChargeCustomer(ctx context.Context, req *pb.ChargeRequest) (*pb.ChargeResponse, error) {
+	tracing.SetAttributes(ctx, tracing.OrderID.String(req.OrderId), tracing.CustomerID.String(req.CustomerId))
+	logging.FromContext(ctx).Info("Charge request received", slog.Float64("amount", float64(req.Amount)))
+
+	event := &pb.PaymentEvent{OrderId: req.OrderId, CustomerId: req.CustomerId, Amount: req.Amount}
+	resp, err := s.gateway.Charge(ctx, req)
+	if err != nil {
+		event.Reason, event.Message = declineReason(err), err.Error()
+		metrics.ChargeDeclined(event.Reason)
+		s.webhooks.Publish(ctx, webhook.PaymentDeclined, req.CustomerId, event)
+		return nil, err
+	}
+	event.Message = resp.Message
+	s.webhooks.Publish(ctx, webhook.PaymentCharged, req.CustomerId, event)
+	return resp, nil
+}
+
+// declineReason names a declined charge by its error detail.
+func declineReason(err error) string {
+	if appErr, ok := err.(errors.AppError); ok && appErr.GetProtobufError() != nil {
+		return string(appErr.GetProtobufError().ProtoReflect().Descriptor().Name())
+	}
+	return lib.NameOf(err)
+}
+
+// subscribeToExchangeRates keeps a subscription to the currency service open
+// until ctx is done, reconnecting with a backoff. ready reports whether exchange
+// rates are currently being received.
+func subscribeToExchangeRates(ctx context.Context, clk clock.Clock, addr string, dialOpts []grpc.DialOption, cache *rates.Cache, ready func(bool)) {
+	// Establish connection to the currency service
+	dialOpts = append(dialOpts, grpc.WithStreamInterceptor(interceptors.ClientStreamErrorInterceptor))
+	conn, err := grpc.Dial(addr, dialOpts...)
+	if err != nil {
+		slog.Error("Failed to connect to currency service", slog.Any("error", err))
+		return
+	}
+	defer conn.Close()
+
+	// Create a new currency client
+	client := pb.NewCurrencyClient(conn)
+
+	backoff := time.Second
+	for {
+		received, err := receiveExchangeRates(ctx, client, cache, ready)
+		ready(false)
+		if ctx.Err() != nil {
+			return
+		}
+		if received {
+			backoff = time.Second
+		}
+		slog.Warn("Exchange rate subscription lost", slog.Any("error", err), slog.Duration("retry_in", backoff))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-clk.After(backoff):
+		}
+		backoff = min(backoff*2, 30*time.Second)
+	}
+}
+
+func receiveExchangeRates(ctx context.Context, client pb.CurrencyClient, cache *rates.Cache, ready func(bool)) (received bool, err error) {
+	// Subscribe to exchange rates from the currency service
+	stream, err := client.SendExchangeRates(ctx, &pb.Empty{})
+	if err != nil {
+		return false, err
+	}
+
+	// Continuously receive exchange rates
+	for {
+		exchangeRate, err := stream.Recv()
+		if err != nil {
+			// Check for EOF or stream closure
+			if err == io.EOF {
+				return received, fmt.Errorf("stream closed by server")
+			}
+			return received, err
+		}
+		received = true
+		ready(true)
+		metrics.ExchangeRateReceived(exchangeRate.CurrencyFrom, exchangeRate.CurrencyTo)
+		cache.Put(exchangeRate)
+
+		// Process the received exchange rate
+		slog.Debug("Received exchange rate",
+			slog.String("from", exchangeRate.CurrencyFrom),
+			slog.String("to", exchangeRate.CurrencyTo),
+			slog.Float64("rate", exchangeRate.Rate),
+		)
+	}
+}
+
+// DefaultConfig returns the defaults of the payment service.
+func DefaultConfig() server.Config {
+	return server.Config{
+		Addr:     ":50052",
+		LogLevel: slog.LevelDebug,
+		Debug:    true,
+		Metrics:  metrics.Config{Addr: ":9092"},
+		Admin:    admin.Config{Addr: "localhost:50062"},
+		// Enforced once client certificates are required with -tls-client-auth
+		TLS: tlsconfig.Config{
+			AllowedPeers: map[string][]string{"/service.Charge/ChargeCustomer": {"order"}},
+		},
+		Dependencies: map[string]string{"currency": "localhost:50053"},
+		Deadline: deadline.Config{
+			Rules: []deadline.Rule{{
+				Methods:      []string{"/service.Charge/ChargeCustomer"},
+				MinRemaining: lib.Duration(20 * time.Millisecond),
+				Default:      lib.Duration(2 * time.Second),
+			}},
+		},
+		RateLimit: ratelimit.Config{
+			Rules: []ratelimit.Rule{{Methods: []string{"/service.Charge/ChargeCustomer"}, MaxConcurrent: 100}},
+		},
+	}
+}
+
+// Start registers the Charge service on srv and subscribes to exchange rates
+// while srv is serving, on the broker of srv or else from the Currency
+// service. dialOpts are added to those of srv for the Currency connection.
+func Start(srv *server.Server, gateway Gateway, dialOpts ...grpc.DialOption) *Server {
+	s := NewServer(gateway)
+	s.webhooks = srv.Webhooks()
+	pb.RegisterChargeServer(srv.GRPC(), s)
+
+	// Charge is ready only while exchange rates are flowing
+	requireRates := srv.Features().Define("require_rates", "Charge is ready only while exchange rates are received", true)
+	var receiving atomic.Bool
+	setReady := func() {
+		srv.SetReady(pb.Charge_ServiceDesc.ServiceName, receiving.Load() || !requireRates.Enabled())
+	}
+	setReady()
+	requireRates.OnChange(func(bool) { setReady() })
+
+	// The cache keeps the last rates while the broker is away, so Charge
+	// stays ready once it has received any.
+	if b := srv.Broker(); b != nil {
+		s.rates.OnUpdate(func(*pb.ExchangeRate) {
+			if !receiving.Swap(true) {
+				setReady()
+			}
+		})
+		sub, err := rates.Subscribe(b, srv.Config().Broker.Group, s.rates)
+		if err != nil {
+			slog.Error("Failed to subscribe to exchange rates", slog.Any("error", err))
+			return s
+		}
+		srv.OnStop(func(context.Context) error { return sub.Unsubscribe() })
+		return s
+	}
+
+	addr, opts := srv.Config().Dependency("currency"), append(srv.DialOptions(), dialOpts...)
+	srv.Go(func(ctx context.Context) {
+		subscribeToExchangeRates(ctx, srv.Clock(), addr, opts, s.rates, func(ready bool) {
+			receiving.Store(ready)
+			setReady()
+		})
+	})
+	return s
+}
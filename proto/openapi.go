@@ -0,0 +1,9 @@
+package proto
+
+import _ "embed"
+
+// OpenAPI is the OpenAPI v2 document of the HTTP/JSON API, generated from the
+// google.api.http annotations in service.proto.
+//
+//go:embed service.swagger.json
+var OpenAPI []byte
@@ -0,0 +1,76 @@
+// Package random provides seeded random number generators, so that a run
+// started with the same seed makes the same random choices. Each consumer
+// derives its own generator by name, so that one consumer drawing more numbers
+// does not change what the others get.
+package random
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math/rand/v2"
+	"sync"
+)
+
+// Rand is a source of random numbers safe for concurrent use.
+type Rand interface {
+	IntN(n int) int
+	Int64N(n int64) int64
+	Float64() float64
+	// Read fills p with random bytes, e.g. to generate UUIDs.
+	Read(p []byte) (int, error)
+}
+
+// NewSeed returns a non-zero seed for runs that were not given one.
+func NewSeed() int64 {
+	for {
+		if seed := rand.Int64(); seed != 0 {
+			return seed
+		}
+	}
+}
+
+// New returns a generator seeded with seed.
+func New(seed int64) Rand {
+	return Derive(seed, "")
+}
+
+// Derive returns the generator called name of a run seeded with seed.
+func Derive(seed int64, name string) Rand {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return &locked{r: rand.New(rand.NewPCG(uint64(seed), h.Sum64()))}
+}
+
+type locked struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+func (l *locked) IntN(n int) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.IntN(n)
+}
+
+func (l *locked) Int64N(n int64) int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Int64N(n)
+}
+
+func (l *locked) Float64() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Float64()
+}
+
+func (l *locked) Read(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var buf [8]byte
+	for i := 0; i < len(p); i += len(buf) {
+		binary.LittleEndian.PutUint64(buf[:], l.r.Uint64())
+		copy(p[i:], buf[:])
+	}
+	return len(p), nil
+}
@@ -0,0 +1,286 @@
+// Package ratelimit enforces token-bucket limits per method and per caller,
+// and optional concurrency limits, rejecting excess calls with a
+// TooManyRequestsError. Limits can be changed at runtime.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"grpc-test/authz"
+	"grpc-test/clock"
+	"grpc-test/lib"
+	pb "grpc-test/proto"
+
+	"golang.org/x/time/rate"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Config lists the limits. Rules are matched in order against the full
+// method name and the first match applies; unmatched methods are unlimited.
+// When File is set, rules are read from it and re-read whenever it changes.
+type Config struct {
+	File  string `json:"file"`
+	Rules []Rule `json:"rules"`
+}
+
+// Rule sets the limits of the matching methods. Zero values disable a limit.
+type Rule struct {
+	// Methods are path.Match patterns such as "/service.Charge/*".
+	Methods []string `json:"methods"`
+	// RPS and Burst bound all calls to a method together.
+	RPS   float64 `json:"rps"`
+	Burst int     `json:"burst"`
+	// CallerRPS and CallerBurst bound the calls of each caller to a method.
+	CallerRPS   float64 `json:"caller_rps"`
+	CallerBurst int     `json:"caller_burst"`
+	// MaxConcurrent bounds the in-flight calls to a method.
+	MaxConcurrent int `json:"max_concurrent"`
+}
+
+// idleTimeout is how long an unused per-caller bucket is kept.
+const idleTimeout = 10 * time.Minute
+
+// Limiter holds the buckets of the current rules.
+type Limiter struct {
+	clock clock.Clock
+	slots *slots
+	state atomic.Pointer[state]
+}
+
+type state struct {
+	rules []Rule
+	slots *slots
+
+	mu        sync.Mutex
+	methods   map[string]*methodState
+	lastSweep time.Time
+}
+
+type methodState struct {
+	rule     Rule
+	limiter  *rate.Limiter
+	inFlight chan struct{}
+	callers  map[string]*callerBucket
+}
+
+type callerBucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// slots holds the concurrency slots of every method, across updates, so that
+// calls in flight when the rules change still count against the limit.
+type slots struct {
+	mu       sync.Mutex
+	byMethod map[string]chan struct{}
+}
+
+// get returns the slots of method, new ones if its limit changed to n.
+func (s *slots) get(method string, n int) chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ch, ok := s.byMethod[method]; ok && cap(ch) == n {
+		return ch
+	}
+	ch := make(chan struct{}, n)
+	s.byMethod[method] = ch
+	return ch
+}
+
+// NewLimiter returns a limiter whose buckets fill up on clk.
+func NewLimiter(rules []Rule, clk clock.Clock) *Limiter {
+	l := &Limiter{clock: clk, slots: &slots{byMethod: map[string]chan struct{}{}}}
+	l.Update(rules)
+	return l
+}
+
+// Update replaces the rules. Buckets start full again, while the calls in
+// flight keep counting against concurrency limits that did not change.
+func (l *Limiter) Update(rules []Rule) {
+	l.state.Store(&state{rules: rules, slots: l.slots, methods: map[string]*methodState{}, lastSweep: l.clock.Now()})
+}
+
+// Rules returns the rules in effect.
+func (l *Limiter) Rules() []Rule {
+	return l.state.Load().rules
+}
+
+// UnaryServerInterceptor applies the limits to unary RPCs.
+func (l *Limiter) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		release, err := l.acquire(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor applies the limits to opening streams.
+func (l *Limiter) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		release, err := l.acquire(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		defer release()
+		return handler(srv, ss)
+	}
+}
+
+func (l *Limiter) acquire(ctx context.Context, method string) (func(), error) {
+	st := l.state.Load()
+	m := st.method(method)
+	if m == nil {
+		return func() {}, nil
+	}
+	now := l.clock.Now()
+
+	// Every limit is checked before any is consumed: the tokens taken are
+	// given back when a later check rejects the call, so that the callers
+	// over their own limit do not drain the budget of the method.
+	release := func() {}
+	if m.inFlight != nil {
+		select {
+		case m.inFlight <- struct{}{}:
+			release = func() { <-m.inFlight }
+		default:
+			return nil, ErrTooManyRequests(100*time.Millisecond, "method:"+method, fmt.Sprintf("Concurrency limit of %d exceeded", m.rule.MaxConcurrent))
+		}
+	}
+	var taken *rate.Reservation
+	if m.limiter != nil {
+		r, delay := reserve(m.limiter, now)
+		if r == nil {
+			release()
+			return nil, ErrTooManyRequests(delay, "method:"+method, fmt.Sprintf("Rate limit of %g requests per second exceeded", m.rule.RPS))
+		}
+		taken = r
+	}
+	if m.rule.CallerRPS > 0 {
+		caller := callerKey(ctx)
+		if r, delay := reserve(st.caller(m, caller, now), now); r == nil {
+			if taken != nil {
+				taken.CancelAt(now)
+			}
+			release()
+			return nil, ErrTooManyRequests(delay, "caller:"+caller, fmt.Sprintf("Rate limit of %g requests per second per caller exceeded", m.rule.CallerRPS))
+		}
+	}
+	return release, nil
+}
+
+// reserve takes a token if one is available now and returns its reservation,
+// otherwise it returns nil and how long until the next token.
+func reserve(l *rate.Limiter, now time.Time) (*rate.Reservation, time.Duration) {
+	r := l.ReserveN(now, 1)
+	if !r.OK() {
+		return nil, time.Second
+	}
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return nil, delay
+	}
+	return r, 0
+}
+
+func (s *state) method(name string) *methodState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m, ok := s.methods[name]; ok {
+		return m
+	}
+
+	var m *methodState
+	for _, r := range s.rules {
+		if matches(r.Methods, name) {
+			m = &methodState{rule: r, callers: map[string]*callerBucket{}}
+			if r.RPS > 0 {
+				m.limiter = rate.NewLimiter(rate.Limit(r.RPS), max(r.Burst, 1))
+			}
+			if r.MaxConcurrent > 0 {
+				m.inFlight = s.slots.get(name, r.MaxConcurrent)
+			}
+			break
+		}
+	}
+	s.methods[name] = m
+	return m
+}
+
+func (s *state) caller(m *methodState, key string, now time.Time) *rate.Limiter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) > time.Minute {
+		for _, ms := range s.methods {
+			if ms == nil {
+				continue
+			}
+			for k, b := range ms.callers {
+				if now.Sub(b.lastSeen) > idleTimeout {
+					delete(ms.callers, k)
+				}
+			}
+		}
+		s.lastSweep = now
+	}
+
+	b, ok := m.callers[key]
+	if !ok {
+		b = &callerBucket{limiter: rate.NewLimiter(rate.Limit(m.rule.CallerRPS), max(m.rule.CallerBurst, 1))}
+		m.callers[key] = b
+	}
+	b.lastSeen = now
+	return b.limiter
+}
+
+func matches(patterns []string, method string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, method); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// callerKey identifies the caller by the customer of its authorized
+// principal, or else the principal itself. Anonymous callers are identified by
+// their peer address: the customer_id of a request is chosen by the caller, who
+// would get a fresh bucket by changing it.
+func callerKey(ctx context.Context) string {
+	if p, ok := authz.FromContext(ctx); ok && !p.Anonymous() {
+		if p.CustomerID != "" {
+			return "customer/" + p.CustomerID
+		}
+		return p.String()
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			return "addr/" + host
+		}
+		return "addr/" + p.Addr.String()
+	}
+	return "unknown"
+}
+
+// ErrTooManyRequests is the error of a rejected call, with the delay after
+// which a retry may succeed and the limit that was hit.
+func ErrTooManyRequests(retryAfter time.Duration, subject, description string) error {
+	return lib.ErrTooManyRequests().WithMessage(description).WithProtobufError(&pb.ErrTooManyRequests{
+		RetryInfo: &errdetails.RetryInfo{RetryDelay: durationpb.New(retryAfter)},
+		QuotaFailure: &errdetails.QuotaFailure{
+			Violations: []*errdetails.QuotaFailure_Violation{{Subject: subject, Description: description}},
+		},
+	})
+}
@@ -0,0 +1,149 @@
+package ratelimit
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"grpc-test/clock"
+	pb "grpc-test/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+)
+
+func TestBucketsFillOnTheClock(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	l := NewLimiter([]Rule{{Methods: []string{"/service.Charge/*"}, RPS: 1, Burst: 1}}, clk)
+	const method = "/service.Charge/ChargeCustomer"
+
+	if _, err := l.acquire(context.Background(), method); err != nil {
+		t.Fatalf("first call: %v", err)
+	}
+	if _, err := l.acquire(context.Background(), method); err == nil {
+		t.Fatal("second call within the second succeeded")
+	}
+	clk.Advance(time.Second)
+	if _, err := l.acquire(context.Background(), method); err != nil {
+		t.Errorf("call a second later on the clock: %v", err)
+	}
+}
+
+// from returns a context of a call from the anonymous peer at addr.
+func from(addr string) context.Context {
+	return peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(addr), Port: 40000}})
+}
+
+func TestCallersHaveTheirOwnBuckets(t *testing.T) {
+	l := NewLimiter([]Rule{{Methods: []string{"/service.Charge/*"}, CallerRPS: 1, CallerBurst: 1}}, clock.NewFake(time.Unix(0, 0)))
+	info := &grpc.UnaryServerInfo{FullMethod: "/service.Charge/ChargeCustomer"}
+	handler := func(context.Context, any) (any, error) { return nil, nil }
+	call := func(ctx context.Context, customer string) error {
+		_, err := l.UnaryServerInterceptor()(ctx, &pb.ChargeRequest{CustomerId: customer}, info, handler)
+		return err
+	}
+
+	if err := call(from("10.0.0.1"), "1"); err != nil {
+		t.Fatalf("first call of 10.0.0.1: %v", err)
+	}
+	// A request body cannot pick another bucket
+	if err := call(from("10.0.0.1"), "2"); err == nil {
+		t.Error("second call of 10.0.0.1 with another customer_id succeeded")
+	}
+	if err := call(from("10.0.0.2"), "1"); err != nil {
+		t.Errorf("first call of 10.0.0.2: %v", err)
+	}
+}
+
+func TestRejectedCallsKeepTheMethodBudget(t *testing.T) {
+	l := NewLimiter([]Rule{{Methods: []string{"/service.Charge/*"}, RPS: 1, Burst: 2, CallerRPS: 1, CallerBurst: 1}}, clock.NewFake(time.Unix(0, 0)))
+	const method = "/service.Charge/ChargeCustomer"
+	if _, err := l.acquire(from("10.0.0.1"), method); err != nil {
+		t.Fatal(err)
+	}
+	for range 5 {
+		if _, err := l.acquire(from("10.0.0.1"), method); err == nil {
+			t.Fatal("call over the caller limit succeeded")
+		}
+	}
+	if _, err := l.acquire(from("10.0.0.2"), method); err != nil {
+		t.Errorf("call of another caller = %v, want the method token the rejected calls gave back", err)
+	}
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	rules := []Rule{{Methods: []string{"/service.Charge/*"}, MaxConcurrent: 2}}
+	l := NewLimiter(rules, clock.NewFake(time.Unix(0, 0)))
+	const method = "/service.Charge/ChargeCustomer"
+	var releases []func()
+	for range 2 {
+		release, err := l.acquire(context.Background(), method)
+		if err != nil {
+			t.Fatal(err)
+		}
+		releases = append(releases, release)
+	}
+	if _, err := l.acquire(context.Background(), method); err == nil {
+		t.Fatal("call over the concurrency limit succeeded")
+	}
+
+	// Calls in flight still count after a reload with the same limit
+	l.Update(append([]Rule(nil), rules...))
+	if _, err := l.acquire(context.Background(), method); err == nil {
+		t.Error("call over the concurrency limit succeeded after a reload")
+	}
+	releases[0]()
+	release, err := l.acquire(context.Background(), method)
+	if err != nil {
+		t.Fatalf("call after a release: %v", err)
+	}
+	release()
+
+	// A new limit starts over
+	l.Update([]Rule{{Methods: []string{"/service.Charge/*"}, MaxConcurrent: 3}})
+	for range 3 {
+		if _, err := l.acquire(context.Background(), method); err != nil {
+			t.Fatalf("call within the new limit: %v", err)
+		}
+	}
+}
+
+func TestWatchFileReloadsOnTheClock(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "ratelimit.json")
+	if err := os.WriteFile(file, []byte(`{"rules": []}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	clk := clock.NewFake(time.Unix(0, 0))
+	l := NewLimiter(nil, clk)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		l.WatchFile(ctx, file, 2*time.Second)
+	}()
+	defer func() { cancel(); <-done }()
+
+	clk.BlockUntil(1)
+	if err := os.WriteFile(file, []byte(`{"rules": [{"methods": ["/service.Charge/*"], "rps": 1}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// The modification time may not move within the resolution of the file
+	// system otherwise
+	if err := os.Chtimes(file, time.Time{}, time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := len(l.Rules()); n != 0 {
+		t.Fatalf("rules = %d before the interval passed on the clock, want 0", n)
+	}
+
+	clk.Advance(2 * time.Second)
+	for deadline := time.Now().Add(5 * time.Second); len(l.Rules()) == 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("rules were not reloaded after the interval")
+		}
+	}
+}
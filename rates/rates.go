@@ -0,0 +1,139 @@
+// Package rates distributes exchange rates over a broker. The Currency
+// service publishes every rate to the subject of its pair, "rates.USD.EUR",
+// as the protojson encoding of pb.ExchangeRate. Consumers subscribe to
+// Subjects and keep the last rate of every pair in a Cache.
+package rates
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+
+	"grpc-test/broker"
+	"grpc-test/metrics"
+	pb "grpc-test/proto"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Subjects matches the subjects of every pair.
+const Subjects = "rates.>"
+
+// Subject returns the subject of the rates from one currency to another.
+func Subject(from, to string) string {
+	return "rates." + from + "." + to
+}
+
+// Retain keeps the last rate of every pair on b for new subscribers.
+func Retain(b broker.Broker) error {
+	return b.Retain(Subjects)
+}
+
+// Publish publishes rate to the subject of its pair.
+func Publish(b broker.Broker, rate *pb.ExchangeRate) error {
+	data, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(rate)
+	if err != nil {
+		return err
+	}
+	return b.Publish(Subject(rate.CurrencyFrom, rate.CurrencyTo), data)
+}
+
+// Decode decodes a message published by Publish.
+func Decode(m broker.Message) (*pb.ExchangeRate, error) {
+	rate := &pb.ExchangeRate{}
+	if err := protojson.Unmarshal(m.Data, rate); err != nil {
+		return nil, fmt.Errorf("decode exchange rate on %s: %w", m.Subject, err)
+	}
+	return rate, nil
+}
+
+// Cache keeps the last rate of every pair.
+type Cache struct {
+	mu       sync.RWMutex
+	rates    map[string]*pb.ExchangeRate
+	onUpdate []func(*pb.ExchangeRate)
+}
+
+func NewCache() *Cache {
+	return &Cache{rates: map[string]*pb.ExchangeRate{}}
+}
+
+// Put stores rate as the last of its pair.
+func (c *Cache) Put(rate *pb.ExchangeRate) {
+	c.mu.Lock()
+	c.rates[pair(rate.CurrencyFrom, rate.CurrencyTo)] = proto.Clone(rate).(*pb.ExchangeRate)
+	fns := slices.Clone(c.onUpdate)
+	c.mu.Unlock()
+	for _, fn := range fns {
+		fn(rate)
+	}
+}
+
+// Get returns the last rate from one currency to another.
+func (c *Cache) Get(from, to string) (*pb.ExchangeRate, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	rate, ok := c.rates[pair(from, to)]
+	if !ok {
+		return nil, false
+	}
+	return proto.Clone(rate).(*pb.ExchangeRate), true
+}
+
+// All returns the last rate of every pair, ordered by pair.
+func (c *Cache) All() []*pb.ExchangeRate {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	all := make([]*pb.ExchangeRate, 0, len(c.rates))
+	for _, rate := range c.rates {
+		all = append(all, proto.Clone(rate).(*pb.ExchangeRate))
+	}
+	slices.SortFunc(all, func(a, b *pb.ExchangeRate) int {
+		return strings.Compare(pair(a.CurrencyFrom, a.CurrencyTo), pair(b.CurrencyFrom, b.CurrencyTo))
+	})
+	return all
+}
+
+// Len returns the number of pairs with a rate.
+func (c *Cache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.rates)
+}
+
+// OnUpdate registers fn to run after every Put.
+func (c *Cache) OnUpdate(fn func(*pb.ExchangeRate)) {
+	c.mu.Lock()
+	c.onUpdate = append(c.onUpdate, fn)
+	c.mu.Unlock()
+}
+
+// Subscribe keeps the rates published on b in c, starting with the last rate
+// of every pair. Subscriptions sharing a non-empty group split the rates
+// between them.
+func Subscribe(b broker.Broker, group string, c *Cache) (broker.Subscription, error) {
+	if err := Retain(b); err != nil {
+		return nil, err
+	}
+	return b.Subscribe(Subjects, group, func(m broker.Message) {
+		rate, err := Decode(m)
+		if err != nil {
+			slog.Warn("Dropped exchange rate", slog.Any("error", err))
+			return
+		}
+		metrics.ExchangeRateReceived(rate.CurrencyFrom, rate.CurrencyTo)
+		slog.Debug("Received exchange rate",
+			slog.String("from", rate.CurrencyFrom),
+			slog.String("to", rate.CurrencyTo),
+			slog.Float64("rate", rate.Rate),
+		)
+		c.Put(rate)
+	})
+}
+
+func pair(from, to string) string {
+	return from + "/" + to
+}
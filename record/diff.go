@@ -0,0 +1,189 @@
+package record
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Ignore leaves a field out of comparisons, e.g. generated IDs and
+// timestamps.
+type Ignore struct {
+	// Method is a path.Match pattern of the methods it applies to, all when
+	// empty.
+	Method string
+	// Field is a dotted field path whose segments are path.Match patterns,
+	// e.g. "order_id" or "orders.*". It matches the end of the path of a
+	// value, so "timestamp" ignores that field at any depth.
+	Field string
+}
+
+// ParseIgnore parses "[method:]field", e.g. "/service.Order/*:order_id".
+func ParseIgnore(s string) (Ignore, error) {
+	var ig Ignore
+	if i := strings.LastIndex(s, ":"); i >= 0 {
+		ig.Method, s = s[:i], s[i+1:]
+		if _, err := path.Match(ig.Method, ""); err != nil {
+			return Ignore{}, fmt.Errorf("invalid method pattern %q: %w", ig.Method, err)
+		}
+	}
+	if s == "" {
+		return Ignore{}, fmt.Errorf("ignore without a field")
+	}
+	for _, seg := range strings.Split(s, ".") {
+		if _, err := path.Match(seg, ""); err != nil {
+			return Ignore{}, fmt.Errorf("invalid field pattern %q: %w", s, err)
+		}
+	}
+	ig.Field = s
+	return ig, nil
+}
+
+func (ig Ignore) String() string {
+	if ig.Method == "" {
+		return ig.Field
+	}
+	return ig.Method + ":" + ig.Field
+}
+
+// matches reports whether ig ignores the field at fieldPath of method.
+func (ig Ignore) matches(method string, fieldPath []string) bool {
+	if ig.Method != "" {
+		if ok, _ := path.Match(ig.Method, method); !ok {
+			return false
+		}
+	}
+	pattern := strings.Split(ig.Field, ".")
+	if len(pattern) > len(fieldPath) {
+		return false
+	}
+	tail := fieldPath[len(fieldPath)-len(pattern):]
+	for i, p := range pattern {
+		if ok, _ := path.Match(p, tail[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Difference is a value that differs between a recorded and a replayed call.
+// A nil value is missing from its call.
+type Difference struct {
+	Path string
+	Want any
+	Got  any
+}
+
+func (d Difference) String() string {
+	return fmt.Sprintf("%s: want %s, got %s", d.Path, show(d.Want), show(d.Got))
+}
+
+func show(v any) string {
+	if v == nil {
+		return "<missing>"
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
+
+// Diff compares the outcome of two calls: their response messages and their
+// status with its details. Metadata, timing and requests are not compared.
+// Paths look like "responses[0].order_id" or "status.details[1].reason".
+func Diff(want, got *Call, ignores []Ignore) []Difference {
+	d := differ{method: want.Method, ignores: ignores}
+	d.compare(nil, "", outcome(want), outcome(got))
+	return d.diffs
+}
+
+// outcome returns the compared parts of c as decoded JSON.
+func outcome(c *Call) map[string]any {
+	o := map[string]any{"responses": decodeAll(c.Responses)}
+	if c.Status != nil {
+		o["status"] = map[string]any{
+			"code":    c.Status.Code,
+			"message": c.Status.Message,
+			"details": decodeAll(c.Status.Details),
+		}
+	}
+	return o
+}
+
+func decodeAll(msgs []json.RawMessage) []any {
+	out := make([]any, len(msgs))
+	for i, m := range msgs {
+		dec := json.NewDecoder(bytes.NewReader(m))
+		dec.UseNumber()
+		if err := dec.Decode(&out[i]); err != nil {
+			out[i] = string(m)
+		}
+	}
+	return out
+}
+
+type differ struct {
+	method  string
+	ignores []Ignore
+	diffs   []Difference
+}
+
+// compare records where want and got differ. fields is the path without
+// indexes, matched by ignores, and display the path with them.
+func (d *differ) compare(fields []string, display string, want, got any) {
+	for _, ig := range d.ignores {
+		if len(fields) > 0 && ig.matches(d.method, fields) {
+			return
+		}
+	}
+
+	switch w := want.(type) {
+	case map[string]any:
+		g, ok := got.(map[string]any)
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(w)+len(g))
+		for k := range w {
+			keys = append(keys, k)
+		}
+		for k := range g {
+			if _, ok := w[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			p := k
+			if display != "" {
+				p = display + "." + k
+			}
+			d.compare(append(fields[:len(fields):len(fields)], k), p, w[k], g[k])
+		}
+		return
+	case []any:
+		g, ok := got.([]any)
+		if !ok {
+			break
+		}
+		for i := range max(len(w), len(g)) {
+			var wi, gi any
+			if i < len(w) {
+				wi = w[i]
+			}
+			if i < len(g) {
+				gi = g[i]
+			}
+			d.compare(fields, fmt.Sprintf("%s[%d]", display, i), wi, gi)
+		}
+		return
+	}
+	if !reflect.DeepEqual(want, got) {
+		d.diffs = append(d.diffs, Difference{Path: display, Want: want, Got: got})
+	}
+}
@@ -0,0 +1,105 @@
+package record
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Writer appends calls to a recording. It is safe for concurrent use.
+type Writer struct {
+	mu   sync.Mutex
+	file *os.File
+	gz   *gzip.Writer
+	enc  *json.Encoder
+	// closed drops the calls that end during shutdown, after Close.
+	closed bool
+}
+
+// Create creates or truncates the recording file, gzip-compressed when its
+// name ends in .gz.
+func Create(file string) (*Writer, error) {
+	f, err := os.Create(file)
+	if err != nil {
+		return nil, fmt.Errorf("create recording: %w", err)
+	}
+	w := &Writer{file: f}
+	var out io.Writer = f
+	if strings.HasSuffix(file, ".gz") {
+		w.gz = gzip.NewWriter(f)
+		out = w.gz
+	}
+	w.enc = json.NewEncoder(out)
+	return w, nil
+}
+
+// Write appends c. Compressed calls are flushed as they are written, so that
+// the recording can be read while the service runs and survives a crash.
+func (w *Writer) Write(c *Call) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	if err := w.enc.Encode(c); err != nil {
+		return err
+	}
+	if w.gz != nil {
+		return w.gz.Flush()
+	}
+	return nil
+}
+
+// Close closes the file.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	var err error
+	if w.gz != nil {
+		err = w.gz.Close()
+	}
+	return errors.Join(err, w.file.Close())
+}
+
+// Read returns the calls of a recording. A recording cut off mid-line, e.g.
+// because the service was killed, is read up to its last complete call.
+func Read(file string) ([]Call, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("open recording: %w", err)
+	}
+	defer f.Close()
+
+	var in io.Reader = f
+	if strings.HasSuffix(file, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, fmt.Errorf("read recording %s: %w", file, err)
+		}
+		defer gz.Close()
+		in = gz
+	}
+
+	var calls []Call
+	dec := json.NewDecoder(in)
+	for {
+		var c Call
+		err := dec.Decode(&c)
+		switch {
+		case err == nil:
+			calls = append(calls, c)
+		case errors.Is(err, io.EOF):
+			return calls, nil
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			return calls, nil
+		default:
+			return nil, fmt.Errorf("read recording %s: call %d: %w", file, len(calls)+1, err)
+		}
+	}
+}
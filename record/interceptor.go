@@ -0,0 +1,247 @@
+package record
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+
+	"grpc-test/clock"
+	"grpc-test/lib"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// Recorder writes the calls passing through its interceptors. The server
+// interceptors belong in front of go-lib's error interceptor and the client
+// ones last, so that both see the status that goes over the wire.
+type Recorder struct {
+	w           *Writer
+	methods     []string
+	maxMessages int
+	clock       clock.Clock
+}
+
+// NewRecorder returns a recorder writing to w.
+func NewRecorder(cfg Config, w *Writer, clk clock.Clock) *Recorder {
+	maxMessages := cfg.MaxMessages
+	if maxMessages <= 0 {
+		maxMessages = 100
+	}
+	return &Recorder{w: w, methods: cfg.Methods, maxMessages: maxMessages, clock: clk}
+}
+
+// UnaryServerInterceptor records unary calls received by the server.
+func (r *Recorder) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if !r.records(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		c := r.start(info.FullMethod, Server, false)
+		md, _ := metadata.FromIncomingContext(ctx)
+		c.Metadata = redact(md)
+		r.add(c, &c.Requests, req)
+		resp, err := handler(ctx, req)
+		if err == nil {
+			r.add(c, &c.Responses, resp)
+		}
+		r.finish(c, err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor records streams received by the server.
+func (r *Recorder) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !r.records(info.FullMethod) {
+			return handler(srv, ss)
+		}
+		c := r.start(info.FullMethod, Server, true)
+		md, _ := metadata.FromIncomingContext(ss.Context())
+		c.Metadata = redact(md)
+		rs := &serverStream{ServerStream: ss, r: r, c: c}
+		err := handler(srv, rs)
+		rs.mu.Lock()
+		defer rs.mu.Unlock()
+		// Handlers often return nil when the client goes away, the
+		// recording keeps that the stream was cut off.
+		recorded := err
+		if recorded == nil && ss.Context().Err() != nil {
+			recorded = status.FromContextError(ss.Context().Err()).Err()
+		}
+		r.finish(c, recorded)
+		return err
+	}
+}
+
+// UnaryClientInterceptor records outgoing unary calls.
+func (r *Recorder) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if !r.records(method) {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		c := r.start(method, Client, false)
+		md, _ := metadata.FromOutgoingContext(ctx)
+		c.Metadata = redact(md)
+		r.add(c, &c.Requests, req)
+		var header, trailer metadata.MD
+		err := invoker(ctx, method, req, reply, cc, append(opts, grpc.Header(&header), grpc.Trailer(&trailer))...)
+		if err == nil {
+			r.add(c, &c.Responses, reply)
+		}
+		c.Header, c.Trailer = redact(header), redact(trailer)
+		r.finish(c, err)
+		return err
+	}
+}
+
+// StreamClientInterceptor records outgoing streams. A stream is written when
+// it ends, or when its context is done if the caller stops receiving.
+func (r *Recorder) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		if !r.records(method) {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+		c := r.start(method, Client, true)
+		md, _ := metadata.FromOutgoingContext(ctx)
+		c.Metadata = redact(md)
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			r.finish(c, err)
+			return nil, err
+		}
+		rs := &clientStream{ClientStream: cs, r: r, c: c}
+		rs.stop = context.AfterFunc(ctx, func() { rs.end(status.FromContextError(ctx.Err()).Err(), false) })
+		return rs, nil
+	}
+}
+
+func (r *Recorder) records(method string) bool {
+	if len(r.methods) > 0 {
+		return matches(r.methods, method)
+	}
+	return !matches(Excluded, method)
+}
+
+func (r *Recorder) start(method, side string, stream bool) *Call {
+	return &Call{Method: method, Side: side, Stream: stream, Start: r.clock.Now().UTC()}
+}
+
+// add appends m to msgs, or counts it as dropped once the stream holds
+// maxMessages in that direction.
+func (r *Recorder) add(c *Call, msgs *[]json.RawMessage, m any) {
+	if len(*msgs) >= r.maxMessages {
+		c.Dropped++
+		return
+	}
+	pm, ok := m.(proto.Message)
+	if !ok {
+		return
+	}
+	data, err := Encode(pm)
+	if err != nil {
+		slog.Warn("Failed to record message", slog.String("method", c.Method), slog.Any("error", err))
+		return
+	}
+	*msgs = append(*msgs, data)
+}
+
+func (r *Recorder) finish(c *Call, err error) {
+	c.Duration = lib.Duration(r.clock.Since(c.Start))
+	c.Status = NewStatus(err)
+	if err := r.w.Write(c); err != nil {
+		slog.Error("Failed to record call", slog.String("method", c.Method), slog.Any("error", err))
+	}
+}
+
+// serverStream records the messages of a server stream. Handlers may send
+// and receive from different goroutines.
+type serverStream struct {
+	grpc.ServerStream
+	r  *Recorder
+	mu sync.Mutex
+	c  *Call
+}
+
+func (s *serverStream) RecvMsg(m any) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.mu.Lock()
+		s.r.add(s.c, &s.c.Requests, m)
+		s.mu.Unlock()
+	}
+	return err
+}
+
+func (s *serverStream) SendMsg(m any) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		s.mu.Lock()
+		s.r.add(s.c, &s.c.Responses, m)
+		s.mu.Unlock()
+	}
+	return err
+}
+
+// clientStream records the messages of a client stream and writes the call
+// once, when it ends.
+type clientStream struct {
+	grpc.ClientStream
+	r    *Recorder
+	mu   sync.Mutex
+	c    *Call
+	done bool
+	stop func() bool
+}
+
+func (s *clientStream) SendMsg(m any) error {
+	err := s.ClientStream.SendMsg(m)
+	if err == nil {
+		s.mu.Lock()
+		if !s.done {
+			s.r.add(s.c, &s.c.Requests, m)
+		}
+		s.mu.Unlock()
+	}
+	return err
+}
+
+func (s *clientStream) RecvMsg(m any) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil {
+		s.stop()
+		if errors.Is(err, io.EOF) {
+			s.end(nil, true)
+		} else {
+			s.end(err, true)
+		}
+		return err
+	}
+	s.mu.Lock()
+	if !s.done {
+		s.r.add(s.c, &s.c.Responses, m)
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// end writes the call unless it was already. The header and trailer are only
+// available once the stream has ended.
+func (s *clientStream) end(err error, ended bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done {
+		return
+	}
+	s.done = true
+	if ended {
+		header, _ := s.ClientStream.Header()
+		s.c.Header, s.c.Trailer = redact(header), redact(s.ClientStream.Trailer())
+	}
+	s.r.finish(s.c, err)
+}
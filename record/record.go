@@ -0,0 +1,161 @@
+// Package record captures gRPC traffic to a file so that it can be replayed
+// against another build and the responses compared. Client and server
+// interceptors write one Call per RPC with its metadata, request and response
+// messages, and its status including the details of AppErrors.
+//
+// Recordings are JSON Lines, gzip-compressed when the file name ends in .gz.
+// Messages are stored in the protojson form of google.protobuf.Any, so that
+// they stay readable and can be decoded without knowing the method.
+package record
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"time"
+
+	"grpc-test/lib"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// Config enables recording of the calls a service receives and, with Client,
+// of those it makes to its dependencies.
+type Config struct {
+	File   string `json:"file"`
+	Client bool   `json:"client"`
+	// Methods are path.Match patterns of the methods to record, all but
+	// Excluded when empty.
+	Methods []string `json:"methods"`
+	// MaxMessages caps the messages kept per stream direction, 100 by default.
+	MaxMessages int `json:"max_messages"`
+}
+
+// Active reports whether calls are recorded.
+func (c Config) Active() bool {
+	return c.File != ""
+}
+
+// Excluded are the methods not recorded unless named in Config.Methods.
+var Excluded = []string{"/grpc.health.v1.Health/*", "/grpc.reflection.*/*", "/grpc.channelz.v1.Channelz/*", "/service.FaultInjection/*"}
+
+// Redacted are the metadata keys whose values are not recorded.
+var Redacted = []string{"authorization", "cookie"}
+
+// Sides a call is recorded on.
+const (
+	Server = "server"
+	Client = "client"
+)
+
+// Call is a recorded RPC.
+type Call struct {
+	Method   string       `json:"method"`
+	Side     string       `json:"side"`
+	Stream   bool         `json:"stream,omitempty"`
+	Start    time.Time    `json:"start"`
+	Duration lib.Duration `json:"duration"`
+	// Metadata was sent with the request. Header and Trailer were received
+	// with the response, on the client side only.
+	Metadata metadata.MD `json:"metadata,omitempty"`
+	Header   metadata.MD `json:"header,omitempty"`
+	Trailer  metadata.MD `json:"trailer,omitempty"`
+	// Requests and Responses hold one message each for unary calls.
+	Requests  []json.RawMessage `json:"requests,omitempty"`
+	Responses []json.RawMessage `json:"responses,omitempty"`
+	// Dropped counts the stream messages beyond MaxMessages.
+	Dropped int     `json:"dropped,omitempty"`
+	Status  *Status `json:"status,omitempty"`
+}
+
+// Status is the status of a failed call.
+type Status struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Details []json.RawMessage `json:"details,omitempty"`
+}
+
+// NewStatus records the status of err, nil if err is nil.
+func NewStatus(err error) *Status {
+	if err == nil {
+		return nil
+	}
+	st := status.Convert(err)
+	s := &Status{Code: st.Code().String(), Message: st.Message()}
+	for _, detail := range st.Proto().GetDetails() {
+		s.Details = append(s.Details, encodeAny(detail))
+	}
+	return s
+}
+
+// marshal writes fields by their proto names, e.g. "order_id".
+var marshal = protojson.MarshalOptions{UseProtoNames: true}
+
+// Encode returns m in the protojson form of google.protobuf.Any.
+func Encode(m proto.Message) (json.RawMessage, error) {
+	a, err := anypb.New(m)
+	if err != nil {
+		return nil, err
+	}
+	return marshal.Marshal(a)
+}
+
+// Decode parses a message written by Encode. Its type must be registered,
+// e.g. by importing the generated package.
+func Decode(data json.RawMessage) (proto.Message, error) {
+	var a anypb.Any
+	if err := protojson.Unmarshal(data, &a); err != nil {
+		return nil, err
+	}
+	return a.UnmarshalNew()
+}
+
+// encodeAny encodes a status detail. Details of unknown types keep their
+// type only.
+func encodeAny(a *anypb.Any) json.RawMessage {
+	data, err := marshal.Marshal(a)
+	if err != nil {
+		data, _ = json.Marshal(map[string]string{"@type": a.GetTypeUrl()})
+	}
+	return data
+}
+
+// redact replaces the values of Redacted keys.
+func redact(md metadata.MD) metadata.MD {
+	if len(md) == 0 {
+		return nil
+	}
+	md = md.Copy()
+	for _, key := range Redacted {
+		if vs, ok := md[key]; ok {
+			md[key] = make([]string, len(vs))
+			for i := range vs {
+				md[key][i] = "REDACTED"
+			}
+		}
+	}
+	return md
+}
+
+// Validate checks the method patterns.
+func (c Config) Validate() error {
+	for _, m := range c.Methods {
+		if _, err := path.Match(m, ""); err != nil {
+			return fmt.Errorf("invalid method pattern %q: %w", m, err)
+		}
+	}
+	return nil
+}
+
+func matches(patterns []string, method string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, method); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"grpc-test/authz"
+	"grpc-test/lib"
+	"grpc-test/record"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// dropped are the metadata keys not sent again: those set by the transport,
+// redacted ones and those identifying the original request.
+var dropped = map[string]bool{
+	"content-type": true,
+	"user-agent":   true,
+	"te":           true,
+	"x-request-id": true,
+	"traceparent":  true,
+	"tracestate":   true,
+}
+
+// replay sends the requests of want and records what comes back.
+func replay(ctx context.Context, conn *grpc.ClientConn, cfg config, want *record.Call) (*record.Call, error) {
+	method, err := findMethod(want.Method)
+	if err != nil {
+		return nil, err
+	}
+	output, err := protoregistry.GlobalTypes.FindMessageByName(method.Output().FullName())
+	if err != nil {
+		return nil, fmt.Errorf("response type: %w", err)
+	}
+	reqs := make([]proto.Message, len(want.Requests))
+	for i, data := range want.Requests {
+		if reqs[i], err = record.Decode(data); err != nil {
+			return nil, fmt.Errorf("request %d: %w", i, err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.timeout)
+	defer cancel()
+	md := forwarded(want.Metadata)
+	ctx = metadata.NewOutgoingContext(ctx, md)
+	if cfg.token != "" {
+		ctx = authz.WithToken(ctx, cfg.token)
+	}
+
+	got := &record.Call{
+		Method:   want.Method,
+		Side:     want.Side,
+		Stream:   want.Stream,
+		Start:    time.Now().UTC(),
+		Metadata: md,
+		Requests: want.Requests,
+	}
+	if !method.IsStreamingClient() && !method.IsStreamingServer() {
+		if len(reqs) != 1 {
+			return nil, fmt.Errorf("recorded %d requests of a unary call", len(reqs))
+		}
+		resp := output.New().Interface()
+		err := conn.Invoke(ctx, want.Method, reqs[0], resp, grpc.Header(&got.Header), grpc.Trailer(&got.Trailer))
+		if err == nil {
+			if err := addResponse(got, resp); err != nil {
+				return nil, err
+			}
+		}
+		got.Status = record.NewStatus(err)
+	} else if err := replayStream(ctx, cancel, conn, method, output, reqs, want, got); err != nil {
+		return nil, err
+	}
+	got.Duration = lib.Duration(time.Since(got.Start))
+	return got, nil
+}
+
+// replayStream sends the requests of a stream and receives its responses.
+// A stream that the client cut off is received as far as it was recorded,
+// and then cut off with the recorded status.
+func replayStream(ctx context.Context, cancel context.CancelFunc, conn *grpc.ClientConn, method protoreflect.MethodDescriptor, output protoreflect.MessageType, reqs []proto.Message, want, got *record.Call) error {
+	desc := &grpc.StreamDesc{
+		StreamName:    string(method.Name()),
+		ServerStreams: method.IsStreamingServer(),
+		ClientStreams: method.IsStreamingClient(),
+	}
+	stream, err := conn.NewStream(ctx, desc, want.Method)
+	if err != nil {
+		got.Status = record.NewStatus(err)
+		return nil
+	}
+	// Send errors surface on RecvMsg.
+	for _, req := range reqs {
+		if stream.SendMsg(req) != nil {
+			break
+		}
+	}
+	stream.CloseSend()
+
+	cutOff := want.Dropped > 0 || want.Status != nil &&
+		(want.Status.Code == codes.Canceled.String() || want.Status.Code == codes.DeadlineExceeded.String())
+	for {
+		if cutOff && len(got.Responses) == len(want.Responses) {
+			cancel()
+			got.Status, got.Dropped = want.Status, want.Dropped
+			return nil
+		}
+		resp := output.New().Interface()
+		if err := stream.RecvMsg(resp); err != nil {
+			if !errors.Is(err, io.EOF) {
+				got.Status = record.NewStatus(err)
+			}
+			got.Trailer = stream.Trailer()
+			return nil
+		}
+		if err := addResponse(got, resp); err != nil {
+			return err
+		}
+	}
+}
+
+func addResponse(c *record.Call, resp proto.Message) error {
+	data, err := record.Encode(resp)
+	if err != nil {
+		return fmt.Errorf("response %d: %w", len(c.Responses), err)
+	}
+	c.Responses = append(c.Responses, data)
+	return nil
+}
+
+// findMethod looks up a method such as "/service.Order/PlaceOrder".
+func findMethod(name string) (protoreflect.MethodDescriptor, error) {
+	service, method, ok := strings.Cut(strings.TrimPrefix(name, "/"), "/")
+	if !ok {
+		return nil, fmt.Errorf("invalid method name %q", name)
+	}
+	d, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return nil, fmt.Errorf("unknown service %s", service)
+	}
+	sd, ok := d.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a service", service)
+	}
+	md := sd.Methods().ByName(protoreflect.Name(method))
+	if md == nil {
+		return nil, fmt.Errorf("unknown method %s", name)
+	}
+	return md, nil
+}
+
+// forwarded returns the recorded metadata to send again.
+func forwarded(recorded metadata.MD) metadata.MD {
+	md := metadata.MD{}
+	for key, values := range recorded {
+		if dropped[key] || strings.HasPrefix(key, ":") || strings.HasPrefix(key, "grpc-") || isRedacted(key) {
+			continue
+		}
+		md[key] = values
+	}
+	return md
+}
+
+func isRedacted(key string) bool {
+	for _, k := range record.Redacted {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,219 @@
+// Command replay re-sends the calls of a recording made with -record and
+// compares the responses and statuses with those recorded:
+//
+//	replay -addr localhost:50051 -ignore order_id traffic.jsonl.gz
+//	replay -target service.Charge=localhost:50052 -method '/service.Charge/*' traffic.jsonl
+//
+// It exits with 1 when a call differs, so that a recording of known good
+// traffic serves as a regression test of a new build.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	_ "grpc-test/proto" // registers the message types of the recording
+	"grpc-test/record"
+	"grpc-test/tlsconfig"
+
+	"google.golang.org/grpc"
+)
+
+type config struct {
+	addr    string
+	targets map[string]string
+	tls     tlsconfig.Config
+	token   string
+	timeout time.Duration
+	methods []string
+	side    string
+	ignores []record.Ignore
+	out     string
+	verbose bool
+}
+
+func main() {
+	cfg := config{targets: map[string]string{}}
+	flag.StringVar(&cfg.addr, "addr", "localhost:50051", "address calls are sent to, unless -target names their service")
+	flag.Func("target", "address of a service as service.Name=host:port, repeatable", func(v string) error {
+		service, addr, ok := strings.Cut(v, "=")
+		if !ok || service == "" || addr == "" {
+			return fmt.Errorf("expected service.Name=host:port, got %q", v)
+		}
+		cfg.targets[service] = addr
+		return nil
+	})
+	flag.StringVar(&cfg.tls.CAFile, "tls-ca", "", "PEM CA bundle to verify the services, enables TLS")
+	flag.StringVar(&cfg.tls.CertFile, "tls-cert", "", "PEM client certificate for mTLS")
+	flag.StringVar(&cfg.tls.KeyFile, "tls-key", "", "PEM client private key for mTLS")
+	flag.StringVar(&cfg.token, "token", os.Getenv("GRPCTEST_TOKEN"), "bearer token, defaults to $GRPCTEST_TOKEN")
+	flag.DurationVar(&cfg.timeout, "timeout", 10*time.Second, "deadline of each call, streams included")
+	flag.Func("method", "replay only the methods matching this pattern, e.g. '/service.Order/*', repeatable", func(v string) error {
+		if _, err := path.Match(v, ""); err != nil {
+			return err
+		}
+		cfg.methods = append(cfg.methods, v)
+		return nil
+	})
+	flag.StringVar(&cfg.side, "side", "", "replay only the calls recorded on this side: server or client")
+	flag.Func("ignore", "field not compared as [method:]field.path, e.g. order_id or '/service.Order/*:message', repeatable", func(v string) error {
+		ig, err := record.ParseIgnore(v)
+		if err != nil {
+			return err
+		}
+		cfg.ignores = append(cfg.ignores, ig)
+		return nil
+	})
+	flag.StringVar(&cfg.out, "out", "", "record the replayed calls to this file, e.g. as the next golden recording")
+	flag.BoolVar(&cfg.verbose, "v", false, "also list the calls that match")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: replay [flags] <recording>\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if cfg.side != "" && cfg.side != record.Server && cfg.side != record.Client {
+		log.Fatalf("invalid -side %q, want %q or %q", cfg.side, record.Server, record.Client)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	differed, err := run(ctx, cfg, flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if differed {
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, cfg config, file string) (differed bool, err error) {
+	calls, err := record.Read(file)
+	if err != nil {
+		return false, err
+	}
+	calls = selectCalls(calls, cfg)
+	// Calls are written as they end, replay them in the order they started.
+	sort.SliceStable(calls, func(i, j int) bool { return calls[i].Start.Before(calls[j].Start) })
+
+	var out *record.Writer
+	if cfg.out != "" {
+		if out, err = record.Create(cfg.out); err != nil {
+			return false, err
+		}
+		defer out.Close()
+	}
+
+	creds, err := tlsconfig.ClientCredentials(cfg.tls)
+	if err != nil {
+		return false, err
+	}
+	conns := map[string]*grpc.ClientConn{}
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	dial := func(addr string) (*grpc.ClientConn, error) {
+		if conn, ok := conns[addr]; ok {
+			return conn, nil
+		}
+		conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(creds))
+		if err != nil {
+			return nil, err
+		}
+		conns[addr] = conn
+		return conn, nil
+	}
+
+	var matched, failed, errored int
+	for i := range calls {
+		want := &calls[i]
+		var diffs []record.Difference
+		conn, err := dial(cfg.target(want.Method))
+		if err == nil {
+			diffs, err = check(ctx, conn, cfg, want, out)
+		}
+		switch {
+		case err != nil && ctx.Err() != nil:
+			return false, ctx.Err()
+		case err != nil:
+			errored++
+			fmt.Printf("ERR  #%d %s: %v\n", i+1, want.Method, err)
+		case len(diffs) > 0:
+			failed++
+			fmt.Printf("FAIL #%d %s\n", i+1, want.Method)
+			for _, d := range diffs {
+				fmt.Printf("    %s\n", d)
+			}
+		default:
+			matched++
+			if cfg.verbose {
+				fmt.Printf("ok   #%d %s\n", i+1, want.Method)
+			}
+		}
+	}
+	fmt.Printf("\n%d calls: %d matched, %d differed, %d could not be replayed\n", len(calls), matched, failed, errored)
+	return failed+errored > 0, nil
+}
+
+// check replays want, writes the result to out if set and returns how it
+// differs from the recording.
+func check(ctx context.Context, conn *grpc.ClientConn, cfg config, want *record.Call, out *record.Writer) ([]record.Difference, error) {
+	got, err := replay(ctx, conn, cfg, want)
+	if err != nil {
+		return nil, err
+	}
+	if out != nil {
+		if err := out.Write(got); err != nil {
+			return nil, err
+		}
+	}
+	return record.Diff(want, got, cfg.ignores), nil
+}
+
+// selectCalls returns the calls chosen by -method and -side.
+func selectCalls(calls []record.Call, cfg config) []record.Call {
+	var selected []record.Call
+	for _, c := range calls {
+		if cfg.side != "" && c.Side != cfg.side {
+			continue
+		}
+		if len(cfg.methods) > 0 && !matchesAny(cfg.methods, c.Method) {
+			continue
+		}
+		selected = append(selected, c)
+	}
+	return selected
+}
+
+func matchesAny(patterns []string, method string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, method); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// target returns the address of the service of method.
+func (c config) target(method string) string {
+	service, _, _ := strings.Cut(strings.TrimPrefix(method, "/"), "/")
+	if addr, ok := c.targets[service]; ok {
+		return addr
+	}
+	return c.addr
+}
@@ -0,0 +1,131 @@
+package resilience
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"grpc-test/clock"
+	"grpc-test/lib"
+	"grpc-test/metrics"
+)
+
+// BreakerPolicy opens the circuit after FailureThreshold consecutive failures.
+// Calls then fail fast with ServiceUnavailable until OpenTimeout has passed,
+// after which up to HalfOpenProbes calls are let through: if they all succeed
+// the circuit closes, any failure opens it again.
+type BreakerPolicy struct {
+	// FailureThreshold of zero disables the breaker.
+	FailureThreshold int          `json:"failure_threshold"`
+	OpenTimeout      lib.Duration `json:"open_timeout"`
+	HalfOpenProbes   int          `json:"half_open_probes"`
+}
+
+type state int
+
+const (
+	closed state = iota
+	halfOpen
+	open
+)
+
+func (s state) String() string {
+	switch s {
+	case closed:
+		return "closed"
+	case halfOpen:
+		return "half-open"
+	}
+	return "open"
+}
+
+type breaker struct {
+	target string
+	policy BreakerPolicy
+	clock  clock.Clock
+
+	mu        sync.Mutex
+	state     state
+	failures  int
+	openedAt  time.Time
+	probes    int
+	successes int
+}
+
+func newBreaker(target string, policy BreakerPolicy, clk clock.Clock) *breaker {
+	policy.HalfOpenProbes = max(policy.HalfOpenProbes, 1)
+	b := &breaker{target: target, policy: policy, clock: clk}
+	metrics.BreakerState(target, int(closed))
+	return b
+}
+
+// allow reports whether a call may go through.
+func (b *breaker) allow() error {
+	if b.policy.FailureThreshold <= 0 {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == open {
+		if b.clock.Since(b.openedAt) < time.Duration(b.policy.OpenTimeout) {
+			return b.unavailable()
+		}
+		b.transition(halfOpen)
+	}
+	if b.state == halfOpen {
+		if b.probes >= b.policy.HalfOpenProbes {
+			return b.unavailable()
+		}
+		b.probes++
+	}
+	return nil
+}
+
+func (b *breaker) record(failure bool) {
+	if b.policy.FailureThreshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case closed:
+		if !failure {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= b.policy.FailureThreshold {
+			b.transition(open)
+		}
+	case halfOpen:
+		if failure {
+			b.transition(open)
+			return
+		}
+		b.successes++
+		if b.successes >= b.policy.HalfOpenProbes {
+			b.transition(closed)
+		}
+	}
+}
+
+func (b *breaker) transition(to state) {
+	from := b.state
+	b.state = to
+	b.failures, b.probes, b.successes = 0, 0, 0
+	if to == open {
+		b.openedAt = b.clock.Now()
+	}
+	metrics.BreakerTransition(b.target, from.String(), to.String(), int(to))
+	slog.Warn("Circuit breaker changed state",
+		slog.String("target", b.target),
+		slog.String("from", from.String()),
+		slog.String("to", to.String()),
+	)
+}
+
+func (b *breaker) unavailable() error {
+	return lib.ErrServiceUnavailable().WithMessage("Circuit breaker for " + b.target + " is " + b.state.String())
+}
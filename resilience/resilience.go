@@ -0,0 +1,188 @@
+// Package resilience protects outgoing unary calls with retries, hedging and a
+// circuit breaker per downstream target. Errors are classified by their
+// AppError detail type or name, so that e.g. ErrGatewayNotReachable is retried
+// while ErrNotEnoughCharge never is.
+//
+// The interceptor must wrap go-lib's client error interceptor so that it sees
+// AppErrors rather than raw statuses.
+package resilience
+
+import (
+	"context"
+	"path"
+	"slices"
+	"sync"
+	"time"
+
+	"grpc-test/clock"
+	"grpc-test/lib"
+	"grpc-test/random"
+
+	"github.com/revotech-group/go-lib/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Config holds the policies applied to every method of a downstream.
+type Config struct {
+	Retry   RetryPolicy   `json:"retry"`
+	Hedging HedgingPolicy `json:"hedging"`
+	Breaker BreakerPolicy `json:"breaker"`
+}
+
+// RetryPolicy retries failed calls with exponential backoff, as long as the
+// next attempt can start before the caller's deadline.
+type RetryPolicy struct {
+	// MaxAttempts includes the first call, values below 2 disable retries.
+	MaxAttempts    int          `json:"max_attempts"`
+	InitialBackoff lib.Duration `json:"initial_backoff"`
+	MaxBackoff     lib.Duration `json:"max_backoff"`
+	Multiplier     float64      `json:"multiplier"`
+	// RetryOn lists the error detail types, AppError names or gRPC codes that
+	// are retried, e.g. "ErrGatewayNotReachable" or "Unavailable".
+	RetryOn []string `json:"retry_on"`
+	// NeverRetry takes precedence over RetryOn.
+	NeverRetry []string `json:"never_retry"`
+}
+
+// HedgingPolicy sends additional copies of a call when the previous one has
+// not answered within Delay, and returns the first success. Only list
+// idempotent methods: every copy reaches the server.
+type HedgingPolicy struct {
+	// Methods are path.Match patterns of the hedged methods.
+	Methods     []string     `json:"methods"`
+	MaxAttempts int          `json:"max_attempts"`
+	Delay       lib.Duration `json:"delay"`
+}
+
+// Interceptor applies a Config to the calls of one or more connections.
+type Interceptor struct {
+	cfg   Config
+	clock clock.Clock
+	rand  random.Rand
+
+	mu       sync.Mutex
+	breakers map[string]*breaker
+}
+
+// Option customizes an Interceptor.
+type Option func(*Interceptor)
+
+// WithClock replaces the system clock used for backoffs and the breaker.
+func WithClock(c clock.Clock) Option {
+	return func(i *Interceptor) { i.clock = c }
+}
+
+// WithRand replaces the source of the backoff jitter.
+func WithRand(r random.Rand) Option {
+	return func(i *Interceptor) { i.rand = r }
+}
+
+func New(cfg Config, opts ...Option) *Interceptor {
+	i := &Interceptor{cfg: cfg, clock: clock.Real, rand: random.New(random.NewSeed()), breakers: map[string]*breaker{}}
+	for _, opt := range opts {
+		opt(i)
+	}
+	return i
+}
+
+// Unary returns the client interceptor.
+func (i *Interceptor) Unary() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		b := i.breaker(cc.Target())
+		call := func(ctx context.Context, reply any) error {
+			if err := b.allow(); err != nil {
+				return err
+			}
+			err := invoker(ctx, method, req, reply, cc, opts...)
+			b.record(i.isFailure(ctx, err))
+			return err
+		}
+
+		if i.hedged(method) {
+			return i.hedge(ctx, method, reply, call)
+		}
+		return i.retry(ctx, method, reply, call)
+	}
+}
+
+func (i *Interceptor) breaker(target string) *breaker {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	b, ok := i.breakers[target]
+	if !ok {
+		b = newBreaker(target, i.cfg.Breaker, i.clock)
+		i.breakers[target] = b
+	}
+	return b
+}
+
+func (i *Interceptor) hedged(method string) bool {
+	if i.cfg.Hedging.MaxAttempts < 2 {
+		return false
+	}
+	return slices.ContainsFunc(i.cfg.Hedging.Methods, func(p string) bool {
+		ok, _ := path.Match(p, method)
+		return ok
+	})
+}
+
+// isFailure reports whether err says the downstream is unhealthy, as opposed
+// to a business error or the caller giving up.
+func (i *Interceptor) isFailure(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() == context.Canceled {
+		return false
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return true
+	}
+	// Unavailable counts whether retried or not
+	if status.Code(err) == codes.Unavailable {
+		return true
+	}
+	_, retryable := i.cfg.Retry.classify(err)
+	return retryable
+}
+
+// reason names an error by its detail type, AppError name or gRPC code, in
+// that order of preference.
+func reason(err error) string {
+	if appErr, ok := err.(errors.AppError); ok {
+		if detail := appErr.GetProtobufError(); detail != nil {
+			return string(detail.ProtoReflect().Descriptor().Name())
+		}
+	}
+	if name := lib.NameOf(err); name != "" {
+		return name
+	}
+	return status.Code(err).String()
+}
+
+// classify returns the reason of err and whether it may be retried.
+func (p RetryPolicy) classify(err error) (string, bool) {
+	r := reason(err)
+	names := []string{r, lib.NameOf(err), status.Code(err).String()}
+	for _, n := range names {
+		if n != "" && slices.Contains(p.NeverRetry, n) {
+			return r, false
+		}
+	}
+	for _, n := range names {
+		if n != "" && slices.Contains(p.RetryOn, n) {
+			return r, true
+		}
+	}
+	return r, false
+}
+
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := float64(p.InitialBackoff)
+	for range attempt - 1 {
+		d *= max(p.Multiplier, 1)
+	}
+	if p.MaxBackoff > 0 {
+		d = min(d, float64(p.MaxBackoff))
+	}
+	return time.Duration(d)
+}
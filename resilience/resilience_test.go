@@ -0,0 +1,149 @@
+package resilience
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"grpc-test/clock"
+	"grpc-test/domain"
+	"grpc-test/lib"
+	"grpc-test/random"
+	"grpc-test/ratelimit"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestClassify(t *testing.T) {
+	p := RetryPolicy{
+		RetryOn:    []string{"ErrGatewayNotReachable", "Unavailable", lib.NameBadRequest},
+		NeverRetry: []string{"ErrNotEnoughCharge"},
+	}
+	tests := []struct {
+		name       string
+		err        error
+		wantReason string
+		wantRetry  bool
+	}{
+		{"detail type", domain.ErrGatewayNotReachable(), "ErrGatewayNotReachable", true},
+		{"gRPC code", status.Error(codes.Unavailable, "connection reset"), "Unavailable", true},
+		{"not listed", status.Error(codes.Internal, "boom"), "Internal", false},
+		// ErrNotEnoughCharge is a BadRequestError, which RetryOn lists
+		{"NeverRetry beats RetryOn", domain.ErrNotEnoughCredit(), "ErrNotEnoughCharge", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason, retry := p.classify(tt.err)
+			if reason != tt.wantReason || retry != tt.wantRetry {
+				t.Errorf("classify() = %q, %v, want %q, %v", reason, retry, tt.wantReason, tt.wantRetry)
+			}
+		})
+	}
+}
+
+// newTestInterceptor returns an interceptor on a fake clock that retries
+// ErrTooManyRequests.
+func newTestInterceptor(clk clock.Clock, breaker BreakerPolicy) *Interceptor {
+	return New(Config{
+		Retry: RetryPolicy{
+			MaxAttempts:    3,
+			InitialBackoff: lib.Duration(time.Millisecond),
+			MaxBackoff:     lib.Duration(time.Millisecond),
+			Multiplier:     2,
+			RetryOn:        []string{"ErrTooManyRequests"},
+		},
+		Breaker: breaker,
+	}, WithClock(clk), WithRand(random.New(1)))
+}
+
+func TestRetryWaitsForRetryInfo(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	i := newTestInterceptor(clk, BreakerPolicy{})
+	calls := 0
+	call := func(context.Context, any) error {
+		calls++
+		if calls == 1 {
+			return ratelimit.ErrTooManyRequests(2*time.Second, "method:/service.Charge/ChargeCustomer", "limited")
+		}
+		return nil
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- i.retry(context.Background(), "/service.Charge/ChargeCustomer", nil, call) }()
+	clk.BlockUntil(1)
+	clk.Advance(time.Second)
+	select {
+	case <-done:
+		t.Fatal("retried before the delay the server asked for")
+	case <-time.After(50 * time.Millisecond):
+	}
+	clk.Advance(time.Second)
+	if err := <-done; err != nil {
+		t.Errorf("retry() = %v, want nil", err)
+	}
+	if calls != 2 {
+		t.Errorf("calls = %d, want 2", calls)
+	}
+}
+
+func TestRetryStopsAtDeadline(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	i := newTestInterceptor(clk, BreakerPolicy{})
+	ctx, cancel := context.WithDeadline(context.Background(), clk.Now().Add(time.Second))
+	defer cancel()
+	calls := 0
+	call := func(context.Context, any) error {
+		calls++
+		return ratelimit.ErrTooManyRequests(2*time.Second, "method:/service.Charge/ChargeCustomer", "limited")
+	}
+	if err := i.retry(ctx, "/service.Charge/ChargeCustomer", nil, call); lib.NameOf(err) != lib.NameTooManyRequests {
+		t.Errorf("retry() = %v, want the TooManyRequests of the call", err)
+	}
+	if calls != 1 {
+		t.Errorf("calls = %d, want 1 as the retry could not start before the deadline", calls)
+	}
+}
+
+func TestBreakerTransitions(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	b := newBreaker("payment", BreakerPolicy{FailureThreshold: 2, OpenTimeout: lib.Duration(10 * time.Second), HalfOpenProbes: 1}, clk)
+	step := func(want state) {
+		t.Helper()
+		if b.state != want {
+			t.Fatalf("state = %v, want %v", b.state, want)
+		}
+	}
+
+	// A success resets the count of consecutive failures
+	b.record(true)
+	b.record(false)
+	b.record(true)
+	step(closed)
+	b.record(true)
+	step(open)
+	if err := b.allow(); lib.NameOf(err) != lib.NameServiceUnavailable {
+		t.Errorf("allow() while open = %v, want ServiceUnavailable", err)
+	}
+
+	clk.Advance(10 * time.Second)
+	if err := b.allow(); err != nil {
+		t.Fatalf("allow() of the probe = %v, want nil", err)
+	}
+	step(halfOpen)
+	if err := b.allow(); err == nil {
+		t.Error("allow() beyond the probes succeeded")
+	}
+	b.record(true)
+	step(open)
+
+	clk.Advance(10 * time.Second)
+	if err := b.allow(); err != nil {
+		t.Fatalf("allow() of the probe = %v, want nil", err)
+	}
+	b.record(false)
+	step(closed)
+	if err := b.allow(); err != nil {
+		t.Errorf("allow() once closed = %v, want nil", err)
+	}
+}
@@ -0,0 +1,124 @@
+package resilience
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"grpc-test/logging"
+	"grpc-test/metrics"
+	pb "grpc-test/proto"
+
+	"github.com/revotech-group/go-lib/errors"
+	"google.golang.org/protobuf/proto"
+)
+
+type attemptFunc func(ctx context.Context, reply any) error
+
+func (i *Interceptor) retry(ctx context.Context, method string, reply any, call attemptFunc) error {
+	p := i.cfg.Retry
+	for attempt := 1; ; attempt++ {
+		err := call(ctx, reply)
+		if err == nil || attempt >= p.MaxAttempts {
+			return err
+		}
+		r, retryable := p.classify(err)
+		if !retryable {
+			return err
+		}
+
+		// Full jitter, but never retry sooner than the server asked for
+		wait := time.Duration(i.rand.Int64N(int64(p.backoff(attempt)) + 1))
+		wait = max(wait, serverDelay(err))
+		if deadline, ok := ctx.Deadline(); ok && deadline.Sub(i.clock.Now()) <= wait {
+			return err
+		}
+
+		metrics.ClientAttempt(method, "retry", r)
+		logging.FromContext(ctx).Warn("Retrying call",
+			slog.String("target_method", method),
+			slog.Int("attempt", attempt+1),
+			slog.String("reason", r),
+			slog.Duration("backoff", wait),
+		)
+
+		timer := i.clock.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C():
+		}
+	}
+}
+
+// serverDelay returns the retry delay requested by a TooManyRequests error.
+func serverDelay(err error) time.Duration {
+	if appErr, ok := err.(errors.AppError); ok {
+		if detail, ok := appErr.GetProtobufError().(*pb.ErrTooManyRequests); ok {
+			return detail.GetRetryInfo().GetRetryDelay().AsDuration()
+		}
+	}
+	return 0
+}
+
+func (i *Interceptor) hedge(ctx context.Context, method string, reply any, call attemptFunc) error {
+	p := i.cfg.Hedging
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		reply proto.Message
+		err   error
+	}
+	results := make(chan result, p.MaxAttempts)
+	launch := func() {
+		r := reply.(proto.Message).ProtoReflect().New().Interface()
+		go func() {
+			results <- result{reply: r, err: call(ctx, r)}
+		}()
+	}
+
+	launch()
+	launched, pending := 1, 1
+	timer := i.clock.NewTimer(time.Duration(p.Delay))
+	defer timer.Stop()
+
+	var lastErr error
+	for {
+		select {
+		case <-timer.C():
+			if launched < p.MaxAttempts {
+				metrics.ClientAttempt(method, "hedge", "delay")
+				launch()
+				launched++
+				pending++
+				timer.Reset(time.Duration(p.Delay))
+			}
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				proto.Merge(reply.(proto.Message), res.reply)
+				return nil
+			}
+			lastErr = res.err
+			if _, retryable := i.cfg.Retry.classify(res.err); !retryable {
+				return res.err
+			}
+			// Send the next copy right away instead of waiting for the delay
+			if launched < p.MaxAttempts {
+				metrics.ClientAttempt(method, "hedge", reason(res.err))
+				launch()
+				launched++
+				pending++
+			} else if pending == 0 {
+				return lastErr
+			}
+		case <-ctx.Done():
+			if lastErr != nil {
+				return lastErr
+			}
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package server
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"grpc-test/admin"
+	"grpc-test/audit"
+	"grpc-test/authz"
+	"grpc-test/broker"
+	"grpc-test/deadline"
+	"grpc-test/diagnostics"
+	"grpc-test/discovery"
+	"grpc-test/faults"
+	"grpc-test/lib"
+	"grpc-test/metrics"
+	"grpc-test/ratelimit"
+	"grpc-test/record"
+	"grpc-test/resilience"
+	"grpc-test/tlsconfig"
+	"grpc-test/tracing"
+	"grpc-test/web"
+	"grpc-test/webhook"
+)
+
+// Config holds the settings shared by every service. Values are resolved in
+// order: defaults, config file (JSON), environment variables, then flags.
+type Config struct {
+	Name            string             `json:"-"`
+	File            string             `json:"-"`
+	Addr            string             `json:"addr"`
+	LogLevel        slog.Level         `json:"log_level"`
+	Debug           bool               `json:"debug"`
+	ShutdownTimeout lib.Duration       `json:"shutdown_timeout"`
+	Seed            int64              `json:"seed"`
+	Dependencies    map[string]string  `json:"dependencies"`
+	Tracing         tracing.Config     `json:"tracing"`
+	Metrics         metrics.Config     `json:"metrics"`
+	TLS             tlsconfig.Config   `json:"tls"`
+	Authz           authz.Config       `json:"authz"`
+	RateLimit       ratelimit.Config   `json:"rate_limit"`
+	Resilience      resilience.Config  `json:"resilience"`
+	Deadline        deadline.Config    `json:"deadline"`
+	Discovery       discovery.Config   `json:"discovery"`
+	Web             web.Config         `json:"web"`
+	Faults          faults.Config      `json:"faults"`
+	Record          record.Config      `json:"record"`
+	Admin           admin.Config       `json:"admin"`
+	Diagnostics     diagnostics.Config `json:"diagnostics"`
+	Audit           audit.Config       `json:"audit"`
+	Webhooks        webhook.Config     `json:"webhooks"`
+	Broker          broker.Config      `json:"broker"`
+
+	// LogLevels overrides LogLevel by package, e.g. {"orderservice": "DEBUG"}.
+	LogLevels map[string]slog.Level `json:"log_levels"`
+	// Features overrides the defaults of feature flags by name.
+	Features map[string]bool `json:"features"`
+}
+
+// Dependency returns the address of a downstream service.
+func (c Config) Dependency(name string) string {
+	return c.Dependencies[name]
+}
+
+// Load resolves the configuration of the named service. Every flag can also be
+// set through an environment variable, e.g. -log-level for "order" is
+// ORDER_LOG_LEVEL, and -config points to an optional JSON file. Invalid
+// flags are reported on standard error and returned, and -help returns
+// flag.ErrHelp after printing the usage.
+func Load(name string, args []string, defaults Config) (Config, error) {
+	defaults.Name = name
+	if defaults.ShutdownTimeout == 0 {
+		defaults.ShutdownTimeout = lib.Duration(10 * time.Second)
+	}
+	if defaults.Discovery.Balancer == "" {
+		defaults.Discovery.Balancer = "round_robin"
+	}
+
+	cfg := defaults.clone()
+	fs := cfg.flagSet()
+
+	// The first pass only discovers the config file, env and flags are
+	// applied again on top of it below.
+	if err := parse(fs, name, args); err != nil {
+		return Config{}, err
+	}
+	if cfg.File == "" {
+		return cfg, nil
+	}
+
+	file := cfg.File
+	cfg = defaults.clone()
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return Config{}, fmt.Errorf("read config file: %w", err)
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return Config{}, fmt.Errorf("parse config file %s: %w", file, err)
+	}
+	if err := parse(fs, name, args); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+func (c Config) clone() Config {
+	c.TLS.AllowedPeers = maps.Clone(c.TLS.AllowedPeers)
+	c.Dependencies = maps.Clone(c.Dependencies)
+	c.LogLevels = maps.Clone(c.LogLevels)
+	c.Features = maps.Clone(c.Features)
+	c.Web.AllowedOrigins = slices.Clone(c.Web.AllowedOrigins)
+	c.Faults.Rules = slices.Clone(c.Faults.Rules)
+	c.Record.Methods = slices.Clone(c.Record.Methods)
+	if c.Dependencies == nil {
+		c.Dependencies = map[string]string{}
+	}
+	return c
+}
+
+func (c *Config) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet(c.Name, flag.ContinueOnError)
+	fs.StringVar(&c.File, "config", c.File, "path to a JSON config file")
+	fs.StringVar(&c.Addr, "addr", c.Addr, "address to listen on")
+	fs.TextVar(&c.LogLevel, "log-level", c.LogLevel, "log level (DEBUG, INFO, WARN, ERROR)")
+	fs.Var((*logLevels)(&c.LogLevels), "package-log-level", "log level of a package as package=LEVEL, repeatable")
+	fs.BoolVar(&c.Debug, "debug", c.Debug, "log stack traces of recovered panics and app errors")
+	fs.TextVar(&c.ShutdownTimeout, "shutdown-timeout", c.ShutdownTimeout, "time to drain in-flight RPCs before forcing stop")
+	fs.Int64Var(&c.Seed, "seed", c.Seed, "seed of every random choice, to replay a run; 0 picks and logs one")
+	fs.Var((*dependencies)(&c.Dependencies), "dep", "downstream target as name=host:port, static:///a,b, dns:///host:port or registry:///service, repeatable")
+	fs.BoolVar(&c.Tracing.Stdout, "trace-stdout", c.Tracing.Stdout, "print finished spans to stdout")
+	fs.StringVar(&c.Tracing.File, "trace-file", c.Tracing.File, "append spans as OTLP/JSON lines to this file")
+	fs.Func("trace-sample-ratio", "fraction of new traces to record, from 0 to 1 (default 1)", func(v string) error {
+		ratio, err := strconv.ParseFloat(v, 64)
+		if err != nil || ratio < 0 || ratio > 1 {
+			return fmt.Errorf("%q is not a number from 0 to 1", v)
+		}
+		c.Tracing.SampleRatio = &ratio
+		return nil
+	})
+	fs.StringVar(&c.Metrics.Addr, "metrics-addr", c.Metrics.Addr, "address of the /metrics HTTP listener, empty to disable")
+	fs.StringVar(&c.TLS.CertFile, "tls-cert", c.TLS.CertFile, "PEM certificate of this service, enables TLS")
+	fs.StringVar(&c.TLS.KeyFile, "tls-key", c.TLS.KeyFile, "PEM private key of this service")
+	fs.StringVar(&c.TLS.CAFile, "tls-ca", c.TLS.CAFile, "PEM CA bundle used to verify peers")
+	fs.BoolVar(&c.TLS.ClientAuth, "tls-client-auth", c.TLS.ClientAuth, "require client certificates (mTLS)")
+	fs.StringVar(&c.Authz.PolicyFile, "authz-policy", c.Authz.PolicyFile, "JSON authorization policy, enables per-method authorization")
+	fs.StringVar(&c.RateLimit.File, "ratelimit-file", c.RateLimit.File, "JSON rate limit rules, reloaded when the file changes")
+	fs.StringVar(&c.Discovery.Registry, "registry", c.Discovery.Registry, "JSON service registry file to register in and resolve registry:/// targets from")
+	fs.StringVar(&c.Discovery.Advertise, "advertise", c.Discovery.Advertise, "address registered for this service, defaults to hostname and listen port")
+	fs.StringVar(&c.Discovery.Balancer, "balancer", c.Discovery.Balancer, "balancing across downstream instances: round_robin, least_request or pick_first")
+	fs.BoolVar(&c.Web.Enabled, "web", c.Web.Enabled, "also serve Connect and gRPC-Web on the gRPC port")
+	fs.Func("cors-origin", "origin allowed to call from a browser, * for any, repeatable", func(origin string) error {
+		c.Web.AllowedOrigins = append(c.Web.AllowedOrigins, origin)
+		return nil
+	})
+	fs.BoolVar(&c.Faults.Enabled, "faults", c.Faults.Enabled, "enable fault injection and the FaultInjection service")
+	fs.StringVar(&c.Faults.File, "faults-file", c.Faults.File, "JSON fault rules, reloaded when the file changes, enables fault injection")
+	fs.Int64Var(&c.Faults.Seed, "faults-seed", c.Faults.Seed, "seed of the fault injection decisions, defaults to one derived from -seed")
+	fs.StringVar(&c.Record.File, "record", c.Record.File, "record the calls received to this file for replay, gzipped if it ends in .gz")
+	fs.BoolVar(&c.Record.Client, "record-client", c.Record.Client, "also record the calls made to dependencies, with -record")
+	fs.StringVar(&c.Admin.Addr, "admin-addr", c.Admin.Addr, "address of the Admin service, empty to disable")
+	fs.StringVar(&c.Audit.File, "audit-file", c.Audit.File, "append charges and authorization decisions to this hash-chained audit log")
+	fs.StringVar(&c.Audit.KeyFile, "audit-key-file", c.Audit.KeyFile, "secret key of the audit log HMACs, at least 32 bytes, required with -audit-file")
+	fs.StringVar(&c.Audit.HeadFile, "audit-head-file", c.Audit.HeadFile, "keep the last record of the audit log in this file, on other storage, to detect truncation")
+	fs.BoolVar(&c.Webhooks.Enabled, "webhooks", c.Webhooks.Enabled, "serve the Webhooks service and push events to the registered endpoints")
+	fs.IntVar(&c.Webhooks.MaxAttempts, "webhook-max-attempts", c.Webhooks.MaxAttempts, "attempts per webhook delivery before it becomes a dead letter, 5 if 0")
+	fs.BoolVar(&c.Webhooks.AllowPrivate, "webhook-allow-private", c.Webhooks.AllowPrivate, "allow webhooks to loopback, private and link-local addresses, for local development")
+	fs.StringVar(&c.Broker.URL, "broker", c.Broker.URL, "message broker to publish and consume exchange rates on: nats://host:port or memory://name")
+	fs.StringVar(&c.Broker.Listen, "broker-listen", c.Broker.Listen, "serve an embedded NATS server with JetStream, without authentication, on this address")
+	fs.StringVar(&c.Broker.Group, "broker-group", c.Broker.Group, "consumer group of the broker subscriptions, empty for every instance to receive every message")
+	fs.BoolVar(&c.Diagnostics.Reflection, "reflection", c.Diagnostics.Reflection, "register gRPC server reflection")
+	fs.BoolVar(&c.Diagnostics.Channelz, "channelz", c.Diagnostics.Channelz, "register the channelz service")
+	fs.StringVar(&c.Diagnostics.Addr, "diagnostics-addr", c.Diagnostics.Addr, "address of the pprof HTTP listener, empty to disable")
+	fs.Var((*featureFlags)(&c.Features), "feature", "turn a feature flag on or off as name=true|false, repeatable")
+	fs.TextVar(&c.Deadline.HopMargin, "hop-margin", c.Deadline.HopMargin, "time reserved from the deadline on every downstream call")
+	fs.IntVar(&c.Resilience.Retry.MaxAttempts, "retry-max-attempts", c.Resilience.Retry.MaxAttempts, "attempts per outgoing call including the first, below 2 disables retries")
+	fs.IntVar(&c.Resilience.Breaker.FailureThreshold, "breaker-threshold", c.Resilience.Breaker.FailureThreshold, "consecutive failures that open the circuit breaker, 0 disables it")
+	return fs
+}
+
+// parse applies environment variables and then command line flags to fs.
+func parse(fs *flag.FlagSet, name string, args []string) error {
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		key := envName(name, f.Name)
+		if v, ok := os.LookupEnv(key); ok && err == nil {
+			if setErr := fs.Set(f.Name, v); setErr != nil {
+				err = fmt.Errorf("invalid value %q for %s: %w", v, key, setErr)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return fs.Parse(args)
+}
+
+func envName(service, flagName string) string {
+	return strings.ToUpper(strings.ReplaceAll(service+"_"+flagName, "-", "_"))
+}
+
+// dependencies is a flag.Value collecting name=addr pairs.
+type dependencies map[string]string
+
+func (d *dependencies) String() string {
+	if d == nil {
+		return ""
+	}
+	pairs := make([]string, 0, len(*d))
+	for name, addr := range *d {
+		pairs = append(pairs, name+"="+addr)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (d *dependencies) Set(v string) error {
+	for _, pair := range strings.Split(v, ",") {
+		name, addr, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || name == "" || addr == "" {
+			return fmt.Errorf("expected name=host:port, got %q", pair)
+		}
+		if *d == nil {
+			*d = map[string]string{}
+		}
+		(*d)[name] = addr
+	}
+	return nil
+}
+
+// logLevels is a flag.Value collecting package=LEVEL pairs.
+type logLevels map[string]slog.Level
+
+func (l *logLevels) String() string {
+	if l == nil {
+		return ""
+	}
+	pairs := make([]string, 0, len(*l))
+	for pkg, level := range *l {
+		pairs = append(pairs, pkg+"="+level.String())
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (l *logLevels) Set(v string) error {
+	for _, pair := range strings.Split(v, ",") {
+		pkg, name, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || pkg == "" {
+			return fmt.Errorf("expected package=LEVEL, got %q", pair)
+		}
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(name)); err != nil {
+			return err
+		}
+		if *l == nil {
+			*l = map[string]slog.Level{}
+		}
+		(*l)[pkg] = level
+	}
+	return nil
+}
+
+// featureFlags is a flag.Value collecting name=bool pairs.
+type featureFlags map[string]bool
+
+func (f *featureFlags) String() string {
+	if f == nil {
+		return ""
+	}
+	pairs := make([]string, 0, len(*f))
+	for name, on := range *f {
+		pairs = append(pairs, name+"="+strconv.FormatBool(on))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (f *featureFlags) Set(v string) error {
+	for _, pair := range strings.Split(v, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || name == "" {
+			return fmt.Errorf("expected name=true|false, got %q", pair)
+		}
+		on, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("feature flag %s: %w", name, err)
+		}
+		if *f == nil {
+			*f = map[string]bool{}
+		}
+		(*f)[name] = on
+	}
+	return nil
+}
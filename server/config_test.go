@@ -0,0 +1,74 @@
+package server
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// load is Load with the usage output discarded.
+func load(t *testing.T, args ...string) (Config, error) {
+	stderr := os.Stderr
+	devnull, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stderr = devnull
+	defer func() {
+		os.Stderr = stderr
+		devnull.Close()
+	}()
+	return Load("test", args, Config{})
+}
+
+func TestLoadReturnsFlagErrors(t *testing.T) {
+	if _, err := load(t, "-no-such-flag"); err == nil {
+		t.Error("Load() with an unknown flag = nil, want an error")
+	}
+	if _, err := load(t, "-trace-sample-ratio", "2"); err == nil {
+		t.Error("Load() with a sample ratio of 2 = nil, want an error")
+	}
+	if _, err := load(t, "-h"); !errors.Is(err, flag.ErrHelp) {
+		t.Errorf("Load() with -h = %v, want flag.ErrHelp", err)
+	}
+}
+
+func TestLoadSampleRatio(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(file, []byte(`{"tracing": {"sample_ratio": 0}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		args []string
+		want *float64
+	}{
+		{"unset", nil, nil},
+		{"flag", []string{"-trace-sample-ratio", "0"}, ptr(0)},
+		{"file", []string{"-config", file}, ptr(0)},
+		{"flag over file", []string{"-config", file, "-trace-sample-ratio", "0.5"}, ptr(0.5)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := load(t, tt.args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := cfg.Tracing.SampleRatio
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("SampleRatio = %v, want %v", deref(got), deref(tt.want))
+			}
+		})
+	}
+}
+
+func ptr(f float64) *float64 { return &f }
+
+func deref(f *float64) any {
+	if f == nil {
+		return nil
+	}
+	return *f
+}
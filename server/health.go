@@ -0,0 +1,44 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// The empty service name reports liveness: it is SERVING for as long as the
+// server accepts RPCs. Each registered service reports its own readiness under
+// its full name, e.g. "service.Order", as set by SetReady or WatchConn.
+
+// SetReady sets the readiness status of a service.
+func (s *Server) SetReady(service string, ready bool) {
+	status := healthpb.HealthCheckResponse_NOT_SERVING
+	if ready {
+		status = healthpb.HealthCheckResponse_SERVING
+	}
+	s.health.SetServingStatus(service, status)
+}
+
+// WatchConn keeps the readiness of service in sync with the connectivity state
+// of a downstream connection until ctx is done. It also triggers reconnects
+// when the connection falls back to idle.
+func (s *Server) WatchConn(ctx context.Context, service string, conn *grpc.ClientConn) {
+	for {
+		state := conn.GetState()
+		s.SetReady(service, state == connectivity.Ready)
+		if state == connectivity.Idle {
+			conn.Connect()
+		}
+		if !conn.WaitForStateChange(ctx, state) {
+			return
+		}
+		slog.Info("Downstream connection changed state",
+			slog.String("target", conn.Target()),
+			slog.String("from", state.String()),
+			slog.String("to", conn.GetState().String()),
+		)
+	}
+}
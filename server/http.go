@@ -0,0 +1,49 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// RunHTTP is Run for services that serve HTTP instead of gRPC, such as the
+// gateway. The gRPC server is not started.
+func (s *Server) RunHTTP(ctx context.Context, h http.Handler) error {
+	defer s.close()
+
+	lis, err := net.Listen("tcp", s.cfg.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.cfg.Addr, err)
+	}
+	for _, fn := range s.background {
+		go fn(ctx)
+	}
+
+	srv := &http.Server{Handler: h, ReadHeaderTimeout: 5 * time.Second}
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.Serve(lis) }()
+	slog.Info("Server is running", slog.String("service", s.cfg.Name), slog.String("addr", lis.Addr().String()))
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	timeout := time.Duration(s.cfg.ShutdownTimeout)
+	slog.Info("Shutting down, draining in-flight requests", slog.String("service", s.cfg.Name), slog.Duration("timeout", timeout))
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("Graceful shutdown timed out, closing remaining connections", slog.String("service", s.cfg.Name))
+		srv.Close()
+	}
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
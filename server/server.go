@@ -0,0 +1,668 @@
+// Package server bootstraps the gRPC services: it loads their configuration,
+// builds a grpc.Server with the standard interceptor chain and drains
+// in-flight RPCs on shutdown.
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"grpc-test/admin"
+	"grpc-test/audit"
+	"grpc-test/authz"
+	"grpc-test/broker"
+	"grpc-test/clock"
+	"grpc-test/deadline"
+	"grpc-test/diagnostics"
+	"grpc-test/discovery"
+	"grpc-test/faults"
+	"grpc-test/features"
+	"grpc-test/logging"
+	"grpc-test/metrics"
+	pb "grpc-test/proto"
+	"grpc-test/random"
+	"grpc-test/ratelimit"
+	"grpc-test/record"
+	"grpc-test/tlsconfig"
+	"grpc-test/tracing"
+	"grpc-test/webhook"
+
+	"github.com/revotech-group/go-lib/grpc/interceptors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Server wraps a grpc.Server with its configuration and lifecycle.
+type Server struct {
+	cfg       Config
+	grpc      *grpc.Server
+	health    *health.Server
+	dialCreds credentials.TransportCredentials
+	limiter   *ratelimit.Limiter
+	faults    *faults.Injector
+	recorder  *record.Recorder
+	clock     clock.Clock
+	logs      *logging.Control
+	features  *features.Set
+	webhooks  *webhook.Dispatcher
+	broker    broker.Broker
+	// admin serves the Admin service on its own listener, nil if disabled.
+	admin *grpc.Server
+
+	// draining is cancelled when shutdown starts, ending long-lived streams.
+	draining context.Context
+	drain    context.CancelFunc
+
+	// background runs alongside the server for as long as it serves.
+	background []func(context.Context)
+	// closers run after the server has stopped, in reverse order.
+	closers []func(context.Context) error
+}
+
+type options struct {
+	unary      []grpc.UnaryServerInterceptor
+	stream     []grpc.StreamServerInterceptor
+	serverOpts []grpc.ServerOption
+	clock      clock.Clock
+}
+
+// Option customizes the server built by New.
+type Option func(*options)
+
+// WithUnaryInterceptors appends interceptors after the standard chain.
+func WithUnaryInterceptors(i ...grpc.UnaryServerInterceptor) Option {
+	return func(o *options) { o.unary = append(o.unary, i...) }
+}
+
+// WithStreamInterceptors appends interceptors after the standard chain.
+func WithStreamInterceptors(i ...grpc.StreamServerInterceptor) Option {
+	return func(o *options) { o.stream = append(o.stream, i...) }
+}
+
+// WithServerOptions passes extra options to grpc.NewServer.
+func WithServerOptions(opts ...grpc.ServerOption) Option {
+	return func(o *options) { o.serverOpts = append(o.serverOpts, opts...) }
+}
+
+// WithClock replaces the system clock of the server and its services, e.g.
+// with a clock.Fake.
+func WithClock(c clock.Clock) Option {
+	return func(o *options) { o.clock = c }
+}
+
+// New sets up the default logger and tracing, and creates a gRPC server whose
+// chain is built around go-lib's error interceptors. The grpc.health.v1
+// service is registered on every server, and the Admin service on its own
+// server when cfg.Admin.Addr is set, along with FaultInjection when faults
+// are enabled.
+func New(cfg Config, opts ...Option) (_ *Server, err error) {
+	// closers release what is opened below, in reverse. They are handed to
+	// the server with OnStop, or run here if New fails.
+	var closers []func(context.Context) error
+	defer func() {
+		if err != nil {
+			for _, c := range slices.Backward(closers) {
+				c(context.Background())
+			}
+		}
+	}()
+
+	logs := logging.NewControl(cfg.LogLevel, cfg.LogLevels, cfg.Debug)
+	logs.Install()
+	if err := cfg.Discovery.Validate(); err != nil {
+		return nil, err
+	}
+
+	serverCreds, err := tlsconfig.ServerCredentials(cfg.TLS)
+	if err != nil {
+		return nil, fmt.Errorf("server TLS: %w", err)
+	}
+	dialCreds, err := tlsconfig.ClientCredentials(cfg.TLS)
+	if err != nil {
+		return nil, fmt.Errorf("client TLS: %w", err)
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Name, cfg.Tracing)
+	if err != nil {
+		return nil, err
+	}
+	closers = append(closers, shutdownTracing)
+
+	o := &options{clock: clock.Real}
+	for _, opt := range opts {
+		opt(o)
+	}
+	metrics.SetClock(o.clock)
+
+	// Runs without a seed get a random one, logged so that they can be
+	// replayed with -seed.
+	if cfg.Seed == 0 {
+		cfg.Seed = random.NewSeed()
+	}
+	slog.Info("Random seed", slog.String("service", cfg.Name), slog.Int64("seed", cfg.Seed))
+
+	var policy *authz.Policy
+	if cfg.Authz.PolicyFile != "" {
+		if policy, err = authz.LoadPolicy(cfg.Authz.PolicyFile); err != nil {
+			return nil, err
+		}
+	}
+	rules := cfg.RateLimit.Rules
+	if cfg.RateLimit.File != "" {
+		if rules, err = ratelimit.LoadRules(cfg.RateLimit.File); err != nil {
+			return nil, err
+		}
+	}
+	var injector *faults.Injector
+	if cfg.Faults.Active() {
+		faultsCfg := cfg.Faults
+		if faultsCfg.File != "" {
+			if faultsCfg.Rules, err = faults.LoadRules(faultsCfg.File); err != nil {
+				return nil, err
+			}
+		}
+		rng := random.Derive(cfg.Seed, cfg.Name+"/faults")
+		if faultsCfg.Seed != 0 {
+			rng = random.New(faultsCfg.Seed)
+		}
+		if injector, err = faults.NewInjector(faultsCfg.Rules, rng, o.clock); err != nil {
+			return nil, fmt.Errorf("faults: %w", err)
+		}
+	}
+
+	var recording *record.Writer
+	var recorder *record.Recorder
+	if cfg.Record.Active() {
+		if err := cfg.Record.Validate(); err != nil {
+			return nil, fmt.Errorf("record: %w", err)
+		}
+		if recording, err = record.Create(cfg.Record.File); err != nil {
+			return nil, err
+		}
+		closers = append(closers, func(context.Context) error { return recording.Close() })
+		recorder = record.NewRecorder(cfg.Record, recording, o.clock)
+	}
+
+	// The embedded broker serves other processes, and this one unless it
+	// is given the URL of another broker.
+	// Closers run in reverse, so the client closes before the server.
+	var embedded *broker.Embedded
+	var messages broker.Broker
+	if cfg.Broker.Listen != "" {
+		if embedded, err = broker.StartEmbedded(cfg.Broker.Listen); err != nil {
+			return nil, err
+		}
+		closers = append(closers, func(context.Context) error { return embedded.Shutdown() })
+		if cfg.Broker.URL == "" {
+			if messages, err = embedded.Connect(cfg.Name, o.clock); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if cfg.Broker.URL != "" {
+		if messages, err = broker.Open(cfg.Broker.URL, cfg.Name, o.clock); err != nil {
+			return nil, err
+		}
+	}
+	if messages != nil {
+		closers = append(closers, func(context.Context) error { return messages.Close() })
+	}
+
+	var auditLog *audit.Log
+	if cfg.Audit.File != "" {
+		store, err := audit.OpenFile(cfg.Audit)
+		if err != nil {
+			return nil, fmt.Errorf("audit: %w", err)
+		}
+		auditLog = audit.NewLog(cfg.Name, store, o.clock)
+		closers = append(closers, func(context.Context) error { return auditLog.Close() })
+	}
+
+	draining, drain := context.WithCancel(context.Background())
+	enforcer := deadline.NewEnforcer(cfg.Deadline)
+	requestIDs := random.Derive(cfg.Seed, cfg.Name+"/request_ids")
+	unary := []grpc.UnaryServerInterceptor{
+		metrics.UnaryServerInterceptor(),
+		logging.UnaryServerInterceptor(requestIDs),
+	}
+	stream := []grpc.StreamServerInterceptor{
+		metrics.StreamServerInterceptor(),
+		logging.StreamServerInterceptor(requestIDs),
+	}
+	// The recorder sees the statuses go-lib's interceptor makes of AppErrors.
+	if recorder != nil {
+		unary = append(unary, recorder.UnaryServerInterceptor())
+		stream = append(stream, recorder.StreamServerInterceptor())
+	}
+	unary = append(append(unary,
+		interceptors.UnaryServerErrorInterceptor(),
+		metrics.UnaryErrorNameInterceptor(),
+		tracing.UnaryServerInterceptor(),
+		enforcer.UnaryServerInterceptor(),
+	), o.unary...)
+	stream = append(append(stream,
+		interceptors.StreamServerErrorInterceptor(),
+		metrics.StreamErrorNameInterceptor(),
+		tracing.StreamServerInterceptor(),
+		drainInterceptor(draining),
+		enforcer.StreamServerInterceptor(),
+	), o.stream...)
+	if cfg.TLS.ClientAuth && len(cfg.TLS.AllowedPeers) > 0 {
+		unary = append(unary, tlsconfig.UnaryPeerInterceptor(cfg.TLS.AllowedPeers))
+		stream = append(stream, tlsconfig.StreamPeerInterceptor(cfg.TLS.AllowedPeers))
+	}
+	var authorizer *authz.Authorizer
+	if policy != nil {
+		var auditor authz.Auditor = authz.LogAuditor{}
+		if auditLog != nil {
+			auditor = authz.Auditors{auditor, auditLog}
+		}
+		authorizer = authz.NewAuthorizer(policy, auditor)
+		unary = append(unary, authorizer.UnaryServerInterceptor())
+		stream = append(stream, authorizer.StreamServerInterceptor())
+	}
+	if auditLog != nil {
+		unary = append(unary, auditLog.UnaryServerInterceptor())
+	}
+
+	limiter := ratelimit.NewLimiter(rules, o.clock)
+	unary = append(unary, limiter.UnaryServerInterceptor())
+	stream = append(stream, limiter.StreamServerInterceptor())
+	// Faults go last, right in front of the handlers.
+	if injector != nil {
+		unary = append(unary, injector.UnaryServerInterceptor())
+		stream = append(stream, injector.StreamServerInterceptor())
+	}
+	serverOpts := append([]grpc.ServerOption{
+		grpc.Creds(serverCreds),
+		tracing.ServerOption(),
+		grpc.ChainUnaryInterceptor(unary...),
+		grpc.ChainStreamInterceptor(stream...),
+	}, o.serverOpts...)
+
+	s := &Server{
+		cfg:       cfg,
+		grpc:      grpc.NewServer(serverOpts...),
+		health:    health.NewServer(),
+		dialCreds: dialCreds,
+		limiter:   limiter,
+		faults:    injector,
+		recorder:  recorder,
+		clock:     o.clock,
+		logs:      logs,
+		features:  features.NewSet(cfg.Features),
+		broker:    messages,
+		draining:  draining,
+		drain:     drain,
+	}
+	healthpb.RegisterHealthServer(s.grpc, s.health)
+	for _, c := range closers {
+		s.OnStop(c)
+	}
+	if recording != nil {
+		slog.Info("Recording calls", slog.String("service", cfg.Name), slog.String("file", cfg.Record.File), slog.Bool("client", cfg.Record.Client))
+	}
+	if auditLog != nil {
+		slog.Info("Writing audit log", slog.String("service", cfg.Name), slog.String("file", cfg.Audit.File))
+	}
+
+	if cfg.Admin.Addr != "" {
+		s.admin = newAdminServer(serverCreds, authorizer, requestIDs)
+		pb.RegisterAdminServer(s.admin, admin.NewService(cfg.Name, logs, s.features, auditLog, cfg))
+		diagnostics.Register(s.admin, cfg.Diagnostics)
+	}
+	if err := s.diagnostics(authorizer); err != nil {
+		return nil, err
+	}
+
+	if addr := cfg.Metrics.Addr; addr != "" {
+		s.Go(func(ctx context.Context) {
+			if err := metrics.Serve(ctx, addr); err != nil {
+				slog.Error("Failed to serve metrics", slog.Any("error", err))
+			}
+		})
+	}
+	if file := cfg.RateLimit.File; file != "" {
+		s.Go(func(ctx context.Context) { limiter.WatchFile(ctx, file, 2*time.Second) })
+	}
+	if cfg.Webhooks.Enabled {
+		s.webhooks = webhook.NewDispatcher(cfg.Webhooks, o.clock, random.Derive(cfg.Seed, cfg.Name+"/webhooks"))
+		pb.RegisterWebhooksServer(s.grpc, webhook.NewService(s.webhooks))
+		s.Go(s.webhooks.Run)
+	}
+	if injector != nil {
+		// Rules are changed through the admin server only, as they can fail
+		// every call of the service.
+		if s.admin != nil {
+			pb.RegisterFaultInjectionServer(s.admin, faults.NewService(injector))
+		} else {
+			slog.Warn("FaultInjection service disabled, it is served with the Admin service", slog.String("service", cfg.Name))
+		}
+		slog.Warn("Fault injection is enabled", slog.String("service", cfg.Name), slog.Int("rules", len(injector.Rules())))
+		if file := cfg.Faults.File; file != "" {
+			s.Go(func(ctx context.Context) { injector.WatchFile(ctx, file, 2*time.Second) })
+		}
+	}
+	return s, nil
+}
+
+// GRPC returns the underlying server for service registration.
+func (s *Server) GRPC() *grpc.Server {
+	return s.grpc
+}
+
+func (s *Server) Config() Config {
+	return s.cfg
+}
+
+// RateLimiter returns the limiter, whose rules can be changed at runtime.
+func (s *Server) RateLimiter() *ratelimit.Limiter {
+	return s.limiter
+}
+
+// Faults returns the fault injector, nil unless fault injection is enabled.
+func (s *Server) Faults() *faults.Injector {
+	return s.faults
+}
+
+// Features returns the feature flags of the service, defined by the service
+// and flipped through the Admin service.
+func (s *Server) Features() *features.Set {
+	return s.features
+}
+
+// Webhooks returns the dispatcher services publish events to, nil unless
+// webhooks are enabled.
+func (s *Server) Webhooks() *webhook.Dispatcher {
+	return s.webhooks
+}
+
+// Broker returns the message broker of the service, nil if none is
+// configured.
+func (s *Server) Broker() broker.Broker {
+	return s.broker
+}
+
+// Logging returns the control of the log levels and debug mode.
+func (s *Server) Logging() *logging.Control {
+	return s.logs
+}
+
+// Clock returns the clock services should use instead of the time package.
+func (s *Server) Clock() clock.Clock {
+	return s.clock
+}
+
+// Rand returns the generator called name, derived from the seed of the run.
+// Given the same seed, it returns the same numbers.
+func (s *Server) Rand(name string) random.Rand {
+	return random.Derive(s.cfg.Seed, s.cfg.Name+"/"+name)
+}
+
+// Go registers fn to run in the background while the server is serving. Its
+// context is cancelled when shutdown starts.
+func (s *Server) Go(fn func(ctx context.Context)) {
+	s.background = append(s.background, fn)
+}
+
+// OnStop registers fn to run once the server has stopped serving, e.g. to
+// flush telemetry. Functions run in reverse registration order.
+func (s *Server) OnStop(fn func(context.Context) error) {
+	s.closers = append(s.closers, fn)
+}
+
+// DialOptions returns the options every client connection to a downstream
+// service should use, including its transport credentials.
+func (s *Server) DialOptions() []grpc.DialOption {
+	margin := time.Duration(s.cfg.Deadline.HopMargin)
+	unary := []grpc.UnaryClientInterceptor{
+		logging.UnaryClientInterceptor(),
+		authz.UnaryClientInterceptor(),
+		deadline.UnaryClientInterceptor(margin),
+	}
+	stream := []grpc.StreamClientInterceptor{logging.StreamClientInterceptor(), deadline.StreamClientInterceptor(margin)}
+	if s.faults != nil {
+		unary = append(unary, s.faults.UnaryClientInterceptor())
+		stream = append(stream, s.faults.StreamClientInterceptor())
+	}
+	// Calls are recorded as they go over the wire, without injected faults.
+	if s.recorder != nil && s.cfg.Record.Client {
+		unary = append(unary, s.recorder.UnaryClientInterceptor())
+		stream = append(stream, s.recorder.StreamClientInterceptor())
+	}
+	return append(discovery.DialOptions(s.cfg.Discovery, s.Rand("balancer"), s.clock),
+		grpc.WithTransportCredentials(s.dialCreds),
+		tracing.DialOption(),
+		grpc.WithChainUnaryInterceptor(unary...),
+		grpc.WithChainStreamInterceptor(stream...),
+	)
+}
+
+// Run serves until ctx is cancelled, then stops accepting new RPCs and waits up
+// to ShutdownTimeout for in-flight ones before closing all connections.
+func (s *Server) Run(ctx context.Context) error {
+	lis, err := net.Listen("tcp", s.cfg.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.cfg.Addr, err)
+	}
+	return s.Serve(ctx, lis)
+}
+
+// Serve is Run on an existing listener.
+func (s *Server) Serve(ctx context.Context, lis net.Listener) error {
+	defer s.close()
+
+	serve, gracefulStop, stop := s.grpc.Serve, s.grpc.GracefulStop, s.grpc.Stop
+	if s.cfg.Web.Enabled {
+		var err error
+		if serve, gracefulStop, stop, err = s.webTransport(); err != nil {
+			return err
+		}
+	}
+
+	if unknown := s.features.Unknown(); len(unknown) > 0 {
+		slog.Warn("Unknown feature flags configured", slog.String("service", s.cfg.Name), slog.String("flags", strings.Join(unknown, ",")))
+	}
+	if s.admin != nil {
+		stopAdmin, err := s.serveAdmin()
+		if err != nil {
+			return err
+		}
+		defer stopAdmin()
+	}
+
+	for _, fn := range s.background {
+		go fn(ctx)
+	}
+
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- serve(lis) }()
+	slog.Info("Server is running", slog.String("service", s.cfg.Name), slog.String("addr", lis.Addr().String()))
+	deregister := s.register(lis.Addr().String())
+
+	select {
+	case err := <-serveErr:
+		deregister()
+		return err
+	case <-ctx.Done():
+	}
+
+	// Report NOT_SERVING for every service before draining so that the
+	// orchestrator stops routing new traffic here. Open streams are told to
+	// finish, unary calls run to completion.
+	s.health.Shutdown()
+	deregister()
+	s.drain()
+
+	timeout := time.Duration(s.cfg.ShutdownTimeout)
+	slog.Info("Shutting down, draining in-flight RPCs", slog.String("service", s.cfg.Name), slog.Duration("timeout", timeout))
+
+	stopped := make(chan struct{})
+	go func() {
+		gracefulStop()
+		close(stopped)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-stopped:
+	case <-timer.C:
+		slog.Warn("Graceful shutdown timed out, closing remaining connections", slog.String("service", s.cfg.Name))
+		stop()
+		<-stopped
+	}
+	return <-serveErr
+}
+
+// diagnostics registers reflection and channelz on the servers and the pprof
+// listener, as configured. With a policy, they are authorized like any other
+// method, the HTTP listener by request path.
+func (s *Server) diagnostics(authorizer *authz.Authorizer) error {
+	cfg := s.cfg.Diagnostics
+	if !cfg.Enabled() {
+		return nil
+	}
+	if err := cfg.Validate(authorizer != nil); err != nil {
+		return err
+	}
+	if authorizer == nil {
+		slog.Warn("Diagnostics are enabled without an authorization policy", slog.String("service", s.cfg.Name))
+	}
+	diagnostics.Register(s.grpc, cfg)
+	if cfg.Addr == "" {
+		return nil
+	}
+
+	tlsConfig, err := tlsconfig.ServerConfig(s.cfg.TLS)
+	if err != nil {
+		return fmt.Errorf("diagnostics TLS: %w", err)
+	}
+	h := diagnostics.Handler()
+	if authorizer != nil {
+		h = authorizer.Handler(h)
+	}
+	s.Go(func(ctx context.Context) {
+		if err := diagnostics.Serve(ctx, cfg.Addr, h, tlsConfig); err != nil {
+			slog.Error("Failed to serve diagnostics", slog.Any("error", err))
+		}
+	})
+	return nil
+}
+
+// serveAdmin serves the Admin service until the returned function is called.
+// It is stopped last, so that operators keep access while the server drains.
+func (s *Server) serveAdmin() (stop func(), err error) {
+	lis, err := net.Listen("tcp", s.cfg.Admin.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s for the Admin service: %w", s.cfg.Admin.Addr, err)
+	}
+	go func() {
+		if err := s.admin.Serve(lis); err != nil {
+			slog.Error("Failed to serve the Admin service", slog.Any("error", err))
+		}
+	}()
+	slog.Info("Admin service is running", slog.String("service", s.cfg.Name), slog.String("addr", lis.Addr().String()))
+	return s.admin.Stop, nil
+}
+
+// newAdminServer returns the server of the Admin service. It authorizes with
+// the policy of the service, if any, but skips the limits and faults of the
+// serving chain so that it stays usable during an incident.
+func newAdminServer(creds credentials.TransportCredentials, authorizer *authz.Authorizer, requestIDs random.Rand) *grpc.Server {
+	unary := []grpc.UnaryServerInterceptor{
+		logging.UnaryServerInterceptor(requestIDs),
+		interceptors.UnaryServerErrorInterceptor(),
+	}
+	if authorizer != nil {
+		unary = append(unary, authorizer.UnaryServerInterceptor())
+	}
+	return grpc.NewServer(grpc.Creds(creds), grpc.ChainUnaryInterceptor(unary...))
+}
+
+// register adds the server to the registry file, if one is configured, and
+// renews its heartbeat until the function it returns removes it again.
+func (s *Server) register(addr string) (deregister func()) {
+	file := s.cfg.Discovery.Registry
+	if file == "" {
+		return func() {}
+	}
+	advertise := s.cfg.Discovery.Advertise
+	if advertise == "" {
+		var err error
+		if advertise, err = discovery.AdvertiseAddr(addr); err != nil {
+			slog.Error("Failed to determine advertised address", slog.Any("error", err))
+			return func() {}
+		}
+	}
+	ttl := s.cfg.Discovery.HeartbeatTTL()
+	if err := discovery.Register(file, s.cfg.Name, advertise, s.clock.Now(), ttl); err != nil {
+		slog.Error("Failed to register service", slog.String("registry", file), slog.Any("error", err))
+		return func() {}
+	}
+	slog.Info("Registered service", slog.String("service", s.cfg.Name), slog.String("addr", advertise), slog.String("registry", file))
+
+	// Renew the heartbeat well within the TTL. A failed renewal is retried
+	// at the next tick, the entry only goes stale after several.
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := s.clock.NewTicker(ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C():
+			}
+			if err := discovery.Register(file, s.cfg.Name, advertise, s.clock.Now(), ttl); err != nil {
+				slog.Warn("Failed to renew registry heartbeat", slog.String("registry", file), slog.Any("error", err))
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-stopped
+		if err := discovery.Deregister(file, s.cfg.Name, advertise); err != nil {
+			slog.Error("Failed to deregister service", slog.String("registry", file), slog.Any("error", err))
+		}
+	}
+}
+
+func (s *Server) close() {
+	s.drain()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(s.cfg.ShutdownTimeout))
+	defer cancel()
+	for i := len(s.closers) - 1; i >= 0; i-- {
+		if err := s.closers[i](ctx); err != nil {
+			slog.Error("Error during shutdown", slog.String("service", s.cfg.Name), slog.Any("error", err))
+		}
+	}
+}
+
+// drainInterceptor cancels stream contexts when shutdown starts, so that
+// streaming handlers return instead of holding up GracefulStop.
+func drainInterceptor(draining context.Context) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, cancel := context.WithCancel(ss.Context())
+		defer context.AfterFunc(draining, cancel)()
+		defer cancel()
+		return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
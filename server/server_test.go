@@ -0,0 +1,34 @@
+package server
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+
+	"grpc-test/audit"
+	"grpc-test/broker"
+)
+
+func TestNewReleasesOnError(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	lis.Close()
+
+	// The audit log has no key, so New fails after starting the broker
+	cfg := Config{
+		Name:   "test",
+		Broker: broker.Config{Listen: addr},
+		Audit:  audit.Config{File: filepath.Join(t.TempDir(), "audit.jsonl")},
+	}
+	if _, err := New(cfg); err == nil {
+		t.Fatal("New() without an audit key succeeded")
+	}
+	lis, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("broker address still in use after New() failed: %v", err)
+	}
+	lis.Close()
+}
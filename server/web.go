@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"grpc-test/tlsconfig"
+	"grpc-test/web"
+)
+
+// webTransport serves the gRPC server through net/http, so that the browser
+// protocols share its port. grpc.Server.GracefulStop cannot drain streams
+// handed over by net/http, so in-flight requests are counted here instead.
+func (s *Server) webTransport() (serve func(net.Listener) error, gracefulStop, stop func(), err error) {
+	h, err := web.Handler(s.grpc, s.cfg.Web)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	tlsConf, err := tlsconfig.ServerConfig(s.cfg.TLS)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if tlsConf != nil {
+		tlsConf.NextProtos = []string{"h2", "http/1.1"}
+	}
+
+	var inflight atomic.Int64
+	hs := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			inflight.Add(1)
+			defer inflight.Add(-1)
+			h.ServeHTTP(w, r)
+		}),
+		ReadHeaderTimeout: 5 * time.Second,
+		TLSConfig:         tlsConf,
+	}
+	forced := make(chan struct{})
+
+	serve = func(lis net.Listener) error {
+		if tlsConf != nil {
+			err = hs.ServeTLS(lis, "", "")
+		} else {
+			err = hs.Serve(lis)
+		}
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return err
+	}
+	gracefulStop = func() {
+		hs.Shutdown(context.Background())
+		// Cleartext HTTP/2 connections are hijacked from net/http and not
+		// waited for by Shutdown
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+		for inflight.Load() > 0 {
+			select {
+			case <-forced:
+				return
+			case <-ticker.C:
+			}
+		}
+		s.grpc.Stop()
+	}
+	stop = func() {
+		close(forced)
+		hs.Close()
+		s.grpc.Stop()
+	}
+	return serve, gracefulStop, stop, nil
+}
@@ -0,0 +1,295 @@
+// Package testharness boots the Order, Charge and Currency services in-process
+// over bufconn, so that tests can drive full flows without listening on ports:
+//
+//	h := testharness.Start(t, testharness.WithGateway(paymentservice.GatewayFunc(
+//		func(context.Context, *pb.ChargeRequest) (*pb.ChargeResponse, error) {
+//			return nil, domain.ErrNotEnoughCredit()
+//		})))
+//	_, err := h.Order.PlaceOrder(ctx, &pb.OrderRequest{Product: "Laptop", Quantity: 1})
+//	// err is an AppError carrying *pb.ErrNotEnoughCharge
+//
+// Each service runs the same interceptor chain as in production. Fakes replace
+// the payment gateway or a whole service, and WithConfig adjusts the
+// configuration of a service before it starts.
+package testharness
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"grpc-test/clock"
+	"grpc-test/currencyservice"
+	"grpc-test/lib"
+	"grpc-test/orderservice"
+	"grpc-test/paymentservice"
+	pb "grpc-test/proto"
+	"grpc-test/server"
+
+	"github.com/revotech-group/go-lib/grpc/interceptors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// Service names, as used by WithConfig and Conn.
+const (
+	Order    = "order"
+	Payment  = "payment"
+	Currency = "currency"
+)
+
+// readyTimeout is how long Start waits for every service to report SERVING.
+const readyTimeout = 5 * time.Second
+
+// Harness is a running set of services.
+type Harness struct {
+	// Clients of the services. Errors are decoded into AppErrors by go-lib.
+	Order    pb.OrderClient
+	Charge   pb.ChargeClient
+	Currency pb.CurrencyClient
+
+	// OrderServer is the Order service, nil if it was replaced by a fake.
+	OrderServer *orderservice.Server
+
+	listeners map[string]*bufconn.Listener
+	conns     map[string]*grpc.ClientConn
+}
+
+type options struct {
+	gateway    paymentservice.Gateway
+	charge     pb.ChargeServer
+	currency   pb.CurrencyServer
+	order      pb.OrderServer
+	interval   time.Duration
+	seed       int64
+	clock      clock.Clock
+	configs    map[string][]func(*server.Config)
+	serverOpts map[string][]server.Option
+}
+
+// Option customizes the harness.
+type Option func(*options)
+
+// WithGateway replaces the payment gateway, which by default approves every
+// charge.
+func WithGateway(g paymentservice.Gateway) Option {
+	return func(o *options) { o.gateway = g }
+}
+
+// WithOrderServer replaces the Order service with a fake.
+func WithOrderServer(s pb.OrderServer) Option {
+	return func(o *options) { o.order = s }
+}
+
+// WithChargeServer replaces the Charge service with a fake. It is always
+// ready and does not subscribe to exchange rates.
+func WithChargeServer(s pb.ChargeServer) Option {
+	return func(o *options) { o.charge = s }
+}
+
+// WithCurrencyServer replaces the Currency service with a fake.
+func WithCurrencyServer(s pb.CurrencyServer) Option {
+	return func(o *options) { o.currency = s }
+}
+
+// WithRateInterval sets how often the Currency service sends a rate, 100ms by
+// default.
+func WithRateInterval(d time.Duration) Option {
+	return func(o *options) { o.interval = d }
+}
+
+// WithSeed sets the seed of every service, 1 by default, so that runs make
+// the same random choices.
+func WithSeed(seed int64) Option {
+	return func(o *options) { o.seed = seed }
+}
+
+// WithClock runs every service on c, e.g. a clock.Fake that the test
+// advances. The rate interval and retry backoffs then pass only when c does.
+func WithClock(c clock.Clock) Option {
+	return func(o *options) { o.clock = c }
+}
+
+// WithConfig modifies the configuration of a service before it starts.
+func WithConfig(service string, fn func(*server.Config)) Option {
+	return func(o *options) { o.configs[service] = append(o.configs[service], fn) }
+}
+
+// WithServerOptions passes options to server.New for a service.
+func WithServerOptions(service string, opts ...server.Option) Option {
+	return func(o *options) { o.serverOpts[service] = append(o.serverOpts[service], opts...) }
+}
+
+// Start boots Currency, Payment and Order, waits until they are ready and
+// stops them when the test ends.
+func Start(t testing.TB, opts ...Option) *Harness {
+	t.Helper()
+	o := &options{
+		gateway:    paymentservice.ApproveGateway{},
+		interval:   100 * time.Millisecond,
+		seed:       1,
+		clock:      clock.Real,
+		configs:    map[string][]func(*server.Config){},
+		serverOpts: map[string][]server.Option{},
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	h := &Harness{listeners: map[string]*bufconn.Listener{}, conns: map[string]*grpc.ClientConn{}}
+	for _, name := range []string{Order, Payment, Currency} {
+		h.listeners[name] = bufconn.Listen(1 << 20)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	t.Cleanup(func() {
+		cancel()
+		wg.Wait()
+		for _, conn := range h.conns {
+			conn.Close()
+		}
+	})
+
+	// Services start downstream first, so that their dependencies are
+	// registered before anything dials them.
+	serve := func(name string, defaults server.Config, register func(*server.Server) error) {
+		t.Helper()
+		defaults.Seed = o.seed
+		serverOpts := append([]server.Option{server.WithClock(o.clock)}, o.serverOpts[name]...)
+		srv, err := server.New(h.config(name, defaults, o.configs[name]), serverOpts...)
+		if err != nil {
+			t.Fatalf("set up %s: %v", name, err)
+		}
+		if err := register(srv); err != nil {
+			t.Fatalf("start %s: %v", name, err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := srv.Serve(ctx, h.listeners[name]); err != nil {
+				t.Errorf("serve %s: %v", name, err)
+			}
+		}()
+	}
+
+	serve(Currency, currencyservice.DefaultConfig(), func(srv *server.Server) error {
+		s := o.currency
+		if s == nil {
+			s = currencyservice.NewServer(o.interval, srv.Clock(), srv.Rand("rates"))
+		}
+		pb.RegisterCurrencyServer(srv.GRPC(), s)
+		srv.SetReady(pb.Currency_ServiceDesc.ServiceName, true)
+		return nil
+	})
+	serve(Payment, paymentservice.DefaultConfig(), func(srv *server.Server) error {
+		if o.charge != nil {
+			pb.RegisterChargeServer(srv.GRPC(), o.charge)
+			srv.SetReady(pb.Charge_ServiceDesc.ServiceName, true)
+			return nil
+		}
+		paymentservice.Start(srv, o.gateway, h.dialer())
+		return nil
+	})
+	serve(Order, orderservice.DefaultConfig(), func(srv *server.Server) error {
+		if o.order != nil {
+			pb.RegisterOrderServer(srv.GRPC(), o.order)
+			srv.SetReady(pb.Order_ServiceDesc.ServiceName, true)
+			return nil
+		}
+		s, err := orderservice.Start(srv, h.dialer())
+		h.OrderServer = s
+		return err
+	})
+
+	h.Order = pb.NewOrderClient(h.Conn(t, Order))
+	h.Charge = pb.NewChargeClient(h.Conn(t, Payment))
+	h.Currency = pb.NewCurrencyClient(h.Conn(t, Currency))
+
+	readyCtx, cancelReady := context.WithTimeout(ctx, readyTimeout)
+	defer cancelReady()
+	for name, service := range map[string]string{
+		Currency: pb.Currency_ServiceDesc.ServiceName,
+		Payment:  pb.Charge_ServiceDesc.ServiceName,
+		Order:    pb.Order_ServiceDesc.ServiceName,
+	} {
+		if err := h.waitReady(readyCtx, name, service); err != nil {
+			t.Fatalf("%s is not ready: %v", name, err)
+		}
+	}
+	return h
+}
+
+// config resolves the configuration of a service as server.Load would,
+// without reading flags or the environment. Listeners other than the gRPC
+// one are disabled and only errors are logged.
+func (h *Harness) config(name string, cfg server.Config, modify []func(*server.Config)) server.Config {
+	cfg.Name = name
+	cfg.LogLevel = slog.LevelError
+	cfg.Debug = false
+	cfg.Metrics.Addr = ""
+	cfg.Admin.Addr = ""
+	cfg.ShutdownTimeout = lib.Duration(time.Second)
+	cfg.Dependencies = map[string]string{
+		"charge":   "passthrough:///" + Payment,
+		"currency": "passthrough:///" + Currency,
+	}
+	for _, fn := range modify {
+		fn(&cfg)
+	}
+	return cfg
+}
+
+// dialer routes connections to the bufconn listener named by the address.
+func (h *Harness) dialer() grpc.DialOption {
+	return grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+		lis, ok := h.listeners[addr]
+		if !ok {
+			return nil, fmt.Errorf("testharness: unknown service %q", addr)
+		}
+		return lis.DialContext(ctx)
+	})
+}
+
+// Conn returns a client connection to a service with go-lib's error
+// interceptors, reused across calls.
+func (h *Harness) Conn(t testing.TB, service string) *grpc.ClientConn {
+	t.Helper()
+	if conn, ok := h.conns[service]; ok {
+		return conn
+	}
+	conn, err := grpc.NewClient("passthrough:///"+service,
+		h.dialer(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(interceptors.UnaryClientErrorInterceptor()),
+		grpc.WithStreamInterceptor(interceptors.ClientStreamErrorInterceptor),
+	)
+	if err != nil {
+		t.Fatalf("dial %s: %v", service, err)
+	}
+	h.conns[service] = conn
+	return conn
+}
+
+func (h *Harness) waitReady(ctx context.Context, name, service string) error {
+	client := healthpb.NewHealthClient(h.conns[name])
+	for {
+		resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		if err == nil && resp.Status == healthpb.HealthCheckResponse_SERVING {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			if err == nil {
+				err = fmt.Errorf("%s is %s", service, resp.Status)
+			}
+			return err
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package testharness_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"grpc-test/domain"
+	"grpc-test/paymentservice"
+	pb "grpc-test/proto"
+	"grpc-test/server"
+	"grpc-test/testharness"
+	"grpc-test/webhook"
+
+	"github.com/revotech-group/go-lib/errors"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+func declineGateway() testharness.Option {
+	return testharness.WithGateway(paymentservice.GatewayFunc(
+		func(context.Context, *pb.ChargeRequest) (*pb.ChargeResponse, error) {
+			return nil, domain.ErrNotEnoughCredit()
+		}))
+}
+
+func TestPlaceOrder(t *testing.T) {
+	h := testharness.Start(t)
+	ctx := context.Background()
+
+	order, err := h.Order.PlaceOrder(ctx, &pb.OrderRequest{Product: "Laptop", Quantity: 2})
+	if err != nil {
+		t.Fatalf("PlaceOrder() error = %v", err)
+	}
+	if order.Status != pb.OrderStatus_ORDER_STATUS_PAID {
+		t.Errorf("status = %v, want PAID", order.Status)
+	}
+	got, err := h.Order.GetOrder(ctx, &pb.GetOrderRequest{Id: order.OrderId})
+	if err != nil {
+		t.Fatalf("GetOrder() error = %v", err)
+	}
+	if got.Product != "Laptop" || got.Quantity != 2 || got.Status != pb.OrderStatus_ORDER_STATUS_PAID {
+		t.Errorf("GetOrder() = %v, want the placed order", got)
+	}
+}
+
+func TestPlaceOrderNotEnoughCredit(t *testing.T) {
+	h := testharness.Start(t, declineGateway())
+
+	_, err := h.Order.PlaceOrder(context.Background(), &pb.OrderRequest{Product: "Laptop", Quantity: 1})
+	appErr, ok := err.(errors.AppError)
+	if !ok {
+		t.Fatalf("PlaceOrder() error = %v, want an AppError", err)
+	}
+	if _, ok := appErr.GetProtobufError().(*pb.ErrNotEnoughCharge); !ok {
+		t.Errorf("detail = %T, want *pb.ErrNotEnoughCharge", appErr.GetProtobufError())
+	}
+}
+
+func TestDeclinedOrderIsPaymentFailed(t *testing.T) {
+	h := testharness.Start(t, declineGateway())
+	ctx := context.Background()
+
+	if _, err := h.Order.PlaceOrder(ctx, &pb.OrderRequest{Product: "Laptop", Quantity: 1}); err == nil {
+		t.Fatal("PlaceOrder() succeeded, want a decline")
+	}
+	list, err := h.Order.ListOrders(ctx, &pb.ListOrdersRequest{})
+	if err != nil {
+		t.Fatalf("ListOrders() error = %v", err)
+	}
+	if len(list.Orders) != 1 {
+		t.Fatalf("ListOrders() returned %d orders, want 1", len(list.Orders))
+	}
+	if got := list.Orders[0].Status; got != pb.OrderStatus_ORDER_STATUS_PAYMENT_FAILED {
+		t.Errorf("status = %v, want PAYMENT_FAILED", got)
+	}
+}
+
+func TestCancelOrder(t *testing.T) {
+	h := testharness.Start(t)
+	ctx := context.Background()
+
+	order, err := h.Order.PlaceOrder(ctx, &pb.OrderRequest{Product: "Laptop", Quantity: 1})
+	if err != nil {
+		t.Fatalf("PlaceOrder() error = %v", err)
+	}
+	cancelled, err := h.Order.CancelOrder(ctx, &pb.CancelOrderRequest{Id: order.OrderId, Reason: "changed my mind"})
+	if err != nil {
+		t.Fatalf("CancelOrder() error = %v", err)
+	}
+	if cancelled.Status != pb.OrderStatus_ORDER_STATUS_CANCELLED {
+		t.Errorf("status = %v, want CANCELLED", cancelled.Status)
+	}
+	if want := "Cancelled: changed my mind"; cancelled.Message != want {
+		t.Errorf("message = %q, want %q", cancelled.Message, want)
+	}
+
+	if _, err := h.Order.CancelOrder(ctx, &pb.CancelOrderRequest{Id: order.OrderId}); err == nil {
+		t.Error("second CancelOrder() succeeded, want an error")
+	}
+	if _, err := h.Order.CancelOrder(ctx, &pb.CancelOrderRequest{Id: "missing"}); err == nil {
+		t.Error("CancelOrder() of an unknown order succeeded, want an error")
+	}
+}
+
+func TestDeclinedChargeIsPublished(t *testing.T) {
+	events := make(chan webhook.Event, 1)
+	receiver := httptest.NewServer(webhook.Receiver("s3cret", webhook.DefaultTolerance, func(e webhook.Event) error {
+		events <- e
+		return nil
+	}))
+	defer receiver.Close()
+	h := testharness.Start(t, declineGateway(), testharness.WithConfig(testharness.Payment, func(c *server.Config) {
+		c.Webhooks = webhook.Config{Enabled: true, AllowPrivate: true}
+	}))
+	ctx := context.Background()
+
+	webhooks := pb.NewWebhooksClient(h.Conn(t, testharness.Payment))
+	if _, err := webhooks.RegisterWebhook(ctx, &pb.RegisterWebhookRequest{Url: receiver.URL, Events: []string{"payment.*"}, Secret: "s3cret"}); err != nil {
+		t.Fatalf("RegisterWebhook() error = %v", err)
+	}
+	if _, err := h.Order.PlaceOrder(ctx, &pb.OrderRequest{Product: "Laptop", Quantity: 1}); err == nil {
+		t.Fatal("PlaceOrder() succeeded, want a decline")
+	}
+
+	select {
+	case e := <-events:
+		var data pb.PaymentEvent
+		if err := protojson.Unmarshal(e.Data, &data); err != nil {
+			t.Fatal(err)
+		}
+		if e.Type != webhook.PaymentDeclined || data.Reason != "ErrNotEnoughCharge" {
+			t.Errorf("event = %s with reason %q, want %s with reason ErrNotEnoughCharge", e.Type, data.Reason, webhook.PaymentDeclined)
+		}
+		if data.OrderId == "" || data.Amount != 100 {
+			t.Errorf("data = %v, want the order ID and amount of the charge", &data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event was delivered")
+	}
+}
@@ -0,0 +1,62 @@
+package tlsconfig
+
+import (
+	"context"
+	"slices"
+
+	"grpc-test/lib"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// PeerIdentity returns the service identity of the caller, the common name of
+// its verified client certificate.
+func PeerIdentity(ctx context.Context) (string, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "", false
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+	return info.State.VerifiedChains[0][0].Subject.CommonName, true
+}
+
+// UnaryPeerInterceptor rejects calls to methods in allowed from peers that are
+// not listed for them. Methods without an entry are open to every peer.
+func UnaryPeerInterceptor(allowed map[string][]string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := checkPeer(ctx, info.FullMethod, allowed); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamPeerInterceptor is UnaryPeerInterceptor for streaming RPCs.
+func StreamPeerInterceptor(allowed map[string][]string) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := checkPeer(ss.Context(), info.FullMethod, allowed); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+func checkPeer(ctx context.Context, method string, allowed map[string][]string) error {
+	peers, ok := allowed[method]
+	if !ok {
+		return nil
+	}
+	id, ok := PeerIdentity(ctx)
+	if !ok {
+		return lib.ErrUnauthorizedAccess().WithMessage("Client certificate required")
+	}
+	if !slices.Contains(peers, id) {
+		return lib.ErrForbidden().WithMessage("Peer " + id + " may not call " + method)
+	}
+	return nil
+}
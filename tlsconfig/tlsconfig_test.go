@@ -0,0 +1,127 @@
+package tlsconfig
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// authority is a CA issuing server certificates.
+type authority struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newAuthority(t *testing.T, name string) *authority {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &authority{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a server certificate for host.
+func (a *authority) issue(t *testing.T, host string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, a.cert, &key.PublicKey, a.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// handshake connects a client with creds to a server presenting cert.
+func handshake(creds credentials.TransportCredentials, authority string, cert tls.Certificate) error {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		tls.Server(server, &tls.Config{Certificates: []tls.Certificate{cert}, NextProtos: []string{"h2"}}).Handshake()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, _, err := creds.ClientHandshake(ctx, authority, client)
+	return err
+}
+
+func TestClientCredentialsReloadCAs(t *testing.T) {
+	first, second := newAuthority(t, "first"), newAuthority(t, "second")
+	cfg := Config{CAFile: filepath.Join(t.TempDir(), "ca.pem")}
+	if err := os.WriteFile(cfg.CAFile, first.pem, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	creds, err := ClientCredentials(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := handshake(creds, "payment:50052", first.issue(t, "payment")); err != nil {
+		t.Errorf("handshake with a trusted server = %v, want nil", err)
+	}
+	if err := handshake(creds, "payment:50052", second.issue(t, "payment")); err == nil {
+		t.Error("handshake with an untrusted server succeeded")
+	}
+	if err := handshake(creds, "order:50053", first.issue(t, "payment")); err == nil {
+		t.Error("handshake with a server of another name succeeded")
+	}
+
+	// Rotate the CA under the running client
+	if err := os.WriteFile(cfg.CAFile, second.pem, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(cfg.CAFile, later, later); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(checkInterval + 100*time.Millisecond)
+
+	if err := handshake(creds, "payment:50052", second.issue(t, "payment")); err != nil {
+		t.Errorf("handshake after rotation to the new CA = %v, want nil", err)
+	}
+	if err := handshake(creds, "payment:50052", first.issue(t, "payment")); err == nil {
+		t.Error("handshake after rotation with the old CA succeeded")
+	}
+}
@@ -0,0 +1,75 @@
+package tlsconfig
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// checkInterval bounds how often the files are stat'ed during handshakes.
+const checkInterval = time.Second
+
+// watched caches a value loaded from files and reloads it once any of them
+// has been modified. A failed reload keeps serving the previous value.
+type watched[T any] struct {
+	files []string
+	load  func() (T, error)
+
+	mu      sync.Mutex
+	value   T
+	loaded  bool
+	modTime time.Time
+	checked time.Time
+}
+
+func newWatched[T any](files []string, load func() (T, error)) *watched[T] {
+	return &watched[T]{files: files, load: load}
+}
+
+func (w *watched[T]) get() (T, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.loaded && time.Since(w.checked) < checkInterval {
+		return w.value, nil
+	}
+	w.checked = time.Now()
+
+	modTime, err := latestModTime(w.files)
+	if err == nil && w.loaded && modTime.Equal(w.modTime) {
+		return w.value, nil
+	}
+
+	v, loadErr := w.load()
+	if loadErr != nil {
+		if w.loaded {
+			slog.Error("Failed to reload TLS files, keeping the previous ones",
+				slog.String("files", strings.Join(w.files, ",")),
+				slog.Any("error", loadErr),
+			)
+			return w.value, nil
+		}
+		return v, loadErr
+	}
+	if w.loaded {
+		slog.Info("Reloaded TLS files", slog.String("files", strings.Join(w.files, ",")))
+	}
+	w.value, w.loaded, w.modTime = v, true, modTime
+	return v, nil
+}
+
+func latestModTime(files []string) (time.Time, error) {
+	var latest time.Time
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
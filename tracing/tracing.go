@@ -0,0 +1,144 @@
+// Package tracing sets up OpenTelemetry for the services. Trace context is
+// propagated through gRPC metadata using the W3C traceparent header, and spans
+// are exported locally to stdout and/or an OTLP JSON file so no collector is
+// required.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"grpc-test/lib"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+)
+
+// Span attributes shared by all services.
+const (
+	OrderID    = attribute.Key("app.order.id")
+	CustomerID = attribute.Key("app.customer.id")
+	ErrorName  = attribute.Key("app.error.name")
+)
+
+// Config selects the exporters. Tracing is disabled when none is set.
+type Config struct {
+	// Stdout pretty-prints finished spans to standard output.
+	Stdout bool `json:"stdout"`
+	// File appends spans as OTLP/JSON lines, the format written by the
+	// collector's file exporter.
+	File string `json:"file"`
+	// SampleRatio is the fraction of new traces to record, all of them when
+	// nil. Sampling decisions of incoming requests are respected.
+	SampleRatio *float64 `json:"sample_ratio"`
+}
+
+func (c Config) sampleRatio() float64 {
+	if c.SampleRatio == nil {
+		return 1
+	}
+	return *c.SampleRatio
+}
+
+func (c Config) enabled() bool {
+	return c.Stdout || c.File != ""
+}
+
+// Setup installs the global tracer provider and propagator for service. The
+// returned function flushes pending spans and must be called on exit.
+func Setup(ctx context.Context, service string, cfg Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if !cfg.enabled() {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(service)))
+	if err != nil {
+		return nil, fmt.Errorf("tracing resource: %w", err)
+	}
+
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.sampleRatio()))),
+	}
+	if cfg.Stdout {
+		exp, err := stdouttrace.New(stdouttrace.WithPrettyPrint())
+		if err != nil {
+			return nil, fmt.Errorf("stdout exporter: %w", err)
+		}
+		opts = append(opts, sdktrace.WithBatcher(exp))
+	}
+	if cfg.File != "" {
+		exp, err := otlptrace.New(ctx, &fileClient{path: cfg.File})
+		if err != nil {
+			return nil, fmt.Errorf("otlp file exporter: %w", err)
+		}
+		opts = append(opts, sdktrace.WithBatcher(exp))
+	}
+
+	tp := sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(tp)
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		fmt.Fprintf(os.Stderr, "tracing: %v\n", err)
+	}))
+	return tp.Shutdown, nil
+}
+
+// ServerOption creates a span for every incoming RPC, continuing the trace of
+// the caller when the request carries trace context.
+func ServerOption() grpc.ServerOption {
+	return grpc.StatsHandler(otelgrpc.NewServerHandler())
+}
+
+// DialOption creates a span for every outgoing RPC and injects its context
+// into the request metadata.
+func DialOption() grpc.DialOption {
+	return grpc.WithStatsHandler(otelgrpc.NewClientHandler())
+}
+
+// SetAttributes annotates the current span.
+func SetAttributes(ctx context.Context, attrs ...attribute.KeyValue) {
+	trace.SpanFromContext(ctx).SetAttributes(attrs...)
+}
+
+// UnaryServerInterceptor records the AppError returned by the handler on the
+// server span.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		resp, err := handler(ctx, req)
+		recordError(ctx, err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor is UnaryServerInterceptor for streaming RPCs.
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		err := handler(srv, ss)
+		recordError(ss.Context(), err)
+		return err
+	}
+}
+
+func recordError(ctx context.Context, err error) {
+	if err == nil {
+		return
+	}
+	span := trace.SpanFromContext(ctx)
+	if name := lib.NameOf(err); name != "" {
+		span.SetAttributes(ErrorName.String(name))
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -0,0 +1,105 @@
+// Package web lets browsers call the gRPC services directly. It serves the
+// Connect protocol and gRPC-Web next to native gRPC on the same port, over
+// HTTP/1.1 and HTTP/2, with CORS.
+//
+// Requests in other protocols are transcoded to gRPC and handled by the
+// grpc.Server, so they go through the same interceptors. Error details, such
+// as ErrNotEnoughCharge, reach browsers in the protocol's standard form:
+// "details" of the Connect JSON error body, or the grpc-status-details-bin
+// trailer for gRPC-Web. Both decode with the generated service.proto types.
+package web
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"grpc-test/lib"
+	"grpc-test/logging"
+
+	"connectrpc.com/vanguard/vanguardgrpc"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+)
+
+// Config enables the browser protocols and sets the CORS policy.
+type Config struct {
+	Enabled bool `json:"enabled"`
+	// AllowedOrigins lists the origins allowed to call with credentials. "*"
+	// allows any other origin to call without them.
+	AllowedOrigins []string `json:"allowed_origins"`
+	// MaxAge is how long browsers may cache preflight responses.
+	MaxAge lib.Duration `json:"max_age"`
+}
+
+var (
+	allowedMethods = []string{http.MethodGet, http.MethodPost}
+	allowedHeaders = []string{
+		"Content-Type", "Authorization", logging.RequestIDHeader,
+		"Connect-Protocol-Version", "Connect-Timeout-Ms", "Connect-Accept-Encoding", "Connect-Content-Encoding",
+		"Grpc-Timeout", "X-Grpc-Web", "X-User-Agent",
+	}
+	exposedHeaders = []string{
+		"Grpc-Status", "Grpc-Message", "Grpc-Status-Details-Bin", logging.RequestIDHeader,
+		"Content-Encoding", "Connect-Content-Encoding",
+	}
+)
+
+// Handler serves srv in every protocol. Services must be registered on srv
+// before it is called.
+func Handler(srv *grpc.Server, cfg Config) (http.Handler, error) {
+	transcoder, err := vanguardgrpc.NewTranscoder(srv)
+	if err != nil {
+		return nil, err
+	}
+	return h2c.NewHandler(cors(cfg, transcoder), &http2.Server{}), nil
+}
+
+// cors answers preflight requests and adds the CORS headers for allowed
+// origins. Requests without an Origin header are not affected.
+func cors(cfg Config, h http.Handler) http.Handler {
+	methods := strings.Join(allowedMethods, ", ")
+	headers := strings.Join(allowedHeaders, ", ")
+	exposed := strings.Join(exposedHeaders, ", ")
+	maxAge := strconv.Itoa(int(time.Duration(cfg.MaxAge).Seconds()))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		// Only listed origins may send credentials. "*" lets any site call
+		// without cookies or HTTP authentication.
+		switch {
+		case slices.Contains(cfg.AllowedOrigins, origin):
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		case slices.Contains(cfg.AllowedOrigins, "*"):
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		default:
+			if r.Method == http.MethodOptions {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", methods)
+			w.Header().Set("Access-Control-Allow-Headers", headers)
+			if cfg.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", maxAge)
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Access-Control-Expose-Headers", exposed)
+		h.ServeHTTP(w, r)
+	})
+}
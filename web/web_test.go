@@ -0,0 +1,29 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORS(t *testing.T) {
+	h := cors(Config{AllowedOrigins: []string{"https://app.example", "*"}}, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	tests := []struct {
+		origin, allowOrigin, allowCredentials string
+	}{
+		{"https://app.example", "https://app.example", "true"},
+		{"https://evil.example", "*", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/service.Order/PlaceOrder", nil)
+		r.Header.Set("Origin", tt.origin)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.allowOrigin {
+			t.Errorf("%s: Access-Control-Allow-Origin = %q, want %q", tt.origin, got, tt.allowOrigin)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Credentials"); got != tt.allowCredentials {
+			t.Errorf("%s: Access-Control-Allow-Credentials = %q, want %q", tt.origin, got, tt.allowCredentials)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrPrivateDestination is returned for webhooks that point, or resolve, to
+// an address that is not public, unless Config.AllowPrivate is set.
+var ErrPrivateDestination = errors.New("webhook destination is not a public address")
+
+// sharedAddressSpace is the carrier-grade NAT range, not covered by
+// netip.Addr.IsPrivate.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// public reports whether addr may receive deliveries: loopback, private,
+// link-local (such as the 169.254.169.254 metadata endpoint), multicast and
+// unspecified addresses may not.
+func public(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedAddressSpace.Contains(addr)
+}
+
+// checkHost rejects hosts that are non-public IP literals or localhost. Names
+// are checked again once resolved, at every dial.
+func checkHost(host string) error {
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("%w: %s", ErrPrivateDestination, host)
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && !public(addr) {
+		return fmt.Errorf("%w: %s", ErrPrivateDestination, host)
+	}
+	return nil
+}
+
+// newTransport returns the transport of the deliveries. Unless allowPrivate,
+// it refuses to connect to non-public addresses after DNS resolution, so that
+// a name resolving to an internal host is caught too. Proxies are not used,
+// as the check would then apply to the proxy instead of the endpoint.
+func newTransport(allowPrivate bool) *http.Transport {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if !allowPrivate {
+		dialer.Control = func(_, address string, _ syscall.RawConn) error {
+			ap, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !public(ap.Addr()) {
+				return fmt.Errorf("%w: %s", ErrPrivateDestination, ap.Addr())
+			}
+			return nil
+		}
+	}
+	return &http.Transport{
+		DialContext:         dialer.DialContext,
+		ForceAttemptHTTP2:   true,
+		MaxIdleConns:        100,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+}
@@ -0,0 +1,84 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"grpc-test/clock"
+	"grpc-test/random"
+)
+
+func TestPublic(t *testing.T) {
+	tests := map[string]bool{
+		"93.184.216.34":    true,
+		"2606:4700::1111":  true,
+		"127.0.0.1":        false,
+		"::1":              false,
+		"10.1.2.3":         false,
+		"172.16.0.1":       false,
+		"192.168.1.1":      false,
+		"169.254.169.254":  false,
+		"fe80::1":          false,
+		"100.64.0.1":       false,
+		"0.0.0.0":          false,
+		"::ffff:127.0.0.1": false,
+		"224.0.0.1":        false,
+	}
+	for addr, want := range tests {
+		if got := public(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("public(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestRegisterRefusesPrivateDestinations(t *testing.T) {
+	d := NewDispatcher(Config{}, clock.NewFake(time.Unix(0, 0)), random.New(1))
+	for _, url := range []string{
+		"http://localhost:8090/",
+		"http://127.0.0.1/",
+		"http://169.254.169.254/latest/meta-data/",
+		"https://[::1]:8443/",
+	} {
+		if _, err := d.Register(Webhook{URL: url}); !errors.Is(err, ErrPrivateDestination) {
+			t.Errorf("Register(%s) error = %v, want ErrPrivateDestination", url, err)
+		}
+	}
+
+	d = NewDispatcher(Config{AllowPrivate: true}, clock.NewFake(time.Unix(0, 0)), random.New(1))
+	if _, err := d.Register(Webhook{URL: "http://localhost:8090/"}); err != nil {
+		t.Errorf("Register() with AllowPrivate error = %v", err)
+	}
+}
+
+func TestRegisterCapsWebhooksPerOwner(t *testing.T) {
+	d := NewDispatcher(Config{MaxPerOwner: 2}, clock.NewFake(time.Unix(0, 0)), random.New(1))
+	for i := 0; i < 2; i++ {
+		if _, err := d.Register(Webhook{URL: "https://example.com/hook", Owner: "alice"}); err != nil {
+			t.Fatalf("Register() error = %v", err)
+		}
+	}
+	if _, err := d.Register(Webhook{URL: "https://example.com/hook", Owner: "alice"}); !errors.Is(err, ErrTooManyWebhooks) {
+		t.Errorf("third Register() error = %v, want ErrTooManyWebhooks", err)
+	}
+	if _, err := d.Register(Webhook{URL: "https://example.com/hook", Owner: "bob"}); err != nil {
+		t.Errorf("Register() of another owner error = %v", err)
+	}
+}
+
+func TestAttemptRefusesPrivateAddressAtDial(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		t.Error("the private endpoint was reached")
+	}))
+	defer srv.Close()
+
+	d := NewDispatcher(Config{}, clock.Real, random.New(1))
+	a, retryable := d.attempt(context.Background(), srv.URL, "secret", "d1", OrderPaid, []byte("{}"))
+	if a.Error == "" || retryable {
+		t.Errorf("attempt() = %+v, retryable %v, want a refused, final attempt", a, retryable)
+	}
+}
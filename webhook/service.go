@@ -0,0 +1,151 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"grpc-test/authz"
+	"grpc-test/lib"
+	"grpc-test/logging"
+	pb "grpc-test/proto"
+
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Service implements pb.WebhooksServer on top of a Dispatcher.
+type Service struct {
+	pb.UnimplementedWebhooksServer
+	d *Dispatcher
+}
+
+func NewService(d *Dispatcher) *Service {
+	return &Service{d: d}
+}
+
+func (s *Service) RegisterWebhook(ctx context.Context, req *pb.RegisterWebhookRequest) (*pb.Webhook, error) {
+	w := Webhook{URL: req.Url, Events: req.Events, Secret: req.Secret, Owner: "anonymous"}
+	if p, ok := authz.FromContext(ctx); ok {
+		w.CustomerID, w.Owner = p.CustomerID, p.String()
+	}
+	w, err := s.d.Register(w)
+	if errors.Is(err, ErrTooManyWebhooks) {
+		return nil, lib.ErrTooManyRequests().WithMessage(err.Error())
+	}
+	if err != nil {
+		return nil, lib.ErrBadRequest().WithMessage(err.Error())
+	}
+	logging.FromContext(ctx).Info("Webhook registered", slog.String("webhook_id", w.ID), slog.String("url", w.URL), slog.Any("events", w.Events))
+	resp := webhookToProto(w)
+	resp.Secret = w.Secret
+	return resp, nil
+}
+
+func (s *Service) ListWebhooks(ctx context.Context, _ *pb.Empty) (*pb.WebhookList, error) {
+	resp := &pb.WebhookList{}
+	for _, w := range s.d.Webhooks() {
+		if visible(ctx, w.CustomerID) {
+			resp.Webhooks = append(resp.Webhooks, webhookToProto(w))
+		}
+	}
+	return resp, nil
+}
+
+func (s *Service) DeleteWebhook(ctx context.Context, req *pb.DeleteWebhookRequest) (*pb.Empty, error) {
+	if _, err := s.lookup(ctx, req.Id); err != nil {
+		return nil, err
+	}
+	s.d.Delete(req.Id)
+	logging.FromContext(ctx).Info("Webhook deleted", slog.String("webhook_id", req.Id))
+	return &pb.Empty{}, nil
+}
+
+func (s *Service) ListDeliveries(ctx context.Context, req *pb.ListDeliveriesRequest) (*pb.WebhookDeliveries, error) {
+	switch req.State {
+	case "", Pending, Delivered, Dead:
+	default:
+		return nil, lib.ErrBadRequest().WithMessage("state must be pending, delivered or dead, got " + req.State)
+	}
+	deliveries := s.d.Deliveries(func(dl *Delivery) bool {
+		return visible(ctx, dl.CustomerID) &&
+			(req.WebhookId == "" || dl.WebhookID == req.WebhookId) &&
+			(req.State == "" || dl.State == req.State)
+	})
+	limit := int(req.Limit)
+	if limit <= 0 {
+		limit = 100
+	}
+	if len(deliveries) > limit {
+		deliveries = deliveries[len(deliveries)-limit:]
+	}
+
+	resp := &pb.WebhookDeliveries{}
+	for _, dl := range deliveries {
+		resp.Deliveries = append(resp.Deliveries, deliveryToProto(dl))
+	}
+	return resp, nil
+}
+
+func (s *Service) Redeliver(ctx context.Context, req *pb.RedeliverRequest) (*pb.WebhookDelivery, error) {
+	found := s.d.Deliveries(func(dl *Delivery) bool { return dl.ID == req.Id && visible(ctx, dl.CustomerID) })
+	if len(found) == 0 {
+		return nil, lib.ErrNotFound().WithMessage("Delivery " + req.Id + " not found")
+	}
+	dl, err := s.d.Redeliver(req.Id)
+	if err != nil {
+		return nil, lib.ErrBadRequest().WithMessage(err.Error())
+	}
+	logging.FromContext(ctx).Info("Webhook delivery requeued", slog.String("delivery_id", dl.ID), slog.String("webhook_id", dl.WebhookID))
+	return deliveryToProto(dl), nil
+}
+
+// lookup returns a webhook the caller may see.
+func (s *Service) lookup(ctx context.Context, id string) (Webhook, error) {
+	w, ok := s.d.Webhook(id)
+	if !ok || !visible(ctx, w.CustomerID) {
+		return Webhook{}, lib.ErrNotFound().WithMessage("Webhook " + id + " not found")
+	}
+	return w, nil
+}
+
+// visible reports whether the caller may see the webhooks and deliveries of a
+// customer: callers that act for a customer only see their own.
+func visible(ctx context.Context, customerID string) bool {
+	p, ok := authz.FromContext(ctx)
+	return !ok || p.CustomerID == "" || customerID == p.CustomerID
+}
+
+// webhookToProto converts w without its secret.
+func webhookToProto(w Webhook) *pb.Webhook {
+	return &pb.Webhook{
+		Id:         w.ID,
+		Url:        w.URL,
+		Events:     w.Events,
+		CustomerId: w.CustomerID,
+		Owner:      w.Owner,
+		Created:    timestamppb.New(w.Created),
+	}
+}
+
+func deliveryToProto(dl Delivery) *pb.WebhookDelivery {
+	resp := &pb.WebhookDelivery{
+		Id:        dl.ID,
+		WebhookId: dl.WebhookID,
+		EventId:   dl.Event.ID,
+		EventType: dl.Event.Type,
+		State:     dl.State,
+	}
+	if dl.State == Pending {
+		resp.NextAttempt = timestamppb.New(dl.NextAttempt)
+	}
+	for _, a := range dl.Attempts {
+		resp.Attempts = append(resp.Attempts, &pb.WebhookDeliveryAttempt{
+			Time:       timestamppb.New(a.Time),
+			StatusCode: int32(a.StatusCode),
+			Error:      a.Error,
+			Duration:   durationpb.New(a.Duration),
+		})
+	}
+	return resp
+}
@@ -0,0 +1,561 @@
+// Package webhook pushes events to the HTTP endpoints partners register, so
+// that they no longer poll for changes. Every delivery is a signed POST (see
+// Sign) retried with exponential backoff until the endpoint answers 2xx or the
+// attempts run out, after which the delivery is kept as a dead letter until it
+// is redelivered. Webhooks and deliveries are kept in memory, within bounds.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"sync"
+	"time"
+
+	"grpc-test/clock"
+	"grpc-test/lib"
+	"grpc-test/logging"
+	"grpc-test/metrics"
+	"grpc-test/random"
+
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Config of the dispatcher. Zero values take the defaults noted.
+type Config struct {
+	// Enabled serves the Webhooks service and delivers events.
+	Enabled bool `json:"enabled"`
+	// MaxAttempts per delivery before it becomes a dead letter, 5 if 0.
+	MaxAttempts int `json:"max_attempts"`
+	// InitialBackoff is the base of the wait before the second attempt, 1s if
+	// 0. It doubles with every attempt up to MaxBackoff, 5m if 0.
+	InitialBackoff lib.Duration `json:"initial_backoff"`
+	MaxBackoff     lib.Duration `json:"max_backoff"`
+	// Timeout of one attempt, 10s if 0.
+	Timeout lib.Duration `json:"timeout"`
+	// MaxPerOwner is the number of webhooks one caller may register, 10 if 0.
+	MaxPerOwner int `json:"max_per_owner"`
+	// MaxPending is the number of deliveries one webhook may have pending,
+	// 100 if 0. Events beyond it become dead letters right away.
+	MaxPending int `json:"max_pending"`
+	// AllowPrivate allows loopback, private and link-local destinations, for
+	// local development. They are refused by default, so that registering a
+	// webhook cannot reach internal hosts.
+	AllowPrivate bool `json:"allow_private"`
+}
+
+func (c Config) withDefaults() Config {
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = 5
+	}
+	if c.InitialBackoff <= 0 {
+		c.InitialBackoff = lib.Duration(time.Second)
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = lib.Duration(5 * time.Minute)
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = lib.Duration(10 * time.Second)
+	}
+	if c.MaxPerOwner <= 0 {
+		c.MaxPerOwner = 10
+	}
+	if c.MaxPending <= 0 {
+		c.MaxPending = 100
+	}
+	return c
+}
+
+// Event types.
+const (
+	OrderPaid          = "order.paid"
+	OrderPaymentFailed = "order.payment_failed"
+	OrderCancelled     = "order.cancelled"
+	PaymentCharged     = "payment.charged"
+	PaymentDeclined    = "payment.declined"
+)
+
+// Events are the event types webhooks can subscribe to. Each service
+// publishes its own: order.* on Order, payment.* on Charge.
+var Events = []string{OrderPaid, OrderPaymentFailed, OrderCancelled, PaymentCharged, PaymentDeclined}
+
+// States of a delivery.
+const (
+	Pending   = "pending"
+	Delivered = "delivered"
+	Dead      = "dead"
+)
+
+// maxKept is the number of deliveries kept for inspection. Beyond it the
+// oldest delivered ones are dropped, then the oldest dead letters. Pending
+// deliveries are bounded by Config.MaxPending instead.
+const maxKept = 1000
+
+// Event is the JSON body of a delivery.
+type Event struct {
+	ID   string    `json:"id"`
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	// CustomerID is the customer the event is about. Webhooks registered by a
+	// customer only receive the events of that customer.
+	CustomerID string          `json:"customer_id,omitempty"`
+	Data       json.RawMessage `json:"data"`
+}
+
+// Webhook is a registered endpoint.
+type Webhook struct {
+	ID  string
+	URL string
+	// Events are the types delivered, as path.Match patterns such as
+	// "order.*". Empty subscribes to every event.
+	Events []string
+	Secret string
+	// CustomerID restricts the webhook to the events of a customer.
+	CustomerID string
+	Owner      string
+	Created    time.Time
+}
+
+// Subscribed reports whether e is delivered to w.
+func (w *Webhook) Subscribed(e Event) bool {
+	if w.CustomerID != "" && e.CustomerID != w.CustomerID {
+		return false
+	}
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, pattern := range w.Events {
+		if ok, _ := path.Match(pattern, e.Type); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Delivery is an event on its way to a webhook.
+type Delivery struct {
+	ID          string
+	WebhookID   string
+	CustomerID  string
+	Event       Event
+	State       string
+	Attempts    []Attempt
+	NextAttempt time.Time
+
+	body []byte
+	// tries counts the attempts since the delivery was last (re)queued.
+	tries int
+}
+
+// Attempt is one POST of a delivery.
+type Attempt struct {
+	Time time.Time
+	// StatusCode is the HTTP status answered, 0 if there was no response.
+	StatusCode int
+	Error      string
+	Duration   time.Duration
+}
+
+// Dispatcher delivers published events to the subscribed webhooks. A nil
+// Dispatcher publishes nothing.
+type Dispatcher struct {
+	cfg    Config
+	client *http.Client
+	clock  clock.Clock
+	rand   random.Rand
+	wg     sync.WaitGroup
+
+	mu         sync.Mutex
+	ctx        context.Context // set by Run, deliveries wait for it
+	webhooks   map[string]*Webhook
+	deliveries map[string]*Delivery
+	order      []string       // delivery IDs, oldest first
+	pending    map[string]int // pending deliveries by webhook ID
+}
+
+// NewDispatcher returns a dispatcher that draws IDs and backoff jitter from
+// rng. Nothing is delivered until Run is called.
+func NewDispatcher(cfg Config, clk clock.Clock, rng random.Rand) *Dispatcher {
+	cfg = cfg.withDefaults()
+	return &Dispatcher{
+		cfg: cfg,
+		client: &http.Client{
+			Timeout:   time.Duration(cfg.Timeout),
+			Transport: newTransport(cfg.AllowPrivate),
+			// A redirect is an answer of the endpoint, not followed.
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		clock:      clk,
+		rand:       rng,
+		webhooks:   map[string]*Webhook{},
+		deliveries: map[string]*Delivery{},
+		pending:    map[string]int{},
+	}
+}
+
+// Register adds a webhook, generating its ID and, if empty, its secret.
+func (d *Dispatcher) Register(w Webhook) (Webhook, error) {
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Webhook{}, fmt.Errorf("invalid webhook URL %q: expected an absolute http or https URL", w.URL)
+	}
+	if !d.cfg.AllowPrivate {
+		if err := checkHost(u.Hostname()); err != nil {
+			return Webhook{}, err
+		}
+	}
+	for _, pattern := range w.Events {
+		if !slices.ContainsFunc(Events, func(e string) bool { ok, _ := path.Match(pattern, e); return ok }) {
+			return Webhook{}, fmt.Errorf("event %q matches none of %v", pattern, Events)
+		}
+	}
+	if w.Secret == "" {
+		if w.Secret, err = newSecret(); err != nil {
+			return Webhook{}, err
+		}
+	}
+	w.Events = slices.Clone(w.Events)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	owned := 0
+	for _, o := range d.webhooks {
+		if o.Owner == w.Owner {
+			owned++
+		}
+	}
+	if owned >= d.cfg.MaxPerOwner {
+		return Webhook{}, fmt.Errorf("%w: %s has %d", ErrTooManyWebhooks, w.Owner, owned)
+	}
+	w.ID = d.newID()
+	w.Created = d.clock.Now()
+	d.webhooks[w.ID] = &w
+	return w, nil
+}
+
+// Webhooks returns the registered webhooks, oldest first.
+func (d *Dispatcher) Webhooks() []Webhook {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	webhooks := make([]Webhook, 0, len(d.webhooks))
+	for _, w := range d.webhooks {
+		webhooks = append(webhooks, *w)
+	}
+	slices.SortFunc(webhooks, func(a, b Webhook) int { return a.Created.Compare(b.Created) })
+	return webhooks
+}
+
+// Webhook returns the webhook with the given ID.
+func (d *Dispatcher) Webhook(id string) (Webhook, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	w, ok := d.webhooks[id]
+	if !ok {
+		return Webhook{}, false
+	}
+	return *w, true
+}
+
+// Delete removes a webhook and its dead letters, which could no longer be
+// redelivered. Its pending deliveries are dropped at their next attempt.
+func (d *Dispatcher) Delete(id string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, ok := d.webhooks[id]
+	delete(d.webhooks, id)
+	d.drop(func(dl *Delivery) bool { return dl.WebhookID == id && dl.State == Dead }, len(d.order))
+	return ok
+}
+
+// Deliveries returns the deliveries accepted by keep, oldest first.
+func (d *Dispatcher) Deliveries(keep func(*Delivery) bool) []Delivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var deliveries []Delivery
+	for _, id := range d.order {
+		if dl := d.deliveries[id]; keep(dl) {
+			c := *dl
+			c.Attempts = slices.Clone(dl.Attempts)
+			deliveries = append(deliveries, c)
+		}
+	}
+	return deliveries
+}
+
+// Redeliver queues a dead letter again for up to MaxAttempts attempts.
+func (d *Dispatcher) Redeliver(id string) (Delivery, error) {
+	d.mu.Lock()
+	dl, ok := d.deliveries[id]
+	if !ok {
+		d.mu.Unlock()
+		return Delivery{}, ErrNoDelivery
+	}
+	if dl.State != Dead {
+		d.mu.Unlock()
+		return Delivery{}, fmt.Errorf("delivery %s is %s, only dead letters can be redelivered", id, dl.State)
+	}
+	if _, ok := d.webhooks[dl.WebhookID]; !ok {
+		d.mu.Unlock()
+		return Delivery{}, fmt.Errorf("webhook %s of delivery %s was deleted", dl.WebhookID, id)
+	}
+	if d.pending[dl.WebhookID] >= d.cfg.MaxPending {
+		d.mu.Unlock()
+		return Delivery{}, fmt.Errorf("webhook %s has %d deliveries pending, the most allowed", dl.WebhookID, d.pending[dl.WebhookID])
+	}
+	dl.State, dl.tries, dl.NextAttempt = Pending, 0, d.clock.Now()
+	d.pending[dl.WebhookID]++
+	c := *dl
+	c.Attempts = slices.Clone(dl.Attempts)
+	ctx := d.ctx
+	d.mu.Unlock()
+
+	if ctx != nil {
+		d.start(ctx, id)
+	}
+	return c, nil
+}
+
+// ErrTooManyWebhooks is returned by Register once the owner has
+// Config.MaxPerOwner webhooks.
+var ErrTooManyWebhooks = errors.New("too many webhooks")
+
+// ErrNoDelivery is returned for unknown delivery IDs.
+var ErrNoDelivery = errors.New("no such delivery")
+
+// Publish delivers an event of type typ about a customer to every subscribed
+// webhook. data is encoded with protojson.
+func (d *Dispatcher) Publish(ctx context.Context, typ, customerID string, data proto.Message) {
+	if d == nil {
+		return
+	}
+	payload, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(data)
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to encode webhook event", slog.String("event", typ), slog.Any("error", err))
+		return
+	}
+
+	d.mu.Lock()
+	e := Event{ID: d.newID(), Type: typ, Time: d.clock.Now().UTC(), CustomerID: customerID, Data: payload}
+	body, _ := json.Marshal(e)
+	var ids []string
+	for _, w := range d.webhooks {
+		if !w.Subscribed(e) {
+			continue
+		}
+		dl := &Delivery{ID: d.newID(), WebhookID: w.ID, CustomerID: w.CustomerID, Event: e, State: Pending, NextAttempt: e.Time, body: body}
+		d.deliveries[dl.ID] = dl
+		d.order = append(d.order, dl.ID)
+		// A webhook that keeps failing must not hold every event in memory
+		if d.pending[w.ID] >= d.cfg.MaxPending {
+			dl.State = Dead
+			dl.Attempts = []Attempt{{Time: e.Time, Error: "too many deliveries pending"}}
+			metrics.WebhookDelivery(typ, Dead)
+			logging.FromContext(ctx).Warn("Webhook has too many deliveries pending, kept as dead letter", slog.String("webhook_id", w.ID), slog.String("event", typ))
+			continue
+		}
+		d.pending[w.ID]++
+		ids = append(ids, dl.ID)
+	}
+	d.trim()
+	run := d.ctx
+	d.mu.Unlock()
+
+	if run != nil {
+		for _, id := range ids {
+			d.start(run, id)
+		}
+	}
+}
+
+// Run delivers events until ctx is cancelled. Deliveries still pending then
+// are not attempted again.
+func (d *Dispatcher) Run(ctx context.Context) {
+	d.mu.Lock()
+	d.ctx = ctx
+	var pending []string
+	for _, id := range d.order {
+		if d.deliveries[id].State == Pending {
+			pending = append(pending, id)
+		}
+	}
+	d.mu.Unlock()
+
+	for _, id := range pending {
+		d.start(ctx, id)
+	}
+	<-ctx.Done()
+	d.wg.Wait()
+}
+
+func (d *Dispatcher) start(ctx context.Context, id string) {
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		d.deliver(ctx, id)
+	}()
+}
+
+// deliver attempts a delivery until it is delivered or dead.
+func (d *Dispatcher) deliver(ctx context.Context, id string) {
+	for ctx.Err() == nil {
+		d.mu.Lock()
+		dl := d.deliveries[id]
+		w, ok := d.webhooks[dl.WebhookID]
+		var url, secret string
+		if ok {
+			url, secret = w.URL, w.Secret
+		}
+		body, typ := dl.body, dl.Event.Type
+		d.mu.Unlock()
+
+		if !ok {
+			d.mu.Lock()
+			d.settled(dl.WebhookID)
+			d.drop(func(c *Delivery) bool { return c == dl }, 1)
+			d.mu.Unlock()
+			return
+		}
+		a, retryable := d.attempt(ctx, url, secret, id, typ, body)
+		if ctx.Err() != nil {
+			return
+		}
+		wait, done := d.record(ctx, id, a, retryable)
+		if done {
+			return
+		}
+
+		timer := d.clock.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C():
+		}
+	}
+}
+
+// attempt POSTs body once and reports whether a failure is worth retrying:
+// errors without a response, timeouts, 429 and 5xx answers are, refused
+// destinations are not.
+func (d *Dispatcher) attempt(ctx context.Context, url, secret, deliveryID, typ string, body []byte) (a Attempt, retryable bool) {
+	a.Time = d.clock.Now()
+	defer func() { a.Duration = d.clock.Since(a.Time) }()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		a.Error = err.Error()
+		return a, false
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "grpc-test-webhooks")
+	req.Header.Set(EventHeader, typ)
+	req.Header.Set(DeliveryHeader, deliveryID)
+	req.Header.Set(SignatureHeader, Sign(secret, a.Time, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		a.Error = err.Error()
+		return a, !errors.Is(err, ErrPrivateDestination)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	a.StatusCode = resp.StatusCode
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return a, false
+	}
+	a.Error = resp.Status
+	code := resp.StatusCode
+	return a, code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= 500
+}
+
+// record adds an attempt to a delivery and returns the wait before the next
+// one, or done once the delivery is delivered or dead.
+func (d *Dispatcher) record(ctx context.Context, id string, a Attempt, retryable bool) (wait time.Duration, done bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	dl := d.deliveries[id]
+	dl.Attempts = append(dl.Attempts, a)
+	dl.tries++
+	logger := logging.FromContext(ctx).With(slog.String("delivery_id", id), slog.String("webhook_id", dl.WebhookID), slog.String("event", dl.Event.Type), slog.Int("attempt", len(dl.Attempts)))
+
+	switch {
+	case a.Error == "":
+		dl.State = Delivered
+		d.settled(dl.WebhookID)
+		metrics.WebhookDelivery(dl.Event.Type, Delivered)
+		logger.Debug("Webhook delivered", slog.Int("status", a.StatusCode), slog.Duration("duration", a.Duration))
+		return 0, true
+	case !retryable || dl.tries >= d.cfg.MaxAttempts:
+		dl.State = Dead
+		d.settled(dl.WebhookID)
+		metrics.WebhookDelivery(dl.Event.Type, Dead)
+		logger.Warn("Webhook delivery failed, kept as dead letter", slog.String("error", a.Error))
+		return 0, true
+	}
+
+	// Full jitter, as for retried calls
+	backoff := min(float64(d.cfg.InitialBackoff)*float64(uint64(1)<<min(dl.tries-1, 32)), float64(d.cfg.MaxBackoff))
+	wait = time.Duration(d.rand.Int64N(int64(backoff) + 1))
+	dl.NextAttempt = d.clock.Now().Add(wait)
+	metrics.WebhookDelivery(dl.Event.Type, "retry")
+	logger.Info("Webhook delivery failed, retrying", slog.String("error", a.Error), slog.Duration("backoff", wait))
+	return wait, false
+}
+
+// trim drops the oldest delivered deliveries beyond maxKept, and then the
+// oldest dead letters.
+func (d *Dispatcher) trim() {
+	for _, state := range []string{Delivered, Dead} {
+		excess := len(d.order) - maxKept
+		if excess <= 0 {
+			return
+		}
+		d.drop(func(dl *Delivery) bool { return dl.State == state }, excess)
+	}
+}
+
+// settled counts out a delivery of a webhook that is no longer pending.
+func (d *Dispatcher) settled(webhookID string) {
+	if d.pending[webhookID]--; d.pending[webhookID] <= 0 {
+		delete(d.pending, webhookID)
+	}
+}
+
+// drop removes up to n of the oldest deliveries that match.
+func (d *Dispatcher) drop(match func(*Delivery) bool, n int) {
+	d.order = slices.DeleteFunc(d.order, func(id string) bool {
+		if n > 0 && match(d.deliveries[id]) {
+			delete(d.deliveries, id)
+			n--
+			return true
+		}
+		return false
+	})
+}
+
+func (d *Dispatcher) newID() string {
+	return uuid.Must(uuid.NewRandomFromReader(d.rand)).String()
+}
+
+// newSecret returns a signing secret. Unlike IDs, secrets are not drawn from
+// the seeded generator, which would make them predictable.
+func newSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate webhook secret: %w", err)
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
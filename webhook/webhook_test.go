@@ -0,0 +1,89 @@
+package webhook
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"grpc-test/clock"
+	"grpc-test/random"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// newTestDispatcher returns a dispatcher that is not running, so that
+// published deliveries stay pending, with one webhook registered.
+func newTestDispatcher(t *testing.T, cfg Config) (*Dispatcher, Webhook) {
+	cfg.AllowPrivate = true
+	d := NewDispatcher(cfg, clock.NewFake(time.Unix(0, 0)), random.New(1))
+	w, err := d.Register(Webhook{URL: "http://localhost:8090/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d, w
+}
+
+func count(d *Dispatcher, state string) int {
+	return len(d.Deliveries(func(dl *Delivery) bool { return dl.State == state }))
+}
+
+func TestPublishCapsPendingDeliveries(t *testing.T) {
+	d, _ := newTestDispatcher(t, Config{MaxPending: 2})
+	for range 5 {
+		d.Publish(context.Background(), OrderPaid, "12345", wrapperspb.String("order"))
+	}
+	if got := count(d, Pending); got != 2 {
+		t.Errorf("pending = %d, want 2", got)
+	}
+	if got := count(d, Dead); got != 3 {
+		t.Errorf("dead = %d, want 3", got)
+	}
+
+	dead := d.Deliveries(func(dl *Delivery) bool { return dl.State == Dead })
+	if _, err := d.Redeliver(dead[0].ID); err == nil {
+		t.Error("Redeliver() beyond MaxPending succeeded")
+	}
+}
+
+func TestTrimDropsOldestDeadLetters(t *testing.T) {
+	d, _ := newTestDispatcher(t, Config{MaxPending: 1})
+	clk := d.clock.(*clock.Fake)
+	for range maxKept + 100 {
+		d.Publish(context.Background(), OrderPaid, "12345", wrapperspb.String("order"))
+		clk.Advance(time.Second)
+	}
+	all := d.Deliveries(func(*Delivery) bool { return true })
+	if len(all) != maxKept {
+		t.Fatalf("deliveries = %d, want %d", len(all), maxKept)
+	}
+	// The pending delivery of the first event is kept, the dead letters of
+	// the 100 events after it were dropped
+	if all[0].State != Pending {
+		t.Errorf("oldest delivery is %s, want pending", all[0].State)
+	}
+	if got, want := all[1].Event.Time, time.Unix(101, 0).UTC(); !got.Equal(want) {
+		t.Errorf("oldest dead letter at %v, want %v", got, want)
+	}
+}
+
+func TestDeleteDropsDeadLetters(t *testing.T) {
+	d, w := newTestDispatcher(t, Config{MaxPending: 1})
+	other, err := d.Register(Webhook{URL: "http://localhost:8091/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		d.Publish(context.Background(), OrderPaid, "12345", wrapperspb.String("order"))
+	}
+	if !d.Delete(w.ID) {
+		t.Fatal("Delete() = false, want true")
+	}
+	for _, dl := range d.Deliveries(func(dl *Delivery) bool { return dl.State == Dead }) {
+		if dl.WebhookID != other.ID {
+			t.Errorf("dead letter %s of the deleted webhook was kept", dl.ID)
+		}
+	}
+	if got := count(d, Dead); got != 2 {
+		t.Errorf("dead = %d, want the 2 of the other webhook", got)
+	}
+}